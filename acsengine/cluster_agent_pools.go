@@ -0,0 +1,404 @@
+package acsengine
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/Azure/terraform-provider-acsengine/internal/operations"
+	"github.com/hashicorp/terraform/helper/hashcode"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// Agent pools are a set keyed by name, so the order of `agent_pool_profiles` doesn't matter and pools can
+// be added and removed in place. The first pool in the api model is the primary pool acs-engine configures
+// the Kubernetes cloud provider with, so it can't be removed without recreating the cluster.
+
+// agent pool fields that can't be changed on an existing pool
+var agentPoolForceNewFields = []string{"os_disk_size", "os_type", "enable_auto_scaling", "ip_address_count"}
+
+// Computed fields aren't hashed, so they don't change the hash between configuration and state. Pools
+// are still matched by name when they are updated.
+func agentPoolProfileHash(v interface{}) int {
+	var buf bytes.Buffer
+	m := v.(map[string]interface{})
+	buf.WriteString(fmt.Sprintf("%s-", m["name"].(string)))
+	if v, ok := m["vm_size"].(string); ok {
+		buf.WriteString(fmt.Sprintf("%s-", strings.ToLower(v)))
+	}
+	if v, ok := m["os_type"].(string); ok {
+		buf.WriteString(fmt.Sprintf("%s-", strings.ToLower(v)))
+	}
+	if v, ok := m["os_disk_size"].(int); ok {
+		buf.WriteString(fmt.Sprintf("%d-", v))
+	}
+	if v, ok := m["enable_auto_scaling"].(bool); ok && v {
+		// the cluster autoscaler owns the node count
+		buf.WriteString(fmt.Sprintf("%d-%d-", m["min_count"].(int), m["max_count"].(int)))
+	} else if v, ok := m["count"].(int); ok {
+		buf.WriteString(fmt.Sprintf("%d-", v))
+	}
+
+	return hashcode.String(buf.String())
+}
+
+func agentPoolProfilesByName(profiles []interface{}) map[string]map[string]interface{} {
+	byName := map[string]map[string]interface{}{}
+	for _, p := range profiles {
+		profile := p.(map[string]interface{})
+		byName[profile["name"].(string)] = profile
+	}
+	return byName
+}
+
+func agentPoolNames(profiles []interface{}) []string {
+	names := []string{}
+	for _, p := range profiles {
+		names = append(names, p.(map[string]interface{})["name"].(string))
+	}
+	return names
+}
+
+// returns the names of the pools that were added and removed
+func diffAgentPools(oldProfiles, newProfiles []interface{}) ([]string, []string) {
+	oldByName, newByName := agentPoolProfilesByName(oldProfiles), agentPoolProfilesByName(newProfiles)
+	added, removed := []string{}, []string{}
+	for _, name := range agentPoolNames(newProfiles) {
+		if _, ok := oldByName[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	for _, name := range agentPoolNames(oldProfiles) {
+		if _, ok := newByName[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	return added, removed
+}
+
+// returns the key of a change that needs the cluster to be recreated, which is the removal of the primary
+// pool or a change to a field of an existing pool that can't be updated in place
+func agentPoolsForceNewKey(primaryPool string, oldProfiles, newProfiles []interface{}) (string, bool) {
+	oldByName, newByName := agentPoolProfilesByName(oldProfiles), agentPoolProfilesByName(newProfiles)
+	if oldProfile, ok := oldByName[primaryPool]; ok {
+		if _, ok := newByName[primaryPool]; !ok {
+			return fmt.Sprintf("agent_pool_profiles.%d.name", agentPoolProfileHash(oldProfile)), true
+		}
+	}
+
+	for _, name := range agentPoolNames(newProfiles) {
+		oldProfile, ok := oldByName[name]
+		if !ok {
+			continue
+		}
+		profile := newByName[name]
+		for _, field := range agentPoolForceNewFields {
+			if agentPoolFieldChanged(field, oldProfile[field], profile[field]) {
+				return fmt.Sprintf("agent_pool_profiles.%d.%s", agentPoolProfileHash(profile), field), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func agentPoolFieldChanged(field string, old, new interface{}) bool {
+	if field == "ip_address_count" && new == 0 {
+		// an unset `ip_address_count` keeps the computed value
+		return false
+	}
+	if v, ok := new.(string); ok {
+		// VM sizes and OS types aren't case sensitive
+		return !strings.EqualFold(old.(string), v)
+	}
+	return !reflect.DeepEqual(old, new)
+}
+
+// returns the name of the first agent pool in a base64 encoded api model
+func primaryAgentPoolName(apimodel string) (string, error) {
+	if apimodel == "" {
+		return "", nil
+	}
+	data, err := base64.StdEncoding.DecodeString(apimodel)
+	if err != nil {
+		return "", fmt.Errorf("error decoding `api_model`: %+v", err)
+	}
+	model := struct {
+		Properties struct {
+			AgentPoolProfiles []struct {
+				Name string `json:"name"`
+			} `json:"agentPoolProfiles"`
+		} `json:"properties"`
+	}{}
+	if err = json.Unmarshal(data, &model); err != nil {
+		return "", fmt.Errorf("error parsing `api_model`: %+v", err)
+	}
+	if len(model.Properties.AgentPoolProfiles) == 0 {
+		return "", nil
+	}
+
+	return model.Properties.AgentPoolProfiles[0].Name, nil
+}
+
+func customizeDiffAgentPools(d *schema.ResourceDiff) error {
+	if d.Id() == "" || !d.HasChange("agent_pool_profiles") {
+		return nil
+	}
+	primaryPool, err := primaryAgentPoolName(d.Get("api_model").(string))
+	if err != nil {
+		return err
+	}
+	o, n := d.GetChange("agent_pool_profiles")
+	key, ok := agentPoolsForceNewKey(primaryPool, o.(*schema.Set).List(), n.(*schema.Set).List())
+	if !ok {
+		return nil
+	}
+	// a changed pool is a new set element, so a field that was reset to its zero value isn't a change
+	// to that element, but its name always is
+	if !d.HasChange(key) {
+		key = key[:strings.LastIndex(key, ".")] + ".name"
+	}
+	return d.ForceNew(key)
+}
+
+// The primary pool of a new cluster is the auto scaled pool if there is one, since the cluster autoscaler
+// addon only manages the primary pool, and otherwise the first pool by name
+func sortAgentPoolProfiles(profiles []*api.AgentPoolProfile) {
+	autoScaled := func(profile *api.AgentPoolProfile) bool {
+		return profile.EnableAutoScaling != nil && *profile.EnableAutoScaling
+	}
+	sort.SliceStable(profiles, func(i, j int) bool {
+		if autoScaled(profiles[i]) != autoScaled(profiles[j]) {
+			return autoScaled(profiles[i])
+		}
+		return profiles[i].Name < profiles[j].Name
+	})
+}
+
+func (cluster *containerService) agentPoolIndex(name string) (int, bool) {
+	for i, profile := range cluster.Properties.AgentPoolProfiles {
+		if strings.EqualFold(profile.Name, name) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// adds, removes, resizes and scales agent pools to match `agent_pool_profiles`
+func updateAgentPools(d *resourceData, c *ArmClient) error {
+	o, n := d.GetChange("agent_pool_profiles")
+	oldProfiles, newProfiles := o.(*schema.Set).List(), n.(*schema.Set).List()
+	oldByName := agentPoolProfilesByName(oldProfiles)
+	added, removed := diffAgentPools(oldProfiles, newProfiles)
+
+	// removing pools first frees up subnet addresses for new pools
+	for _, name := range removed {
+		if err := removeAgentPool(d, c, name); err != nil {
+			return fmt.Errorf("error removing agent pool %q: %+v", name, err)
+		}
+	}
+
+	for _, name := range added {
+		if err := addAgentPool(d, c, name); err != nil {
+			return fmt.Errorf("error adding agent pool %q: %+v", name, err)
+		}
+	}
+
+	for _, p := range newProfiles {
+		profile := p.(map[string]interface{})
+		name := profile["name"].(string)
+		oldProfile, ok := oldByName[name]
+		if !ok {
+			continue
+		}
+
+		if vmSize := profile["vm_size"].(string); !strings.EqualFold(vmSize, oldProfile["vm_size"].(string)) {
+			if err := resizeAgentPool(d, c, name, vmSize); err != nil {
+				return fmt.Errorf("error resizing agent pool %q: %+v", name, err)
+			}
+		}
+
+		if profile["enable_auto_scaling"].(bool) {
+			// the cluster autoscaler owns the node count, so only its node range is updated
+			minCount, maxCount := profile["min_count"].(int), profile["max_count"].(int)
+			if minCount != oldProfile["min_count"].(int) || maxCount != oldProfile["max_count"].(int) {
+				if err := updateClusterAutoscaler(d, c, minCount, maxCount); err != nil {
+					return fmt.Errorf("error updating cluster autoscaler: %+v", err)
+				}
+			}
+			continue
+		}
+
+		if count := profile["count"].(int); count != oldProfile["count"].(int) {
+			if err := scaleCluster(d, c, name, count); err != nil {
+				return fmt.Errorf("error scaling agent pool %q: %+v", name, err)
+			}
+		}
+	}
+
+	return updateAgentPoolVersions(d)
+}
+
+// deploys a template containing only the new agent pool and adds the pool to the end of the api model
+func addAgentPool(d *resourceData, c *ArmClient, name string) error {
+	cluster, err := d.loadContainerServiceFromApimodel(true, true)
+	if err != nil {
+		return fmt.Errorf("error parsing the api model: %+v", err)
+	}
+	if _, ok := cluster.agentPoolIndex(name); ok {
+		return fmt.Errorf("agent pool %q already exists in api model", name)
+	}
+	profiles, err := d.expandAgentPoolProfiles()
+	if err != nil {
+		return fmt.Errorf("error expanding agent pool profiles: %+v", err)
+	}
+	var profile *api.AgentPoolProfile
+	for _, p := range profiles {
+		if p.Name == name {
+			profile = p
+		}
+	}
+	if profile == nil {
+		return fmt.Errorf("agent pool %q not found in configuration", name)
+	}
+	if profile.EnableAutoScaling != nil && *profile.EnableAutoScaling {
+		return fmt.Errorf("auto scaling is only supported on the primary agent pool")
+	}
+	index := len(cluster.Properties.AgentPoolProfiles)
+	cluster.Properties.AgentPoolProfiles = append(cluster.Properties.AgentPoolProfiles, profile)
+
+	keyVaultSecretRef := cluster.Properties.ServicePrincipalProfile.KeyvaultSecretRef
+	clientSecret, err := getSecretFromKeyVault(c, keyVaultSecretRef.VaultID, keyVaultSecretRef.SecretName, keyVaultSecretRef.SecretVersion)
+	if err != nil {
+		return fmt.Errorf("error getting service principal key: %+v", err)
+	}
+
+	sc := operations.NewScaleClient(clientSecret)
+	sc.ManageResourceGroup = d.managesResourceGroup()
+	if err = sc.SetScaleClient(c.StopContext, cluster.ContainerService, d.Id(), index, profile.Count); err != nil {
+		return fmt.Errorf("failed to initialize scale client: %+v", err)
+	}
+	d.setUpdateStrategy(sc)
+	version, _ := agentPoolProfilesByName(d.Get("agent_pool_profiles").(*schema.Set).List())[name]["kubernetes_version"].(string)
+	sc.AgentPoolVersion = version
+	sc.Client = operations.NewContextClient(sc.Client, c.StopContext)
+	if transform := d.templateTransformer(); transform != nil {
+		sc.Client = operations.NewTransformingClient(sc.Client, transform)
+	}
+
+	if err = deployAgentPool(c, sc, profile.Count, 0, -1); err != nil {
+		return fmt.Errorf("failed to deploy agent pool: %+v", err)
+	}
+	log.Printf("[INFO] agent pool %q added", name)
+
+	if err = cluster.saveTemplates(d, sc.DeploymentDirectory); err != nil {
+		return err
+	}
+	versions := d.getAgentPoolVersions()
+	if !recordAgentPoolVersion(versions, name, version) {
+		return nil
+	}
+	return d.setAgentPoolVersions(versions)
+}
+
+// drains every node in the agent pool, deletes its VMs or scale sets and removes the pool from the api model
+func removeAgentPool(d *resourceData, c *ArmClient, name string) error {
+	cluster, err := d.loadContainerServiceFromApimodel(true, true)
+	if err != nil {
+		return fmt.Errorf("error parsing the api model: %+v", err)
+	}
+	agentIndex, ok := cluster.agentPoolIndex(name)
+	if !ok {
+		log.Printf("[INFO] agent pool %q already removed from api model", name)
+		return nil
+	}
+	if agentIndex == 0 {
+		return fmt.Errorf("the first agent pool can't be removed")
+	}
+
+	keyVaultSecretRef := cluster.Properties.ServicePrincipalProfile.KeyvaultSecretRef
+	clientSecret, err := getSecretFromKeyVault(c, keyVaultSecretRef.VaultID, keyVaultSecretRef.SecretName, keyVaultSecretRef.SecretVersion)
+	if err != nil {
+		return fmt.Errorf("error getting service principal key: %+v", err)
+	}
+
+	sc := operations.NewScaleClient(clientSecret)
+	sc.ManageResourceGroup = d.managesResourceGroup()
+	if err = sc.SetScaleClient(c.StopContext, cluster.ContainerService, d.Id(), agentIndex, cluster.Properties.AgentPoolProfiles[agentIndex].Count); err != nil {
+		return fmt.Errorf("failed to initialize scale client: %+v", err)
+	}
+	d.setUpdateStrategy(sc)
+	d.setScaleClientVersion(sc)
+	sc.Client = operations.NewContextClient(sc.Client, c.StopContext)
+	kubeconfig, err := cluster.getKubeConfig(c, true)
+	if err != nil {
+		return fmt.Errorf("error getting kube config: %+v", err)
+	}
+
+	if sc.AgentPool.IsAvailabilitySets() {
+		if err = removeAgentPoolVMs(c, sc, kubeconfig); err != nil {
+			return err
+		}
+	} else {
+		if err = removeAgentPoolScaleSets(c, sc, kubeconfig); err != nil {
+			return err
+		}
+	}
+	log.Printf("[INFO] agent pool %q removed", name)
+
+	cluster.Properties.AgentPoolProfiles = append(cluster.Properties.AgentPoolProfiles[:agentIndex], cluster.Properties.AgentPoolProfiles[agentIndex+1:]...)
+	return cluster.saveTemplates(d, sc.DeploymentDirectory)
+}
+
+func removeAgentPoolVMs(c *ArmClient, sc *operations.ScaleClient, kubeconfig string) error {
+	_, _, _, vms, err := sc.ScaleVMAS(c.StopContext)
+	if err != nil {
+		return fmt.Errorf("failed to get agent pool VMs: %+v", err)
+	}
+	if len(vms) > 0 {
+		if _, err = sc.DrainNodes(c.StopContext, kubeconfig, vms); err != nil {
+			return fmt.Errorf("failed to drain agent pool nodes: %+v", err)
+		}
+		if err = deleteAvailabilitySetVMs(sc, vms); err != nil {
+			return fmt.Errorf("failed to delete agent pool VMs: %+v", err)
+		}
+	}
+
+	availabilitySet := fmt.Sprintf("%s-availabilitySet-%s", sc.AgentPool.Name, sc.NameSuffix)
+	if _, err = c.availabilitySetsClient.Delete(c.StopContext, sc.ResourceGroupName, availabilitySet); err != nil {
+		return fmt.Errorf("failed to delete availability set %q: %+v", availabilitySet, err)
+	}
+
+	return nil
+}
+
+func removeAgentPoolScaleSets(c *ArmClient, sc *operations.ScaleClient, kubeconfig string) error {
+	scaleSets, nodes, err := sc.AgentPoolScaleSets(c.StopContext)
+	if err != nil {
+		return fmt.Errorf("failed to get agent pool scale sets: %+v", err)
+	}
+	if len(nodes) > 0 {
+		if _, err = sc.DrainNodes(c.StopContext, kubeconfig, nodes); err != nil {
+			return fmt.Errorf("failed to drain agent pool nodes: %+v", err)
+		}
+	}
+
+	// deleting a scale set deletes its NICs and managed disks
+	for _, scaleSet := range scaleSets {
+		future, err := c.vmScaleSetsClient.Delete(c.StopContext, sc.ResourceGroupName, scaleSet)
+		if err != nil {
+			return fmt.Errorf("failed to delete scale set %q: %+v", scaleSet, err)
+		}
+		if err = future.WaitForCompletion(c.StopContext, c.vmScaleSetsClient.Client); err != nil {
+			return fmt.Errorf("failed waiting for scale set %q to be deleted: %+v", scaleSet, err)
+		}
+	}
+
+	return nil
+}
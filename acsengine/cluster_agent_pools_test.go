@@ -0,0 +1,275 @@
+package acsengine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockAgentPoolProfile(name string, count int, vmSize string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":                name,
+		"count":               count,
+		"vm_size":             vmSize,
+		"os_disk_size":        0,
+		"os_type":             "Linux",
+		"enable_auto_scaling": false,
+		"min_count":           0,
+		"max_count":           0,
+		"ip_address_count":    1,
+	}
+}
+
+func TestDiffAgentPools(t *testing.T) {
+	oldProfiles := []interface{}{
+		mockAgentPoolProfile("agentpool1", 1, "Standard_D2_v2"),
+		mockAgentPoolProfile("agentpool2", 2, "Standard_D2_v2"),
+	}
+	newProfiles := []interface{}{
+		mockAgentPoolProfile("agentpool1", 3, "Standard_D2_v2"),
+		mockAgentPoolProfile("agentpool3", 1, "Standard_D2_v2"),
+	}
+
+	added, removed := diffAgentPools(oldProfiles, newProfiles)
+	assert.Equal(t, []string{"agentpool3"}, added)
+	assert.Equal(t, []string{"agentpool2"}, removed)
+
+	added, removed = diffAgentPools(oldProfiles, oldProfiles)
+	assert.Empty(t, added, "no pools should be added")
+	assert.Empty(t, removed, "no pools should be removed")
+}
+
+func TestAgentPoolsForceNewKey(t *testing.T) {
+	oldProfiles := []interface{}{
+		mockAgentPoolProfile("agentpool1", 1, "Standard_D2_v2"),
+		mockAgentPoolProfile("agentpool2", 2, "Standard_D2_v2"),
+	}
+	largerDiskPool := mockAgentPoolProfile("agentpool2", 2, "Standard_D2_v2")
+	largerDiskPool["os_disk_size"] = 100
+
+	cases := []struct {
+		Description string
+		NewProfiles []interface{}
+		Expected    bool
+		Key         string
+	}{
+		{
+			Description: "scaling a pool",
+			NewProfiles: []interface{}{
+				mockAgentPoolProfile("agentpool1", 3, "Standard_D2_v2"),
+				mockAgentPoolProfile("agentpool2", 2, "Standard_D2_v2"),
+			},
+			Expected: false,
+		},
+		{
+			Description: "adding and removing pools",
+			NewProfiles: []interface{}{
+				mockAgentPoolProfile("agentpool1", 1, "Standard_D2_v2"),
+				mockAgentPoolProfile("agentpool3", 1, "Standard_D4_v2"),
+			},
+			Expected: false,
+		},
+		{
+			Description: "changing the VM size of a pool",
+			NewProfiles: []interface{}{
+				mockAgentPoolProfile("agentpool1", 1, "Standard_D2_v2"),
+				mockAgentPoolProfile("agentpool2", 2, "Standard_D4_v2"),
+			},
+			Expected: false,
+		},
+		{
+			Description: "changing the OS disk size of a pool",
+			NewProfiles: []interface{}{
+				mockAgentPoolProfile("agentpool1", 1, "Standard_D2_v2"),
+				largerDiskPool,
+			},
+			Expected: true,
+			Key:      fmt.Sprintf("agent_pool_profiles.%d.os_disk_size", agentPoolProfileHash(largerDiskPool)),
+		},
+		{
+			Description: "reordering pools",
+			NewProfiles: []interface{}{
+				mockAgentPoolProfile("agentpool2", 2, "Standard_D2_v2"),
+				mockAgentPoolProfile("agentpool1", 1, "Standard_D2_v2"),
+			},
+			Expected: false,
+		},
+		{
+			Description: "replacing the primary pool",
+			NewProfiles: []interface{}{
+				mockAgentPoolProfile("agentpool3", 1, "Standard_D2_v2"),
+				mockAgentPoolProfile("agentpool2", 2, "Standard_D2_v2"),
+			},
+			Expected: true,
+			Key:      fmt.Sprintf("agent_pool_profiles.%d.name", agentPoolProfileHash(mockAgentPoolProfile("agentpool1", 1, "Standard_D2_v2"))),
+		},
+	}
+
+	for _, tc := range cases {
+		key, ok := agentPoolsForceNewKey("agentpool1", oldProfiles, tc.NewProfiles)
+		assert.Equal(t, tc.Expected, ok, tc.Description)
+		if ok {
+			assert.Equal(t, tc.Key, key, tc.Description)
+		}
+	}
+}
+
+func TestAgentPoolIndex(t *testing.T) {
+	cluster := mockCluster("cluster", "southcentralus", "dnsprefix")
+	cluster.Properties.AgentPoolProfiles = []*api.AgentPoolProfile{
+		{Name: "agentpool1"},
+		{Name: "agentpool2"},
+	}
+
+	index, ok := cluster.agentPoolIndex("AgentPool2")
+	assert.True(t, ok, "agent pool should be found")
+	assert.Equal(t, 1, index)
+
+	_, ok = cluster.agentPoolIndex("agentpool3")
+	assert.False(t, ok, "agent pool should not be found")
+}
+
+func TestSortAgentPoolProfiles(t *testing.T) {
+	enabled := true
+	profiles := []*api.AgentPoolProfile{
+		{Name: "agentpool2"},
+		{Name: "agentpool1"},
+		{Name: "agentpool3", EnableAutoScaling: &enabled},
+	}
+
+	sortAgentPoolProfiles(profiles)
+	names := []string{}
+	for _, profile := range profiles {
+		names = append(names, profile.Name)
+	}
+	assert.Equal(t, []string{"agentpool3", "agentpool1", "agentpool2"}, names, "auto scaled pool should be first")
+}
+
+func TestPrimaryAgentPoolName(t *testing.T) {
+	apimodel := base64Encode(`{"properties": {"agentPoolProfiles": [{"name": "agentpool2"}, {"name": "agentpool1"}]}}`)
+	name, err := primaryAgentPoolName(apimodel)
+	if err != nil {
+		t.Fatalf("primaryAgentPoolName failed: %+v", err)
+	}
+	assert.Equal(t, "agentpool2", name)
+
+	name, err = primaryAgentPoolName("")
+	if err != nil {
+		t.Fatalf("primaryAgentPoolName failed: %+v", err)
+	}
+	assert.Equal(t, "", name, "there should be no primary pool without an api model")
+
+	if _, err = primaryAgentPoolName("not base64"); err == nil {
+		t.Fatalf("primaryAgentPoolName should have failed for an invalid api model")
+	}
+}
+
+func TestAgentPoolProfileHash(t *testing.T) {
+	profile := mockAgentPoolProfile("agentpool1", 1, "Standard_D2_v2")
+	assert.NotEqual(t, agentPoolProfileHash(profile), agentPoolProfileHash(mockAgentPoolProfile("agentpool1", 3, "Standard_D2_v2")))
+	assert.Equal(t, agentPoolProfileHash(profile), agentPoolProfileHash(mockAgentPoolProfile("agentpool1", 1, "standard_d2_v2")), "VM size should not be case sensitive")
+
+	computed := mockAgentPoolProfile("agentpool1", 1, "Standard_D2_v2")
+	computed["ip_address_count"] = 31
+	computed["current_count"] = 2
+	assert.Equal(t, agentPoolProfileHash(profile), agentPoolProfileHash(computed), "computed fields should not be hashed")
+
+	autoScaled := mockAgentPoolProfile("agentpool1", 1, "Standard_D2_v2")
+	autoScaled["enable_auto_scaling"] = true
+	autoScaled["min_count"], autoScaled["max_count"] = 1, 5
+	scaled := mockAgentPoolProfile("agentpool1", 3, "Standard_D2_v2")
+	scaled["enable_auto_scaling"] = true
+	scaled["min_count"], scaled["max_count"] = 1, 5
+	assert.Equal(t, agentPoolProfileHash(autoScaled), agentPoolProfileHash(scaled), "count of auto scaled pools should not be hashed")
+}
+
+func TestCustomizeDiffAgentPools(t *testing.T) {
+	pool1 := map[string]interface{}{"name": "agentpool1", "count": 1, "vm_size": "Standard_D2_v2"}
+	pool2 := map[string]interface{}{"name": "agentpool2", "count": 2, "vm_size": "Standard_D2_v2"}
+	raw := mockClusterRawConfig("cluster", "southcentralus", "rg", "dnsprefix")
+	raw["agent_pool_profiles"] = []interface{}{pool1, pool2}
+	// a Windows pool is only valid with a version that supports Windows and a Windows profile
+	raw["kubernetes_version"] = "1.10.0"
+	raw["windows_profile"] = []interface{}{
+		map[string]interface{}{"admin_username": "azureuser", "admin_password": "Password1234$"},
+	}
+	state, err := applyCluster(raw, base64Encode(`{"properties": {"agentPoolProfiles": [{"name": "agentpool1"}, {"name": "agentpool2"}]}}`))
+	if err != nil {
+		t.Fatalf("failed to create cluster state: %+v", err)
+	}
+
+	cases := []struct {
+		Description string
+		Pools       []interface{}
+		Changed     bool
+		RequiresNew bool
+	}{
+		{
+			Description: "reordering pools",
+			Pools:       []interface{}{pool2, pool1},
+			Changed:     false,
+			RequiresNew: false,
+		},
+		{
+			Description: "scaling a pool",
+			Pools: []interface{}{
+				map[string]interface{}{"name": "agentpool2", "count": 3, "vm_size": "Standard_D2_v2"},
+				pool1,
+			},
+			Changed:     true,
+			RequiresNew: false,
+		},
+		{
+			Description: "removing a pool",
+			Pools:       []interface{}{pool1},
+			Changed:     true,
+			RequiresNew: false,
+		},
+		{
+			Description: "resizing a pool",
+			Pools: []interface{}{
+				pool1,
+				map[string]interface{}{"name": "agentpool2", "count": 2, "vm_size": "Standard_D4_v2"},
+			},
+			Changed:     true,
+			RequiresNew: false,
+		},
+		{
+			Description: "changing the OS of a pool",
+			Pools: []interface{}{
+				pool1,
+				map[string]interface{}{"name": "agentpool2", "count": 2, "vm_size": "Standard_D2_v2", "os_type": "Windows"},
+			},
+			Changed:     true,
+			RequiresNew: true,
+		},
+		{
+			Description: "removing the primary pool",
+			Pools:       []interface{}{pool2},
+			Changed:     true,
+			RequiresNew: true,
+		},
+	}
+
+	for _, tc := range cases {
+		raw["agent_pool_profiles"] = tc.Pools
+		diff, err := diffClusterState(state, raw)
+		if err != nil {
+			t.Fatalf("%s: diff failed: %+v", tc.Description, err)
+		}
+		changed, requiresNew := false, false
+		if diff != nil {
+			for k, attr := range diff.Attributes {
+				if strings.HasPrefix(k, "agent_pool_profiles.") && (attr.Old != attr.New || attr.NewRemoved) {
+					changed = true
+				}
+				requiresNew = requiresNew || attr.RequiresNew
+			}
+		}
+		assert.Equal(t, tc.Changed, changed, tc.Description)
+		assert.Equal(t, tc.RequiresNew, requiresNew, tc.Description)
+	}
+}
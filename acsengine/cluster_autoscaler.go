@@ -0,0 +1,140 @@
+package acsengine
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/Azure/acs-engine/pkg/api/common"
+	"github.com/Azure/terraform-provider-acsengine/internal/kubernetes"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// The cluster-autoscaler addon in acs-engine only manages the primary (first) agent pool scale set, and the
+// vlabs api model doesn't keep the agent pool autoscaling fields, so the node range is stored in the addon config.
+
+const (
+	clusterAutoscalerAddonName = "cluster-autoscaler"
+	minNodesConfig             = "minNodes"
+	maxNodesConfig             = "maxNodes"
+)
+
+func (cluster *containerService) setClusterAutoscalerAddon() error {
+	for i, profile := range cluster.Properties.AgentPoolProfiles {
+		if profile.EnableAutoScaling == nil || !*profile.EnableAutoScaling {
+			continue
+		}
+		if i != 0 {
+			return fmt.Errorf("auto scaling is only supported on one agent pool, not %q", profile.Name)
+		}
+		version := cluster.Properties.OrchestratorProfile.OrchestratorVersion
+		if !common.IsKubernetesVersionGe(version, "1.10.0") {
+			return fmt.Errorf("auto scaling requires virtual machine scale sets, which need Kubernetes 1.10.0 or later (version is %s)", version)
+		}
+
+		enabled := true
+		addon := api.KubernetesAddon{
+			Name:    clusterAutoscalerAddonName,
+			Enabled: &enabled,
+			Config: map[string]string{
+				minNodesConfig: strconv.Itoa(*profile.MinCount),
+				maxNodesConfig: strconv.Itoa(*profile.MaxCount),
+			},
+		}
+		if cluster.Properties.OrchestratorProfile.KubernetesConfig == nil {
+			cluster.Properties.OrchestratorProfile.KubernetesConfig = &api.KubernetesConfig{}
+		}
+		kubernetesConfig := cluster.Properties.OrchestratorProfile.KubernetesConfig
+		kubernetesConfig.Addons = append(kubernetesConfig.Addons, addon)
+	}
+
+	return nil
+}
+
+// sets agent pool autoscaling fields from the cluster-autoscaler addon stored in the api model
+func (cluster *containerService) setAgentPoolAutoScaling() {
+	addon := cluster.clusterAutoscalerAddon()
+	if addon == nil || !addon.IsEnabled(false) || len(cluster.Properties.AgentPoolProfiles) == 0 {
+		return
+	}
+	minCount, err := strconv.Atoi(addon.Config[minNodesConfig])
+	if err != nil {
+		return
+	}
+	maxCount, err := strconv.Atoi(addon.Config[maxNodesConfig])
+	if err != nil {
+		return
+	}
+
+	enabled := true
+	profile := cluster.Properties.AgentPoolProfiles[0]
+	profile.EnableAutoScaling = &enabled
+	profile.MinCount = &minCount
+	profile.MaxCount = &maxCount
+}
+
+func (cluster *containerService) clusterAutoscalerAddon() *api.KubernetesAddon {
+	kubernetesConfig := cluster.Properties.OrchestratorProfile.KubernetesConfig
+	if kubernetesConfig == nil {
+		return nil
+	}
+	for i := range kubernetesConfig.Addons {
+		if kubernetesConfig.Addons[i].Name == clusterAutoscalerAddonName {
+			return &kubernetesConfig.Addons[i]
+		}
+	}
+	return nil
+}
+
+// once the cluster exists the cluster autoscaler owns the agent pool node count, so count is only the initial size
+func autoScalingCountDiffSuppressFunc(k, old, new string, d *schema.ResourceData) bool {
+	if d.Id() == "" {
+		return false
+	}
+	autoScaling := strings.TrimSuffix(k, "count") + "enable_auto_scaling"
+	return d.Get(autoScaling).(bool)
+}
+
+func updateClusterAutoscaler(d *resourceData, c *ArmClient, minCount, maxCount int) error {
+	if minCount < 1 || minCount > maxCount {
+		return fmt.Errorf("`min_count` (%d) must be at least 1 and no greater than `max_count` (%d)", minCount, maxCount)
+	}
+
+	cluster, err := d.loadContainerServiceFromApimodel(true, true)
+	if err != nil {
+		return fmt.Errorf("error parsing the api model: %+v", err)
+	}
+	addon := cluster.clusterAutoscalerAddon()
+	if addon == nil {
+		return fmt.Errorf("cluster autoscaler addon not found in api model")
+	}
+
+	kubeconfig, err := cluster.getKubeConfig(c, true)
+	if err != nil {
+		return fmt.Errorf("error getting kube config: %+v", err)
+	}
+	if err = kubernetes.SetClusterAutoscalerNodeRange(kubeconfig, minCount, maxCount); err != nil {
+		return fmt.Errorf("error updating cluster autoscaler: %+v", err)
+	}
+
+	addon.Config[minNodesConfig] = strconv.Itoa(minCount)
+	addon.Config[maxNodesConfig] = strconv.Itoa(maxCount)
+
+	deploymentDirectory := path.Join("_output", cluster.Properties.MasterProfile.DNSPrefix)
+	return cluster.saveTemplates(d, deploymentDirectory)
+}
+
+func (d *resourceData) setAgentPoolCurrentCounts(counts map[string]int) error {
+	profiles := d.Get("agent_pool_profiles").(*schema.Set).List()
+	for _, p := range profiles {
+		profile := p.(map[string]interface{})
+		profile["current_count"] = counts[strings.ToLower(profile["name"].(string))]
+	}
+	if err := d.Set("agent_pool_profiles", profiles); err != nil {
+		return fmt.Errorf("Error setting 'agent_pool_profiles': %+v", err)
+	}
+
+	return nil
+}
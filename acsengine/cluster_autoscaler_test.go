@@ -0,0 +1,100 @@
+package acsengine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetClusterAutoscalerAddon(t *testing.T) {
+	cluster := mockCluster("cluster", "southcentralus", "dnsprefix")
+	cluster.Properties.OrchestratorProfile.OrchestratorVersion = "1.10.0"
+	enabled := true
+	minCount, maxCount := 1, 5
+	profile := cluster.Properties.AgentPoolProfiles[0]
+	profile.EnableAutoScaling = &enabled
+	profile.MinCount = &minCount
+	profile.MaxCount = &maxCount
+
+	if err := cluster.setClusterAutoscalerAddon(); err != nil {
+		t.Fatalf("setClusterAutoscalerAddon failed: %+v", err)
+	}
+
+	addon := cluster.clusterAutoscalerAddon()
+	if addon == nil {
+		t.Fatalf("cluster autoscaler addon was not set")
+	}
+	assert.True(t, addon.IsEnabled(false), "cluster autoscaler addon should be enabled")
+	assert.Equal(t, "1", addon.Config[minNodesConfig])
+	assert.Equal(t, "5", addon.Config[maxNodesConfig])
+}
+
+func TestSetClusterAutoscalerAddonInvalid(t *testing.T) {
+	cases := []struct {
+		Version   string
+		PoolIndex int
+	}{
+		{
+			Version:   "1.9.8",
+			PoolIndex: 0,
+		},
+		{
+			Version:   "1.10.0",
+			PoolIndex: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		cluster := mockCluster("cluster", "southcentralus", "dnsprefix")
+		cluster.Properties.OrchestratorProfile.OrchestratorVersion = tc.Version
+		enabled := true
+		minCount, maxCount := 1, 5
+		profile := cluster.Properties.AgentPoolProfiles[tc.PoolIndex]
+		profile.EnableAutoScaling = &enabled
+		profile.MinCount = &minCount
+		profile.MaxCount = &maxCount
+
+		if err := cluster.setClusterAutoscalerAddon(); err == nil {
+			t.Fatalf("setClusterAutoscalerAddon should have failed for version %s and agent pool %d", tc.Version, tc.PoolIndex)
+		}
+	}
+}
+
+func TestSetAgentPoolAutoScaling(t *testing.T) {
+	cluster := mockCluster("cluster", "southcentralus", "dnsprefix")
+	cluster.Properties.OrchestratorProfile.OrchestratorVersion = "1.10.0"
+	enabled := true
+	minCount, maxCount := 2, 4
+	cluster.Properties.AgentPoolProfiles[0].EnableAutoScaling = &enabled
+	cluster.Properties.AgentPoolProfiles[0].MinCount = &minCount
+	cluster.Properties.AgentPoolProfiles[0].MaxCount = &maxCount
+	if err := cluster.setClusterAutoscalerAddon(); err != nil {
+		t.Fatalf("setClusterAutoscalerAddon failed: %+v", err)
+	}
+
+	// api model serialization drops these
+	profile := cluster.Properties.AgentPoolProfiles[0]
+	profile.EnableAutoScaling = nil
+	profile.MinCount = nil
+	profile.MaxCount = nil
+
+	cluster.setAgentPoolAutoScaling()
+
+	assert.True(t, *profile.EnableAutoScaling, "auto scaling should be enabled")
+	assert.Equal(t, minCount, *profile.MinCount)
+	assert.Equal(t, maxCount, *profile.MaxCount)
+	assert.Nil(t, cluster.Properties.AgentPoolProfiles[1].EnableAutoScaling, "only the first agent pool can be auto scaled")
+}
+
+func TestSetAgentPoolCurrentCounts(t *testing.T) {
+	d := mockClusterResourceData("cluster", "southcentralus", "rg", "dnsprefix")
+
+	if err := d.setAgentPoolCurrentCounts(map[string]int{"agentpool1": 3}); err != nil {
+		t.Fatalf("setAgentPoolCurrentCounts failed: %+v", err)
+	}
+
+	agentPool1, agentPool2 := mockAgentPool(d, "agentpool1"), mockAgentPool(d, "agentpool2")
+	assert.Equal(t, 3, agentPool1["current_count"].(int))
+	assert.Equal(t, 0, agentPool2["current_count"].(int))
+	assert.Equal(t, 1, agentPool1["count"].(int), "count should not change")
+}
@@ -0,0 +1,306 @@
+package acsengine
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
+	"github.com/Azure/terraform-provider-acsengine/internal/operations"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// Rotating certificates stores newly generated certificates in key vault as new secret versions and then replaces
+// every node, masters first, since nodes only read their certificates from key vault when they're deployed. Each
+// node is deployed with a tag holding the fingerprint of the new client certificate. The fingerprint is also kept in
+// `pending_certificate_fingerprint` from the time the new certificates are in key vault until every node has them,
+// so a rotation that fails part of the way carries on with the nodes that don't have the tag yet instead of
+// generating certificates again. Nodes deployed later by scaling or upgrades don't have the tag, so it can't tell
+// whether a rotation is in progress on its own.
+
+const certificateFingerprintTag = "certificateFingerprint"
+
+// rotates the cluster's certificates, including the certificate authority if rotateCA is set
+func rotateCertificates(d *resourceData, c *ArmClient, rotateCA bool) error {
+	cluster, err := d.loadContainerServiceFromApimodel(true, true)
+	if err != nil {
+		return fmt.Errorf("error parsing the api model: %+v", err)
+	}
+	vaultID := cluster.Properties.ServicePrincipalProfile.KeyvaultSecretRef.VaultID
+	dnsPrefix := cluster.Properties.MasterProfile.DNSPrefix
+
+	vaultURI, err := getKeyVaultURI(c, vaultID)
+	if err != nil {
+		return fmt.Errorf("failed to get vault URI: %+v", err)
+	}
+	clientCertificate, err := getSecret(c, vaultURI, secretName("clientcrt", dnsPrefix), "")
+	if err != nil {
+		return fmt.Errorf("failed to get client certificate: %+v", err)
+	}
+	fingerprint := certificateFingerprint(base64Decode(clientCertificate))
+
+	// the expiry of certificates that were generated by an earlier apply is read from key vault once the rotation
+	// finishes
+	expiry := map[string]interface{}{}
+	if d.certificateRotationPending(fingerprint) {
+		log.Printf("[INFO] resuming certificate rotation")
+	} else {
+		var caCertificate, caPrivateKey string
+		if !rotateCA {
+			if caCertificate, err = getSecret(c, vaultURI, secretName("cacrt", dnsPrefix), ""); err != nil {
+				return fmt.Errorf("failed to get ca certificate: %+v", err)
+			}
+			if caPrivateKey, err = getSecret(c, vaultURI, secretName("cakey", dnsPrefix), ""); err != nil {
+				return fmt.Errorf("failed to get ca key: %+v", err)
+			}
+		}
+		if err = cluster.regenerateCertificates(base64Decode(caCertificate), base64Decode(caPrivateKey)); err != nil {
+			return err
+		}
+		fingerprint = certificateFingerprint(cluster.Properties.CertificateProfile.ClientCertificate)
+		if expiry, err = cluster.certificateProfileExpiry(); err != nil {
+			return err
+		}
+
+		if err = setCertificateProfileSecretsKeyVault(c, &cluster); err != nil {
+			return fmt.Errorf("error setting keys and certificates in key vault: %+v", err)
+		}
+		if err = cluster.setCertificateProfileSecretsAPIModel(); err != nil {
+			return fmt.Errorf("error setting cluster secret IDs: %+v", err)
+		}
+		if err = cluster.saveTemplates(d, path.Join("_output", dnsPrefix)); err != nil {
+			return fmt.Errorf("error saving the api model: %+v", err)
+		}
+		d.SetPartial("api_model")
+		if err = d.Set("pending_certificate_fingerprint", fingerprint); err != nil {
+			return fmt.Errorf("error setting `pending_certificate_fingerprint`: %+v", err)
+		}
+		d.SetPartial("pending_certificate_fingerprint")
+	}
+
+	transform := func(template map[string]interface{}) error {
+		return setComputeResourceTag(template, certificateFingerprintTag, fingerprint)
+	}
+	outdatedMaster := func(vm compute.VirtualMachine) bool {
+		tag := vm.Tags[certificateFingerprintTag]
+		return tag == nil || *tag != fingerprint
+	}
+	if err = recreateMasterNodes(d, c, &cluster, outdatedMaster, transform); err != nil {
+		return fmt.Errorf("failed to rotate master certificates: %+v", err)
+	}
+
+	outdated := outdatedNodes{
+		availabilitySetVMs: func(c *ArmClient, sc *operations.ScaleClient, vms []string) ([]string, error) {
+			tags, err := sc.AvailabilitySetVMTags(c.StopContext, certificateFingerprintTag)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get availability set VM tags: %+v", err)
+			}
+			return outdatedCertificateVMs(vms, tags, fingerprint), nil
+		},
+		scaleSetVMs: outdatedModelScaleSetVMs,
+	}
+	prepare := func(sc *operations.ScaleClient) error {
+		sc.Client = operations.NewTransformingClient(sc.Client, transform)
+		if sc.AgentPool.IsAvailabilitySets() {
+			return nil
+		}
+		return updateScaleSetModel(c, sc)
+	}
+	for _, profile := range cluster.Properties.AgentPoolProfiles {
+		if err = replaceAgentPoolNodes(d, c, profile.Name, outdated, prepare); err != nil {
+			return fmt.Errorf("failed to rotate certificates of agent pool %q: %+v", profile.Name, err)
+		}
+	}
+
+	if err = d.Set("pending_certificate_fingerprint", ""); err != nil {
+		return fmt.Errorf("error setting `pending_certificate_fingerprint`: %+v", err)
+	}
+	d.SetPartial("pending_certificate_fingerprint")
+	if err = d.Set("certificate_expiry", expiry); err != nil {
+		return fmt.Errorf("error setting `certificate_expiry`: %+v", err)
+	}
+	d.SetPartial("certificate_expiry")
+
+	log.Printf("[INFO] certificates rotated")
+	return nil
+}
+
+// a rotation that hasn't finished is resumed as long as key vault still holds the certificates it was deploying
+func (d *resourceData) certificateRotationPending(fingerprint string) bool {
+	pending := d.Get("pending_certificate_fingerprint").(string)
+	return pending != "" && pending == fingerprint
+}
+
+// replaces the certificate profile with newly generated certificates signed by the given certificate authority,
+// or by a new one if it's empty
+func (cluster *containerService) regenerateCertificates(caCertificate, caPrivateKey string) error {
+	cluster.Properties.CertificateProfile = &api.CertificateProfile{
+		CaCertificate: caCertificate,
+		CaPrivateKey:  caPrivateKey,
+	}
+	// acs-engine generates the certificates missing from the certificate profile along with the templates
+	_, _, certsGenerated, err := cluster.formatTemplates(true)
+	if err != nil {
+		return fmt.Errorf("failed to generate certificates: %+v", err)
+	}
+	if !certsGenerated {
+		return fmt.Errorf("no certificates were generated")
+	}
+	return nil
+}
+
+// returns the VMs that weren't deployed with the current certificates
+func outdatedCertificateVMs(vms []string, tags map[string]string, fingerprint string) []string {
+	outdated := []string{}
+	for _, vm := range vms {
+		if tags[vm] != fingerprint {
+			outdated = append(outdated, vm)
+		}
+	}
+	return outdated
+}
+
+func certificateFingerprint(certificatePem string) string {
+	sum := sha256.Sum256([]byte(certificatePem))
+	return hex.EncodeToString(sum[:])
+}
+
+func certificateExpiry(certificatePem string) (time.Time, error) {
+	block, _ := pem.Decode([]byte(certificatePem))
+	if block == nil {
+		return time.Time{}, fmt.Errorf("certificate is not PEM encoded")
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse certificate: %+v", err)
+	}
+	return certificate.NotAfter, nil
+}
+
+// sets a tag on every VM and VM scale set in the template
+func setComputeResourceTag(template map[string]interface{}, name, value string) error {
+	resources, ok := template["resources"].([]interface{})
+	if !ok {
+		return fmt.Errorf("template resources not found")
+	}
+
+	for _, r := range resources {
+		resource, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch resource["type"] {
+		case "Microsoft.Compute/virtualMachines", "Microsoft.Compute/virtualMachineScaleSets":
+			tags, ok := resource["tags"].(map[string]interface{})
+			if !ok {
+				tags = map[string]interface{}{}
+				resource["tags"] = tags
+			}
+			tags[name] = value
+		}
+	}
+
+	return nil
+}
+
+// names of the certificates stored in key vault, without the crt suffix
+func certificateNames(masterCount int) []string {
+	names := []string{"ca", "apiserver", "client", "kubeconfig", "etcdserver", "etcdclient"}
+	for i := 0; i < masterCount; i++ {
+		names = append(names, fmt.Sprintf("etcdpeer%d", i))
+	}
+	return names
+}
+
+// returns the certificates of the certificate profile by name, which are only PEM encoded until they're moved to
+// key vault
+func (cluster *containerService) certificatesByName() map[string]string {
+	certificateProfile := cluster.Properties.CertificateProfile
+	certificates := map[string]string{
+		"ca":         certificateProfile.CaCertificate,
+		"apiserver":  certificateProfile.APIServerCertificate,
+		"client":     certificateProfile.ClientCertificate,
+		"kubeconfig": certificateProfile.KubeConfigCertificate,
+		"etcdserver": certificateProfile.EtcdServerCertificate,
+		"etcdclient": certificateProfile.EtcdClientCertificate,
+	}
+	for i, certificate := range certificateProfile.EtcdPeerCertificates {
+		certificates[fmt.Sprintf("etcdpeer%d", i)] = certificate
+	}
+	return certificates
+}
+
+// returns the expiry time of each certificate in the certificate profile, which has to hold newly generated
+// certificates
+func (cluster *containerService) certificateProfileExpiry() (map[string]interface{}, error) {
+	expiry := map[string]interface{}{}
+	for name, certificate := range cluster.certificatesByName() {
+		notAfter, err := certificateExpiry(certificate)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s certificate: %+v", name, err)
+		}
+		expiry[name] = notAfter.UTC().Format(time.RFC3339)
+	}
+	return expiry, nil
+}
+
+// adds the expiry time of the certificates missing from `certificate_expiry`, such as those of an imported cluster
+// or of masters added since, from key vault. Key vault errors are only logged so they can't block a refresh, and
+// nothing is read while a rotation is pending, since key vault then holds certificates that aren't deployed yet
+func (d *resourceData) setCertificateExpiry(c *ArmClient, cluster *containerService) error {
+	if d.Get("pending_certificate_fingerprint").(string) != "" {
+		return nil
+	}
+	expiry := d.Get("certificate_expiry").(map[string]interface{})
+	missing := []string{}
+	for _, name := range certificateNames(cluster.Properties.MasterProfile.Count) {
+		if _, ok := expiry[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	vaultURI, err := getKeyVaultURI(c, cluster.Properties.ServicePrincipalProfile.KeyvaultSecretRef.VaultID)
+	if err != nil {
+		log.Printf("[WARN] failed to get vault URI to read certificate expiry: %+v", err)
+		return nil
+	}
+	for _, name := range missing {
+		certificate, err := getSecret(c, vaultURI, secretName(name+"crt", cluster.Properties.MasterProfile.DNSPrefix), "")
+		if err != nil {
+			log.Printf("[WARN] failed to get %s certificate to read its expiry: %+v", name, err)
+			continue
+		}
+		notAfter, err := certificateExpiry(base64Decode(certificate))
+		if err != nil {
+			log.Printf("[WARN] error reading %s certificate: %+v", name, err)
+			continue
+		}
+		expiry[name] = notAfter.UTC().Format(time.RFC3339)
+	}
+
+	if err = d.Set("certificate_expiry", expiry); err != nil {
+		return fmt.Errorf("error setting `certificate_expiry`: %+v", err)
+	}
+	return nil
+}
+
+// a new certificate authority would have to be trusted by every etcd member at once, which can't be done
+// while masters are replaced one at a time
+func customizeDiffCertificateRotation(d *schema.ResourceDiff) error {
+	if d.Id() == "" || !d.HasChange("certificate_authority_rotation_id") {
+		return nil
+	}
+	if count := d.Get("master_profile.0.count").(int); count > 1 {
+		return fmt.Errorf("the certificate authority can't be rotated in a cluster with %d masters", count)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package acsengine
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/Azure/terraform-provider-acsengine/internal/tester"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegenerateCertificates(t *testing.T) {
+	cluster := newContainerService(tester.MockContainerService("name", "westus", "dnsprefix"))
+	if err := cluster.regenerateCertificates("", ""); err != nil {
+		t.Fatalf("regenerateCertificates failed: %+v", err)
+	}
+	certificateProfile := cluster.Properties.CertificateProfile
+	caCertificate, caPrivateKey := certificateProfile.CaCertificate, certificateProfile.CaPrivateKey
+	clientCertificate := certificateProfile.ClientCertificate
+	assert.Len(t, certificateProfile.EtcdPeerCertificates, cluster.Properties.MasterProfile.Count)
+
+	expiry, err := certificateExpiry(clientCertificate)
+	if err != nil {
+		t.Fatalf("certificateExpiry failed: %+v", err)
+	}
+	assert.True(t, expiry.After(time.Now()), "a new certificate shouldn't have expired")
+
+	if err = cluster.regenerateCertificates(caCertificate, caPrivateKey); err != nil {
+		t.Fatalf("regenerateCertificates failed: %+v", err)
+	}
+	certificateProfile = cluster.Properties.CertificateProfile
+	assert.Equal(t, caCertificate, certificateProfile.CaCertificate, "the certificate authority should be kept")
+	assert.NotEqual(t, clientCertificate, certificateProfile.ClientCertificate, "the client certificate should be new")
+	assert.NotEqual(t, certificateFingerprint(clientCertificate), certificateFingerprint(certificateProfile.ClientCertificate))
+}
+
+func TestCertificateExpiry(t *testing.T) {
+	notAfter := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	certificatePem := mockCertificate(t, notAfter)
+
+	expiry, err := certificateExpiry(certificatePem)
+	if err != nil {
+		t.Fatalf("certificateExpiry failed: %+v", err)
+	}
+	assert.True(t, notAfter.Equal(expiry), "expected %s but got %s", notAfter, expiry)
+
+	if _, err = certificateExpiry("apple"); err == nil {
+		t.Fatalf("certificateExpiry should have failed")
+	}
+}
+
+// returns a self-signed PEM encoded certificate that expires at notAfter
+func mockCertificate(t *testing.T, notAfter time.Time) string {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %+v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %+v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestCertificateRotationPending(t *testing.T) {
+	cases := []struct {
+		Pending  string
+		Expected bool
+	}{
+		{
+			Pending:  "",
+			Expected: false,
+		},
+		{
+			Pending:  "new",
+			Expected: true,
+		},
+		{
+			Pending:  "old",
+			Expected: false,
+		},
+	}
+
+	for _, tc := range cases {
+		d := mockClusterResourceData("cluster", "southcentralus", "rg", "dnsprefix")
+		if err := d.Set("pending_certificate_fingerprint", tc.Pending); err != nil {
+			t.Fatalf("failed to set pending fingerprint: %+v", err)
+		}
+		assert.Equal(t, tc.Expected, d.certificateRotationPending("new"), "pending fingerprint %q", tc.Pending)
+	}
+}
+
+func TestOutdatedCertificateVMs(t *testing.T) {
+	vms := []string{"k8s-agentpool1-12345678-0", "k8s-agentpool1-12345678-1", "k8s-agentpool1-12345678-2"}
+	tags := map[string]string{
+		"k8s-agentpool1-12345678-0": "new",
+		"k8s-agentpool1-12345678-1": "old",
+	}
+
+	assert.Equal(t, []string{"k8s-agentpool1-12345678-1", "k8s-agentpool1-12345678-2"}, outdatedCertificateVMs(vms, tags, "new"))
+}
+
+func TestSetComputeResourceTag(t *testing.T) {
+	template := map[string]interface{}{
+		"resources": []interface{}{
+			map[string]interface{}{
+				"type": "Microsoft.Compute/virtualMachines",
+				"tags": map[string]interface{}{"poolName": "master"},
+			},
+			map[string]interface{}{
+				"type": "Microsoft.Compute/virtualMachineScaleSets",
+			},
+			map[string]interface{}{
+				"type": "Microsoft.Network/networkInterfaces",
+			},
+		},
+	}
+
+	if err := setComputeResourceTag(template, certificateFingerprintTag, "abc"); err != nil {
+		t.Fatalf("setComputeResourceTag failed: %+v", err)
+	}
+
+	resources := template["resources"].([]interface{})
+	vmTags := resources[0].(map[string]interface{})["tags"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"poolName": "master", certificateFingerprintTag: "abc"}, vmTags)
+	vmssTags := resources[1].(map[string]interface{})["tags"].(map[string]interface{})
+	assert.Equal(t, "abc", vmssTags[certificateFingerprintTag])
+	_, ok := resources[2].(map[string]interface{})["tags"]
+	assert.False(t, ok, "tags should not be set on network interface")
+
+	if err := setComputeResourceTag(map[string]interface{}{}, certificateFingerprintTag, "abc"); err == nil {
+		t.Fatalf("setComputeResourceTag should have failed without resources")
+	}
+}
+
+func TestCertificateNames(t *testing.T) {
+	names := certificateNames(3)
+	assert.Contains(t, names, "ca")
+	assert.Contains(t, names, "etcdpeer2")
+	assert.NotContains(t, names, "etcdpeer3")
+}
+
+func TestCertificateProfileExpiry(t *testing.T) {
+	notAfter := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	certificate := mockCertificate(t, notAfter)
+	cluster := newContainerService(tester.MockContainerService("name", "westus", "dnsprefix"))
+	cluster.Properties.CertificateProfile = &api.CertificateProfile{
+		CaCertificate:         certificate,
+		APIServerCertificate:  certificate,
+		ClientCertificate:     certificate,
+		KubeConfigCertificate: certificate,
+		EtcdServerCertificate: certificate,
+		EtcdClientCertificate: certificate,
+		EtcdPeerCertificates:  []string{certificate, certificate, certificate},
+		EtcdPeerPrivateKeys:   []string{"", "", ""},
+	}
+
+	expiry, err := cluster.certificateProfileExpiry()
+	if err != nil {
+		t.Fatalf("certificateProfileExpiry failed: %+v", err)
+	}
+	names := certificateNames(3)
+	assert.Len(t, expiry, len(names))
+	for _, name := range names {
+		assert.Equal(t, "2030-01-01T00:00:00Z", expiry[name], name)
+	}
+
+	if err = cluster.setCertificateProfileSecretsAPIModel(); err != nil {
+		t.Fatalf("setCertificateProfileSecretsAPIModel failed: %+v", err)
+	}
+	if _, err = cluster.certificateProfileExpiry(); err == nil {
+		t.Fatalf("certificateProfileExpiry should have failed with key vault references")
+	}
+}
+
+func TestSetCertificateExpiryWithoutKeyVault(t *testing.T) {
+	cluster := newContainerService(tester.MockContainerService("name", "westus", "dnsprefix"))
+	expiry := map[string]interface{}{}
+	for _, name := range certificateNames(cluster.Properties.MasterProfile.Count) {
+		expiry[name] = "2030-01-01T00:00:00Z"
+	}
+
+	d := mockClusterResourceData("cluster", "southcentralus", "rg", "dnsprefix")
+	if err := d.Set("certificate_expiry", expiry); err != nil {
+		t.Fatalf("failed to set certificate expiry: %+v", err)
+	}
+	// a nil client would panic if key vault were read
+	if err := d.setCertificateExpiry(nil, cluster); err != nil {
+		t.Fatalf("setCertificateExpiry failed: %+v", err)
+	}
+	assert.Equal(t, expiry, d.Get("certificate_expiry"), "known certificates shouldn't be read again")
+
+	d = mockClusterResourceData("cluster", "southcentralus", "rg", "dnsprefix")
+	if err := d.Set("pending_certificate_fingerprint", "abc"); err != nil {
+		t.Fatalf("failed to set pending certificate fingerprint: %+v", err)
+	}
+	if err := d.setCertificateExpiry(nil, cluster); err != nil {
+		t.Fatalf("setCertificateExpiry failed: %+v", err)
+	}
+	assert.Empty(t, d.Get("certificate_expiry"), "certificates shouldn't be read while a rotation is pending")
+}
+
+func TestCustomizeDiffCertificateRotation(t *testing.T) {
+	cases := []struct {
+		Count       int
+		ExpectError bool
+	}{
+		{
+			Count:       1,
+			ExpectError: false,
+		},
+		{
+			Count:       3,
+			ExpectError: true,
+		},
+	}
+
+	for _, tc := range cases {
+		raw := mockClusterRawConfig("cluster", "southcentralus", "rg", "dnsprefix")
+		raw["master_profile"] = []interface{}{
+			map[string]interface{}{"count": tc.Count, "dns_name_prefix": "dnsprefix"},
+		}
+		state, err := applyCluster(raw, base64Encode(`{"properties": {"agentPoolProfiles": [{"name": "agentpool1"}]}}`))
+		if err != nil {
+			t.Fatalf("failed to create cluster state: %+v", err)
+		}
+
+		raw["certificate_rotation_id"] = "1"
+		if _, err = diffClusterState(state, raw); err != nil {
+			t.Fatalf("rotating leaf certificates with %d masters should be allowed: %+v", tc.Count, err)
+		}
+
+		raw["certificate_authority_rotation_id"] = "1"
+		_, err = diffClusterState(state, raw)
+		if tc.ExpectError {
+			assert.NotNil(t, err, "rotating the certificate authority with %d masters should fail", tc.Count)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+}
@@ -81,11 +81,11 @@ func createDeployment(c *ArmClient, resourceGroup string, name string, deploymen
 	log.Println("[INFO] Deployment created (1)")
 
 	if err = future.WaitForCompletion(c.StopContext, deployClient.Client); err != nil {
-		return fmt.Errorf("error creating deployment: %+v", err)
+		return deploymentError(c, resourceGroup, name, err)
 	}
 	_, err = future.Result(deployClient)
 	if err != nil {
-		return fmt.Errorf("error getting deployment result")
+		return deploymentError(c, resourceGroup, name, fmt.Errorf("error getting deployment result: %+v", err))
 	}
 	// check response status code
 	log.Println("[INFO] Deployment successful")
@@ -53,7 +53,7 @@ func TestGenerateTemplateBasic(t *testing.T) {
 		agentPoolName = "agentpool1"
 		agentPoolProfile1 := tester.MockFlattenAgentPoolProfiles(agentPoolName, tc.AgentPoolCount+1, vmSize, 0, false)
 		agentPoolProfiles = append(agentPoolProfiles, agentPoolProfile1)
-		d.Set("agent_pool_profiles", &agentPoolProfiles)
+		d.Set("agent_pool_profiles", agentPoolProfiles)
 
 		cluster, err := d.setContainerService()
 		if err != nil {
@@ -124,7 +124,7 @@ func TestGenerateTemplateCustomized(t *testing.T) {
 		agentPoolName = "agentpool1"
 		agentPoolProfile1 := tester.MockFlattenAgentPoolProfiles(agentPoolName, tc.AgentPoolCount+1, tc.AgentVMSize, 0, false)
 		agentPoolProfiles = append(agentPoolProfiles, agentPoolProfile1)
-		d.Set("agent_pool_profiles", &agentPoolProfiles)
+		d.Set("agent_pool_profiles", agentPoolProfiles)
 
 		cluster, err := d.setContainerService()
 		if err != nil {
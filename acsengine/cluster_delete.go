@@ -0,0 +1,275 @@
+package acsengine
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Azure/acs-engine/pkg/acsengine"
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-05-01/resources"
+	"github.com/Azure/terraform-provider-acsengine/internal/operations"
+	"github.com/hashicorp/go-multierror"
+)
+
+// By default a cluster is deleted along with its resource group. When the resource group is shared, only the
+// resources acs-engine deployed for the cluster are deleted. acs-engine tags the cluster's VMs and scale sets with
+// its name suffix and names every other resource it creates after a fixed pattern ending in or followed by the
+// suffix, and its deployments take the suffix as a template parameter, so that's how the cluster's resources are
+// told apart from the rest of the group. Resources that merely have the suffix somewhere in their name are left.
+
+// resources are deleted in stages so that none is deleted while another one still refers to it
+var clusterResourceDeletionStages = [][]string{
+	{"Microsoft.Compute/virtualMachines", "Microsoft.Compute/virtualMachineScaleSets"},
+	{"Microsoft.Network/networkInterfaces", "Microsoft.Compute/disks"},
+	{"Microsoft.Network/loadBalancers"},
+	{"Microsoft.Network/publicIPAddresses"},
+	{"Microsoft.Compute/availabilitySets", "Microsoft.Network/virtualNetworks", "Microsoft.Storage/storageAccounts"},
+	{"Microsoft.Network/networkSecurityGroups", "Microsoft.Network/routeTables"},
+}
+
+// deletes the cluster's resources and deployments, leaving everything else in the resource group
+func deleteClusterResources(d *resourceData, c *ArmClient, resourceGroup string) error {
+	cluster, err := d.loadContainerServiceFromApimodel(false, false)
+	if err != nil {
+		return fmt.Errorf("error parsing the api model: %+v", err)
+	}
+	nameSuffix := acsengine.GenerateClusterID(cluster.Properties)
+	dnsPrefix := cluster.Properties.MasterProfile.DNSPrefix
+
+	isCluster := func(r resources.GenericResource) bool { return isClusterResource(r, nameSuffix, dnsPrefix) }
+	clusterResources, err := listResources(c, resourceGroup, isCluster)
+	if err != nil {
+		return err
+	}
+	stages, skipped := clusterResourceStages(clusterResources)
+	for _, r := range skipped {
+		log.Printf("[WARN] not deleting %q since resources of type %q aren't deleted with the cluster", *r.Name, *r.Type)
+	}
+	for _, stage := range stages {
+		if err = deleteResources(c, resourceGroup, stage); err != nil {
+			return err
+		}
+	}
+
+	deploymentName, _, err := deploymentNameAndResourceGroup(d.Id())
+	if err != nil {
+		return fmt.Errorf("error parsing Azure resource ID %q: %+v", d.Id(), err)
+	}
+	return deleteClusterDeployments(c, resourceGroup, deploymentName, nameSuffix)
+}
+
+// returns the resources in the resource group for which include returns true
+func listResources(c *ArmClient, resourceGroup string, include func(resources.GenericResource) bool) ([]resources.GenericResource, error) {
+	iter, err := c.resourcesClient.ListByResourceGroupComplete(c.StopContext, resourceGroup, "", "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list resources in resource group %q: %+v", resourceGroup, err)
+	}
+	included := []resources.GenericResource{}
+	for iter.NotDone() {
+		if r := iter.Value(); r.Name != nil && r.Type != nil && include(r) {
+			included = append(included, r)
+		}
+		if err = iter.Next(); err != nil {
+			return nil, fmt.Errorf("failed to list resources in resource group %q: %+v", resourceGroup, err)
+		}
+	}
+	return included, nil
+}
+
+// the names acs-engine gives the cluster's resources, with the name suffix and the prefix of it used for Windows nodes
+var clusterResourceNamePatterns = []string{
+	`^k8s-[a-z0-9]+-%[1]s-`, // VMs, scale sets, NICs, disks, network security groups and route tables
+	`^k8s-master-(lb|internal-lb)-%[1]s$`,
+	`^k8s-master-ip-.+-%[1]s$`,
+	`^k8s-vnet-%[1]s$`,
+	`^[a-z0-9]+-availabilityset-%[1]s$`,
+	`^%[2]sk8s9[0-9]{2}`, // Windows VMs, NICs and disks
+}
+
+// returns whether the resource was deployed for the cluster with the given name suffix and DNS prefix
+func isClusterResource(r resources.GenericResource, nameSuffix, dnsPrefix string) bool {
+	if r.Name == nil || r.Type == nil || nameSuffix == "" {
+		return false
+	}
+	if _, ok := operations.ClusterPoolName(r.Tags, nameSuffix); ok {
+		return true
+	}
+	name := strings.ToLower(*r.Name)
+	if name == diagnosticsStorageAccountName(dnsPrefix, nameSuffix) {
+		return true
+	}
+	suffix := regexp.QuoteMeta(strings.ToLower(nameSuffix))
+	windowsPrefix := regexp.QuoteMeta(strings.ToLower(operations.WindowsResourceNamePrefix(nameSuffix)))
+	for _, pattern := range clusterResourceNamePatterns {
+		if regexp.MustCompile(fmt.Sprintf(pattern, suffix, windowsPrefix)).MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// groups the resources by deletion stage, and returns the resources of types that aren't deleted separately
+func clusterResourceStages(clusterResources []resources.GenericResource) ([][]resources.GenericResource, []resources.GenericResource) {
+	stages := make([][]resources.GenericResource, len(clusterResourceDeletionStages))
+	skipped := []resources.GenericResource{}
+	for _, r := range clusterResources {
+		stage := resourceDeletionStage(*r.Type)
+		if stage < 0 {
+			skipped = append(skipped, r)
+			continue
+		}
+		stages[stage] = append(stages[stage], r)
+	}
+	return stages, skipped
+}
+
+func resourceDeletionStage(resourceType string) int {
+	for i, types := range clusterResourceDeletionStages {
+		for _, t := range types {
+			if strings.EqualFold(t, resourceType) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// deletes the resources in parallel and returns an error listing every resource that couldn't be deleted
+func deleteResources(c *ArmClient, resourceGroup string, stage []resources.GenericResource) error {
+	var mu sync.Mutex
+	var result *multierror.Error
+	var wg sync.WaitGroup
+	for _, r := range stage {
+		wg.Add(1)
+		go func(resourceType, name string) {
+			defer wg.Done()
+			log.Printf("[INFO] deleting %q", name)
+			if err := c.deleteResource(resourceGroup, resourceType, name); err != nil {
+				mu.Lock()
+				result = multierror.Append(result, fmt.Errorf("failed to delete %q: %+v", name, err))
+				mu.Unlock()
+			}
+		}(*r.Type, *r.Name)
+	}
+	wg.Wait()
+	return result.ErrorOrNil()
+}
+
+func (c *ArmClient) deleteResource(resourceGroup, resourceType, name string) error {
+	ctx := c.StopContext
+	switch strings.ToLower(resourceType) {
+	case "microsoft.compute/virtualmachines":
+		future, err := c.virtualMachinesClient.Delete(ctx, resourceGroup, name)
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.virtualMachinesClient.Client)
+	case "microsoft.compute/virtualmachinescalesets":
+		future, err := c.vmScaleSetsClient.Delete(ctx, resourceGroup, name)
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.vmScaleSetsClient.Client)
+	case "microsoft.compute/disks":
+		future, err := c.disksClient.Delete(ctx, resourceGroup, name)
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.disksClient.Client)
+	case "microsoft.compute/availabilitysets":
+		_, err := c.availabilitySetsClient.Delete(ctx, resourceGroup, name)
+		return err
+	case "microsoft.network/networkinterfaces":
+		future, err := c.interfacesClient.Delete(ctx, resourceGroup, name)
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.interfacesClient.Client)
+	case "microsoft.network/loadbalancers":
+		future, err := c.loadBalancersClient.Delete(ctx, resourceGroup, name)
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.loadBalancersClient.Client)
+	case "microsoft.network/publicipaddresses":
+		future, err := c.publicIPAddressesClient.Delete(ctx, resourceGroup, name)
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.publicIPAddressesClient.Client)
+	case "microsoft.network/virtualnetworks":
+		future, err := c.virtualNetworksClient.Delete(ctx, resourceGroup, name)
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.virtualNetworksClient.Client)
+	case "microsoft.network/networksecuritygroups":
+		future, err := c.securityGroupsClient.Delete(ctx, resourceGroup, name)
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.securityGroupsClient.Client)
+	case "microsoft.network/routetables":
+		future, err := c.routeTablesClient.Delete(ctx, resourceGroup, name)
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.routeTablesClient.Client)
+	case "microsoft.storage/storageaccounts":
+		_, err := c.storageAccountsClient.Delete(ctx, resourceGroup, name)
+		return err
+	}
+	return fmt.Errorf("resources of type %q can't be deleted", resourceType)
+}
+
+// deletes the cluster's deployment and the deployments acs-engine made to scale and upgrade it
+func deleteClusterDeployments(c *ArmClient, resourceGroup, deploymentName, nameSuffix string) error {
+	iter, err := c.deploymentsClient.ListByResourceGroupComplete(c.StopContext, resourceGroup, "", nil)
+	if err != nil {
+		return fmt.Errorf("failed to list deployments in resource group %q: %+v", resourceGroup, err)
+	}
+	names := []string{}
+	for iter.NotDone() {
+		if deployment := iter.Value(); isClusterDeployment(deployment, deploymentName, nameSuffix) {
+			names = append(names, *deployment.Name)
+		}
+		if err = iter.Next(); err != nil {
+			return fmt.Errorf("failed to list deployments in resource group %q: %+v", resourceGroup, err)
+		}
+	}
+
+	for _, name := range names {
+		future, err := c.deploymentsClient.Delete(c.StopContext, resourceGroup, name)
+		if err != nil {
+			return fmt.Errorf("failed to delete deployment %q: %+v", name, err)
+		}
+		if err = future.WaitForCompletion(c.StopContext, c.deploymentsClient.Client); err != nil {
+			return fmt.Errorf("failed to delete deployment %q: %+v", name, err)
+		}
+	}
+	return nil
+}
+
+func isClusterDeployment(deployment resources.DeploymentExtended, deploymentName, nameSuffix string) bool {
+	if deployment.Name == nil {
+		return false
+	}
+	if strings.EqualFold(*deployment.Name, deploymentName) {
+		return true
+	}
+	if deployment.Properties == nil {
+		return false
+	}
+	parameters, ok := deployment.Properties.Parameters.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	parameter, ok := parameters["nameSuffix"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	value, ok := parameter["value"].(string)
+	return ok && value == nameSuffix
+}
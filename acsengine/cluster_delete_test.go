@@ -0,0 +1,170 @@
+package acsengine
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-05-01/resources"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockGenericResource(resourceType, name string, tags map[string]*string) resources.GenericResource {
+	return resources.GenericResource{
+		Type: to.StringPtr(resourceType),
+		Name: to.StringPtr(name),
+		Tags: tags,
+	}
+}
+
+func TestIsClusterResource(t *testing.T) {
+	cases := []struct {
+		Resource resources.GenericResource
+		Expected bool
+	}{
+		{
+			Resource: mockGenericResource("Microsoft.Compute/virtualMachineScaleSets", "k8s-agentpool1-vmss", map[string]*string{
+				"poolName":           to.StringPtr("agentpool1"),
+				"resourceNameSuffix": to.StringPtr("12345678"),
+			}),
+			Expected: true,
+		},
+		{
+			Resource: mockGenericResource("Microsoft.Compute/virtualMachineScaleSets", "k8s-agentpool1-vmss", map[string]*string{
+				"poolName":           to.StringPtr("agentpool1"),
+				"resourceNameSuffix": to.StringPtr("87654321"),
+			}),
+			Expected: false,
+		},
+		{
+			Resource: mockGenericResource("Microsoft.Compute/virtualMachineScaleSets", "k8s-agentpool1-vmss", map[string]*string{
+				"poolName":           to.StringPtr("agentpool1"),
+				"resourceNameSuffix": to.StringPtr(""),
+			}),
+			Expected: false,
+		},
+		{
+			Resource: mockGenericResource("Microsoft.Network/loadBalancers", "k8s-master-lb-12345678", nil),
+			Expected: true,
+		},
+		{
+			Resource: mockGenericResource("Microsoft.Network/loadBalancers", "k8s-master-internal-lb-12345678", nil),
+			Expected: true,
+		},
+		{
+			Resource: mockGenericResource("Microsoft.Compute/disks", "k8s-master-12345678-0_OsDisk_1_0123456789abcdef", nil),
+			Expected: true,
+		},
+		{
+			Resource: mockGenericResource("Microsoft.Network/networkInterfaces", "12345k8s900nic-0", nil),
+			Expected: true,
+		},
+		{
+			Resource: mockGenericResource("Microsoft.Compute/availabilitySets", "agentpool1-availabilitySet-12345678", nil),
+			Expected: true,
+		},
+		{
+			Resource: mockGenericResource("Microsoft.Storage/storageAccounts", "dnsprefixdiag12345678", nil),
+			Expected: true,
+		},
+		{
+			Resource: mockGenericResource("Microsoft.Network/publicIPAddresses", "shared-ip", nil),
+			Expected: false,
+		},
+		{
+			Resource: mockGenericResource("Microsoft.Network/publicIPAddresses", "shared-12345678-ip", nil),
+			Expected: false,
+		},
+		{
+			Resource: mockGenericResource("Microsoft.Network/virtualNetworks", "k8s-vnet-123456789", nil),
+			Expected: false,
+		},
+		{
+			Resource: resources.GenericResource{Type: to.StringPtr("Microsoft.Network/loadBalancers")},
+			Expected: false,
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.Expected, isClusterResource(tc.Resource, "12345678", "dnsprefix"), "resource %q", *tc.Resource.Type)
+	}
+}
+
+func TestClusterResourceStages(t *testing.T) {
+	clusterResources := []resources.GenericResource{
+		mockGenericResource("Microsoft.Network/publicIPAddresses", "k8s-master-ip-dnsprefix-12345678", nil),
+		mockGenericResource("Microsoft.Network/networkInterfaces", "k8s-master-12345678-nic-0", nil),
+		mockGenericResource("microsoft.compute/virtualmachines", "k8s-master-12345678-0", nil),
+		mockGenericResource("Microsoft.Network/loadBalancers", "k8s-master-lb-12345678", nil),
+		mockGenericResource("Microsoft.Compute/disks", "k8s-master-12345678-0-etcddisk", nil),
+		mockGenericResource("Microsoft.Network/virtualNetworks", "k8s-vnet-12345678", nil),
+		mockGenericResource("Microsoft.Network/networkSecurityGroups", "k8s-master-12345678-nsg", nil),
+		mockGenericResource("Microsoft.Web/sites", "site12345678", nil),
+	}
+
+	stages, skipped := clusterResourceStages(clusterResources)
+	names := [][]string{}
+	for _, stage := range stages {
+		stageNames := []string{}
+		for _, r := range stage {
+			stageNames = append(stageNames, *r.Name)
+		}
+		names = append(names, stageNames)
+	}
+
+	expected := [][]string{
+		{"k8s-master-12345678-0"},
+		{"k8s-master-12345678-nic-0", "k8s-master-12345678-0-etcddisk"},
+		{"k8s-master-lb-12345678"},
+		{"k8s-master-ip-dnsprefix-12345678"},
+		{"k8s-vnet-12345678"},
+		{"k8s-master-12345678-nsg"},
+	}
+	assert.Equal(t, expected, names)
+	assert.Len(t, skipped, 1)
+	assert.Equal(t, "site12345678", *skipped[0].Name)
+}
+
+func TestIsClusterDeployment(t *testing.T) {
+	cases := []struct {
+		Deployment resources.DeploymentExtended
+		Expected   bool
+	}{
+		{
+			Deployment: resources.DeploymentExtended{Name: to.StringPtr("cluster")},
+			Expected:   true,
+		},
+		{
+			Deployment: resources.DeploymentExtended{
+				Name: to.StringPtr("agent-18-10-17T12.00.00-1234"),
+				Properties: &resources.DeploymentPropertiesExtended{
+					Parameters: map[string]interface{}{
+						"nameSuffix": map[string]interface{}{"type": "String", "value": "12345678"},
+					},
+				},
+			},
+			Expected: true,
+		},
+		{
+			Deployment: resources.DeploymentExtended{
+				Name: to.StringPtr("agent-18-10-17T12.00.00-1234"),
+				Properties: &resources.DeploymentPropertiesExtended{
+					Parameters: map[string]interface{}{
+						"nameSuffix": map[string]interface{}{"type": "String", "value": "87654321"},
+					},
+				},
+			},
+			Expected: false,
+		},
+		{
+			Deployment: resources.DeploymentExtended{
+				Name:       to.StringPtr("network"),
+				Properties: &resources.DeploymentPropertiesExtended{},
+			},
+			Expected: false,
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.Expected, isClusterDeployment(tc.Deployment, "cluster", "12345678"), "deployment %q", *tc.Deployment.Name)
+	}
+}
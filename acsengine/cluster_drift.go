@@ -0,0 +1,185 @@
+package acsengine
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/Azure/acs-engine/pkg/acsengine"
+	"github.com/Azure/terraform-provider-acsengine/internal/operations"
+	"github.com/Azure/terraform-provider-acsengine/internal/response"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// The api model only records what was last deployed, so Read also looks at the cluster's VMs and scale sets to find
+// nodes added, removed or resized outside of Terraform. The node counts and VM sizes found are set in state so the
+// next plan puts the cluster back to its configuration, the same way scaling and resizing already work from the
+// nodes that are actually deployed. Auto scaled pools keep the count the autoscaler chose. The cluster is only
+// considered gone when its resource group or all of its VMs and scale sets are, since Azure prunes old deployments
+// and deleting a cluster from a shared resource group removes its deployments.
+
+// the nodes currently deployed for an agent pool or the masters
+type poolNodes struct {
+	count   int
+	vmSizes map[string]int // number of nodes of each VM size
+}
+
+// returns the nodes currently deployed for each agent pool and the masters, keyed by lowercase pool name
+func getClusterNodes(c *ArmClient, cluster *containerService, resourceGroup string) (map[string]*poolNodes, error) {
+	nameSuffix := acsengine.GenerateClusterID(cluster.Properties)
+	nodes := map[string]*poolNodes{}
+	add := func(poolName, vmSize string, count int) {
+		pool, ok := nodes[poolName]
+		if !ok {
+			pool = &poolNodes{vmSizes: map[string]int{}}
+			nodes[poolName] = pool
+		}
+		pool.count += count
+		if vmSize != "" && count > 0 {
+			pool.vmSizes[vmSize] += count
+		}
+	}
+
+	vmssList, err := c.vmScaleSetsClient.List(c.StopContext, resourceGroup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scale sets in resource group %q: %+v", resourceGroup, err)
+	}
+	for vmssList.NotDone() {
+		for _, vmss := range vmssList.Values() {
+			poolName, ok := operations.ClusterPoolName(vmss.Tags, nameSuffix)
+			if !ok || vmss.Sku == nil || vmss.Sku.Capacity == nil {
+				continue
+			}
+			vmSize := ""
+			if vmss.Sku.Name != nil {
+				vmSize = *vmss.Sku.Name
+			}
+			add(poolName, vmSize, int(*vmss.Sku.Capacity))
+		}
+		if err = vmssList.Next(); err != nil {
+			return nil, fmt.Errorf("failed to list scale sets in resource group %q: %+v", resourceGroup, err)
+		}
+	}
+
+	vmList, err := c.virtualMachinesClient.List(c.StopContext, resourceGroup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VMs in resource group %q: %+v", resourceGroup, err)
+	}
+	for vmList.NotDone() {
+		for _, vm := range vmList.Values() {
+			poolName, ok := operations.ClusterPoolName(vm.Tags, nameSuffix)
+			if !ok {
+				continue
+			}
+			vmSize := ""
+			if vm.VirtualMachineProperties != nil && vm.HardwareProfile != nil {
+				vmSize = string(vm.HardwareProfile.VMSize)
+			}
+			add(poolName, vmSize, 1)
+		}
+		if err = vmList.Next(); err != nil {
+			return nil, fmt.Errorf("failed to list VMs in resource group %q: %+v", resourceGroup, err)
+		}
+	}
+
+	return nodes, nil
+}
+
+// returns the number of nodes of each pool
+func poolNodeCounts(nodes map[string]*poolNodes) map[string]int {
+	counts := map[string]int{}
+	for poolName, pool := range nodes {
+		counts[poolName] = pool.count
+	}
+	return counts
+}
+
+// returns the VM size most of the pool's nodes have if it isn't the configured size, or "" if there's no drift
+func driftedVMSize(vmSize string, pool *poolNodes) string {
+	if pool == nil {
+		return ""
+	}
+	sizes := []string{}
+	for size := range pool.vmSizes {
+		if !strings.EqualFold(size, vmSize) {
+			sizes = append(sizes, size)
+		}
+	}
+	if len(sizes) == 0 {
+		return ""
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		if pool.vmSizes[sizes[i]] != pool.vmSizes[sizes[j]] {
+			return pool.vmSizes[sizes[i]] > pool.vmSizes[sizes[j]]
+		}
+		return sizes[i] < sizes[j]
+	})
+	return sizes[0]
+}
+
+// sets the node counts and VM sizes of the agent pools from the nodes deployed, and reports masters that drifted
+func (d *resourceData) setClusterNodeDrift(nodes map[string]*poolNodes) error {
+	profiles := d.Get("agent_pool_profiles").(*schema.Set).List()
+	for _, p := range profiles {
+		profile := p.(map[string]interface{})
+		name := profile["name"].(string)
+		pool := nodes[strings.ToLower(name)]
+		count := 0
+		if pool != nil {
+			count = pool.count
+		}
+		if !profile["enable_auto_scaling"].(bool) && profile["count"].(int) != count {
+			log.Printf("[WARN] agent pool %q has %d nodes instead of %d", name, count, profile["count"].(int))
+			profile["count"] = count
+		}
+		if vmSize := driftedVMSize(profile["vm_size"].(string), pool); vmSize != "" {
+			log.Printf("[WARN] agent pool %q has nodes of size %q instead of %q", name, vmSize, profile["vm_size"].(string))
+			profile["vm_size"] = vmSize
+		}
+	}
+	if err := d.Set("agent_pool_profiles", profiles); err != nil {
+		return fmt.Errorf("Error setting 'agent_pool_profiles': %+v", err)
+	}
+
+	masterProfiles := d.Get("master_profile").([]interface{})
+	if len(masterProfiles) == 0 {
+		return nil
+	}
+	masterProfile := masterProfiles[0].(map[string]interface{})
+	masters := nodes["master"]
+	// masters can only be added, a missing master is still an etcd member, and masters can't be resized in place,
+	// so their drift is only reported
+	if masters != nil && masters.count != masterProfile["count"].(int) {
+		log.Printf("[WARN] cluster has %d masters instead of %d, which can't be fixed by Terraform", masters.count, masterProfile["count"].(int))
+	}
+	if vmSize := driftedVMSize(masterProfile["vm_size"].(string), masters); vmSize != "" {
+		log.Printf("[WARN] masters have size %q instead of %q, which can only be fixed by recreating the cluster", vmSize, masterProfile["vm_size"].(string))
+	}
+
+	return nil
+}
+
+// returns whether the cluster's resource group still exists
+func clusterResourceGroupExists(c *ArmClient, resourceGroup string) (bool, error) {
+	group, err := c.resourceGroupsClient.Get(c.StopContext, resourceGroup)
+	if err != nil {
+		if response.WasNotFound(group.Response.Response) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get resource group %q: %+v", resourceGroup, err)
+	}
+	return true, nil
+}
+
+// returns whether the deployment that created the cluster still exists
+func clusterDeploymentExists(c *ArmClient, resourceGroup, deploymentName string) (bool, error) {
+	deployment, err := c.deploymentsClient.Get(c.StopContext, resourceGroup, deploymentName)
+	if err != nil {
+		if response.WasNotFound(deployment.Response.Response) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get deployment %q: %+v", deploymentName, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,102 @@
+package acsengine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDriftedVMSize(t *testing.T) {
+	cases := []struct {
+		VMSize   string
+		Pool     *poolNodes
+		Expected string
+	}{
+		{
+			VMSize:   "Standard_D2_v2",
+			Pool:     nil,
+			Expected: "",
+		},
+		{
+			VMSize:   "Standard_D2_v2",
+			Pool:     &poolNodes{count: 2, vmSizes: map[string]int{"standard_d2_v2": 2}},
+			Expected: "",
+		},
+		{
+			VMSize:   "Standard_D2_v2",
+			Pool:     &poolNodes{count: 3, vmSizes: map[string]int{"Standard_D2_v2": 2, "Standard_D4_v2": 1}},
+			Expected: "Standard_D4_v2",
+		},
+		{
+			VMSize:   "Standard_D2_v2",
+			Pool:     &poolNodes{count: 3, vmSizes: map[string]int{"Standard_D8_v2": 1, "Standard_D4_v2": 2}},
+			Expected: "Standard_D4_v2",
+		},
+		{
+			VMSize:   "Standard_D2_v2",
+			Pool:     &poolNodes{count: 2, vmSizes: map[string]int{"Standard_D8_v2": 1, "Standard_D4_v2": 1}},
+			Expected: "Standard_D4_v2",
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.Expected, driftedVMSize(tc.VMSize, tc.Pool))
+	}
+}
+
+func TestPoolNodeCounts(t *testing.T) {
+	nodes := map[string]*poolNodes{
+		"master":     {count: 3, vmSizes: map[string]int{"Standard_D2_v2": 3}},
+		"agentpool1": {count: 0, vmSizes: map[string]int{}},
+	}
+
+	assert.Equal(t, map[string]int{"master": 3, "agentpool1": 0}, poolNodeCounts(nodes))
+}
+
+func TestSetClusterNodeDrift(t *testing.T) {
+	d := mockClusterResourceData("cluster", "southcentralus", "rg", "dnsprefix")
+
+	nodes := map[string]*poolNodes{
+		"master":     {count: 3, vmSizes: map[string]int{"Standard_D4_v2": 3}},
+		"agentpool1": {count: 4, vmSizes: map[string]int{"Standard_D4_v2": 4}},
+	}
+	if err := d.setClusterNodeDrift(nodes); err != nil {
+		t.Fatalf("setClusterNodeDrift failed: %+v", err)
+	}
+
+	agentPool1, agentPool2 := mockAgentPool(d, "agentpool1"), mockAgentPool(d, "agentpool2")
+	assert.Equal(t, 4, agentPool1["count"].(int))
+	assert.Equal(t, "Standard_D4_v2", agentPool1["vm_size"].(string))
+	assert.Equal(t, 0, agentPool2["count"].(int), "a pool without nodes should have a count of 0")
+	assert.Equal(t, "Standard_D2_v2", agentPool2["vm_size"].(string))
+	assert.Equal(t, 1, d.Get("master_profile.0.count").(int), "master count drift should only be reported")
+	assert.Equal(t, "Standard_D2_v2", d.Get("master_profile.0.vm_size").(string), "master VM size should not change")
+}
+
+func TestSetClusterNodeDriftAutoScaling(t *testing.T) {
+	d := mockClusterResourceData("cluster", "southcentralus", "rg", "dnsprefix")
+	profiles := []interface{}{
+		map[string]interface{}{
+			"name":                "agentpool1",
+			"count":               1,
+			"vm_size":             "Standard_D2_v2",
+			"os_type":             "Linux",
+			"enable_auto_scaling": true,
+			"min_count":           1,
+			"max_count":           5,
+		},
+	}
+	if err := d.Set("agent_pool_profiles", profiles); err != nil {
+		t.Fatalf("failed to set agent pool profiles: %+v", err)
+	}
+
+	nodes := map[string]*poolNodes{
+		"agentpool1": {count: 4, vmSizes: map[string]int{"Standard_D2_v2": 4}},
+	}
+	if err := d.setClusterNodeDrift(nodes); err != nil {
+		t.Fatalf("setClusterNodeDrift failed: %+v", err)
+	}
+
+	assert.Equal(t, 1, mockAgentPool(d, "agentpool1")["count"].(int), "auto scaled pools should keep their count")
+	assert.Equal(t, 1, d.Get("master_profile.0.count").(int), "master count should not change without master nodes")
+}
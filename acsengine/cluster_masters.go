@@ -0,0 +1,414 @@
+package acsengine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/acs-engine/pkg/acsengine"
+	"github.com/Azure/acs-engine/pkg/operations/kubernetesupgrade"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
+	"github.com/Azure/terraform-provider-acsengine/internal/operations"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// Masters are added one at a time. Each new master is first added to the etcd cluster from a running master, and is
+// then deployed with etcd set to join the existing cluster instead of bootstrapping a new one. Adding a member raises
+// the etcd quorum before the new member runs, which only loses quorum when a single master becomes two: etcd stops
+// accepting writes until the second master's etcd joins. If a master fails to deploy, its member is taken out of
+// etcd again. That needs quorum too, so a single running member is restarted once with --force-new-cluster, which
+// keeps its data but forgets the other member. Masters can't be removed, since acs-engine can't take a member out of
+// etcd.
+
+const masterEtcdClusterStateExpression = "variables('masterEtcdClusterStates')[div(variables('masterCount'), 2)]"
+
+// adds masters until the cluster has count masters
+func scaleMasters(d *resourceData, c *ArmClient, count int) error {
+	cluster, err := d.loadContainerServiceFromApimodel(true, true)
+	if err != nil {
+		return fmt.Errorf("error parsing the api model: %+v", err)
+	}
+	if count < cluster.Properties.MasterProfile.Count {
+		return fmt.Errorf("the number of masters can't be decreased from %d to %d", cluster.Properties.MasterProfile.Count, count)
+	}
+
+	keyVaultSecretRef := cluster.Properties.ServicePrincipalProfile.KeyvaultSecretRef
+	clientSecret, err := getSecretFromKeyVault(c, keyVaultSecretRef.VaultID, keyVaultSecretRef.SecretName, keyVaultSecretRef.SecretVersion)
+	if err != nil {
+		return fmt.Errorf("error getting service principal key: %+v", err)
+	}
+
+	deploymentName, _, err := deploymentNameAndResourceGroup(d.Id())
+	if err != nil {
+		return fmt.Errorf("error parsing Azure resource ID %q: %+v", d.Id(), err)
+	}
+	client := operations.NewACSEngineClient(clientSecret)
+	client.ManageResourceGroup = d.managesResourceGroup()
+	if err = client.SetACSEngineClient(c.StopContext, cluster.ContainerService, d.Id()); err != nil {
+		return fmt.Errorf("error initializing client: %+v", err)
+	}
+	client.Client = operations.NewContextClient(client.Client, c.StopContext)
+	if transform := d.templateTransformer(); transform != nil {
+		client.Client = operations.NewTransformingClient(client.Client, transform)
+	}
+
+	masterVMs, err := client.ListMasterVMs(c.StopContext)
+	if err != nil {
+		return fmt.Errorf("failed to get master VMs: %+v", err)
+	}
+	masterNames := []string{}
+	for _, vm := range masterVMs {
+		masterNames = append(masterNames, *vm.Name)
+	}
+	sort.Strings(masterNames)
+	if len(masterNames) == 0 {
+		return fmt.Errorf("no master VMs found in resource group %q", client.ResourceGroupName)
+	}
+
+	if cluster.Properties.MasterProfile.Count < count {
+		if err = addEtcdPeerCertificates(c, &cluster, count); err != nil {
+			return fmt.Errorf("error adding etcd peer certificates: %+v", err)
+		}
+		cluster.Properties.MasterProfile.Count = count
+
+		// saved before any master is deployed so an interrupted apply reuses the same certificates
+		if err = cluster.saveTemplates(d, client.DeploymentDirectory); err != nil {
+			return fmt.Errorf("error saving api model: %+v", err)
+		}
+		d.SetPartial("api_model")
+	}
+
+	running := len(masterNames)
+	for _, index := range missingMasterIndexes(masterNames, count) {
+		name := masterVMName(client.NameSuffix, index)
+		peerURL, err := masterEtcdPeerURL(cluster.Properties.MasterProfile.FirstConsecutiveStaticIP, cluster.Properties.MasterProfile.Subnet, index)
+		if err != nil {
+			return fmt.Errorf("error getting etcd peer URL of %q: %+v", name, err)
+		}
+		if err = runVMCommand(c, client.ResourceGroupName, masterNames[0], linuxCommandID, etcdMemberAddScript(name, peerURL)); err != nil {
+			return fmt.Errorf("failed to add %q to etcd: %+v", name, err)
+		}
+		if err = deployMaster(c, client, deploymentName, index); err != nil {
+			if removeErr := runVMCommand(c, client.ResourceGroupName, masterNames[0], linuxCommandID, etcdMemberRemoveScript(peerURL, running == 1)); removeErr != nil {
+				log.Printf("[ERROR] failed to remove %q from etcd: %+v", name, removeErr)
+			}
+			return fmt.Errorf("failed to deploy %q: %+v", name, err)
+		}
+		running++
+		log.Printf("[INFO] master %q added", name)
+	}
+
+	return cluster.saveTemplates(d, client.DeploymentDirectory)
+}
+
+// generates peer certificates for the new masters, stores them in key vault and adds references to them
+// to the certificate profile, since acs-engine would otherwise regenerate every etcd certificate
+func addEtcdPeerCertificates(c *ArmClient, cluster *containerService, count int) error {
+	certificateProfile := cluster.Properties.CertificateProfile
+	vaultID := cluster.Properties.ServicePrincipalProfile.KeyvaultSecretRef.VaultID
+	dnsPrefix := cluster.Properties.MasterProfile.DNSPrefix
+	existing := len(certificateProfile.EtcdPeerCertificates)
+	if existing >= count {
+		return nil
+	}
+
+	vaultURI, err := getKeyVaultURI(c, vaultID)
+	if err != nil {
+		return fmt.Errorf("failed to get vault URI: %+v", err)
+	}
+	caCertificate, err := getSecret(c, vaultURI, secretName("cacrt", dnsPrefix), "")
+	if err != nil {
+		return fmt.Errorf("failed to get ca certificate: %+v", err)
+	}
+	caPrivateKey, err := getSecret(c, vaultURI, secretName("cakey", dnsPrefix), "")
+	if err != nil {
+		return fmt.Errorf("failed to get ca key: %+v", err)
+	}
+	caPair := &acsengine.PkiKeyCertPair{
+		CertificatePem: base64Decode(caCertificate),
+		PrivateKeyPem:  base64Decode(caPrivateKey),
+	}
+
+	ips, err := masterIPAddresses(cluster.Properties.MasterProfile.FirstConsecutiveStaticIP, cluster.Properties.MasterProfile.Subnet, count)
+	if err != nil {
+		return err
+	}
+	_, _, _, _, _, etcdPeerPairs, err := acsengine.CreatePki(nil, ips, acsengine.DefaultKubernetesClusterDomain, caPair, count)
+	if err != nil {
+		return fmt.Errorf("failed to create etcd peer certificates: %+v", err)
+	}
+
+	for i := existing; i < count; i++ {
+		if err = setSecret(c, vaultURI, secretName(fmt.Sprintf("etcdpeer%dcrt", i), dnsPrefix), base64Encode(etcdPeerPairs[i].CertificatePem)); err != nil {
+			return fmt.Errorf("error setting etcdpeer%d certificate: %+v", i, err)
+		}
+		if err = setSecret(c, vaultURI, secretName(fmt.Sprintf("etcdpeer%dkey", i), dnsPrefix), base64Encode(etcdPeerPairs[i].PrivateKeyPem)); err != nil {
+			return fmt.Errorf("error setting etcdpeer%d key: %+v", i, err)
+		}
+		certificateProfile.EtcdPeerCertificates = append(certificateProfile.EtcdPeerCertificates, vaultSecretRefName(fmt.Sprintf("etcdpeer%dcrt", i), vaultID, dnsPrefix))
+		certificateProfile.EtcdPeerPrivateKeys = append(certificateProfile.EtcdPeerPrivateKeys, vaultSecretRefName(fmt.Sprintf("etcdpeer%dkey", i), vaultID, dnsPrefix))
+	}
+
+	return nil
+}
+
+// the same addresses acs-engine puts in the certificates it generates when a cluster is created
+func masterIPAddresses(firstConsecutiveStaticIP, subnet string, count int) ([]net.IP, error) {
+	internalLbIP, err := masterIPAddress(firstConsecutiveStaticIP, subnet, acsengine.DefaultInternalLbStaticIPOffset)
+	if err != nil {
+		return nil, err
+	}
+	ips := []net.IP{}
+	for i := 0; i < count; i++ {
+		ip, err := masterIPAddress(firstConsecutiveStaticIP, subnet, i)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+		if i == 0 {
+			ips = append(ips, internalLbIP)
+		}
+	}
+	return ips, nil
+}
+
+func masterEtcdPeerURL(firstConsecutiveStaticIP, subnet string, index int) (string, error) {
+	ip, err := masterIPAddress(firstConsecutiveStaticIP, subnet, index)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("https://%s:%d", ip.String(), acsengine.DefaultMasterEtcdServerPort), nil
+}
+
+// returns the address offset from the first master's, which has to stay in the master subnet if one is given
+func masterIPAddress(firstConsecutiveStaticIP, subnet string, offset int) (net.IP, error) {
+	firstMasterIP := net.ParseIP(firstConsecutiveStaticIP).To4()
+	if firstMasterIP == nil {
+		return nil, fmt.Errorf("first consecutive static IP %q is not a valid IPv4 address", firstConsecutiveStaticIP)
+	}
+	first := binary.BigEndian.Uint32(firstMasterIP)
+	if offset < 0 || uint64(first)+uint64(offset) > math.MaxUint32 {
+		return nil, fmt.Errorf("first consecutive static IP %q can't be offset by %d", firstConsecutiveStaticIP, offset)
+	}
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, first+uint32(offset))
+
+	if subnet == "" {
+		return ip, nil
+	}
+	_, subnetCIDR, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, fmt.Errorf("master subnet %q is not a valid CIDR: %+v", subnet, err)
+	}
+	if !subnetCIDR.Contains(ip) {
+		return nil, fmt.Errorf("master IP %s is outside of the master subnet %q", ip.String(), subnet)
+	}
+	return ip, nil
+}
+
+func masterVMName(nameSuffix string, index int) string {
+	return fmt.Sprintf("%s%s-%d", kubernetesupgrade.MasterVMNamePrefix, nameSuffix, index)
+}
+
+// returns the indexes below count that don't have a master VM yet
+func missingMasterIndexes(masterNames []string, count int) []int {
+	existing := map[int]bool{}
+	for _, name := range masterNames {
+		parts := strings.Split(name, "-")
+		if index, err := strconv.Atoi(parts[len(parts)-1]); err == nil {
+			existing[index] = true
+		}
+	}
+
+	missing := []int{}
+	for i := 0; i < count; i++ {
+		if !existing[i] {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// skips adding the member if an earlier apply already added it
+func etcdMemberAddScript(name, peerURL string) []string {
+	return []string{
+		"set -e",
+		"set -a",
+		". /etc/environment",
+		"set +a",
+		fmt.Sprintf("if etcdctl member list | grep -q 'peerURLs=%s'; then", peerURL),
+		fmt.Sprintf("  echo 'etcd member %s already added'", name),
+		"else",
+		fmt.Sprintf("  etcdctl member add %s %s", name, peerURL),
+		"fi",
+	}
+}
+
+// removes the member with peerURL if its etcd never started, restarting the running member as a new one-member
+// cluster if forceNewCluster is set since removing a member from a cluster without quorum can't succeed
+func etcdMemberRemoveScript(peerURL string, forceNewCluster bool) []string {
+	script := []string{
+		"set -e",
+		"set -a",
+		". /etc/environment",
+		"set +a",
+		fmt.Sprintf("member=$(etcdctl member list | grep 'peerURLs=%s' || true)", peerURL),
+		"case \"$member\" in",
+		"  *unstarted*) ;;",
+		fmt.Sprintf("  *) echo 'etcd member %s is not waiting to join'; exit 0 ;;", peerURL),
+		"esac",
+	}
+	if !forceNewCluster {
+		// unstarted members are listed like 8211f1d0f64f3269[unstarted]: peerURLs=...
+		return append(script, "etcdctl member remove \"${member%%\\[*}\"")
+	}
+	return append(script,
+		"systemctl stop etcd",
+		"cp /etc/default/etcd /etc/default/etcd.bak",
+		"sed -i 's/^DAEMON_ARGS=/DAEMON_ARGS=--force-new-cluster /' /etc/default/etcd",
+		"started=true",
+		"systemctl start etcd || started=false",
+		// the flag is only needed once, later restarts have to keep the members added since
+		"mv /etc/default/etcd.bak /etc/default/etcd",
+		"\"$started\"",
+		"for i in $(seq 1 30); do etcdctl cluster-health && break; sleep 5; done",
+		"systemctl restart etcd",
+	)
+}
+
+// run command IDs for shell scripts on Linux VMs and PowerShell scripts on Windows VMs
+const (
+	linuxCommandID   = "RunShellScript"
+	windowsCommandID = "RunPowerShellScript"
+)
+
+func runVMCommand(c *ArmClient, resourceGroup, vmName, commandID string, script []string) error {
+	future, err := c.virtualMachinesClient.RunCommand(c.StopContext, resourceGroup, vmName, compute.RunCommandInput{
+		CommandID: &commandID,
+		Script:    &script,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to run command on %q: %+v", vmName, err)
+	}
+	if err = future.WaitForCompletion(c.StopContext, c.virtualMachinesClient.Client); err != nil {
+		return fmt.Errorf("failed waiting for command on %q: %+v", vmName, err)
+	}
+	result, err := future.Result(c.virtualMachinesClient)
+	if err != nil {
+		return fmt.Errorf("failed to get result of command on %q: %+v", vmName, err)
+	}
+	if result.Value != nil {
+		for _, status := range *result.Value {
+			if status.Message != nil {
+				log.Printf("[DEBUG] command output from %q: %s", vmName, *status.Message)
+			}
+			if status.Level == compute.Error {
+				return fmt.Errorf("command on %q failed", vmName)
+			}
+		}
+	}
+	return nil
+}
+
+// deploys a template containing only the master with the given index
+func deployMaster(c *ArmClient, client *operations.ACSEngineClient, deploymentName string, index int) error {
+	cluster := newContainerService(client.Cluster)
+	template, parameters, _, err := cluster.formatTemplates(false)
+	if err != nil {
+		return fmt.Errorf("failed to format templates: %+v", err)
+	}
+	templateJSON, parametersJSON, err := expandTemplates(template, parameters)
+	if err != nil {
+		return fmt.Errorf("failed to expand template and parameters: %+v", err)
+	}
+
+	if err = masterTemplate(templateJSON, index); err != nil {
+		return fmt.Errorf("error transforming the template for adding a master: %+v", err)
+	}
+	addValue(parametersJSON, "masterOffset", index)
+
+	if _, err = client.Client.DeployTemplate(c.StopContext, client.ResourceGroupName, deploymentName, templateJSON, parametersJSON); err != nil {
+		return fmt.Errorf("error deploying master template: %+v", err)
+	}
+	log.Printf("[INFO] Deployment '%s' successful", deploymentName)
+
+	return nil
+}
+
+// removes the agent pools from the template, limits the master copy loops to the new master, and makes
+// its etcd join the members that are already running
+func masterTemplate(templateJSON map[string]interface{}, index int) error {
+	resources, ok := templateJSON["resources"].([]interface{})
+	if !ok {
+		return fmt.Errorf("template has no resources")
+	}
+
+	members := []string{}
+	for i := 0; i <= index; i++ {
+		member := fmt.Sprintf("variables('masterVMNames')[%d], '=', variables('masterEtcdPeerURLs')[%d]", i, i)
+		members = append(members, member)
+	}
+	initialCluster := fmt.Sprintf("concat(%s)", strings.Join(members, ", ',', "))
+
+	filtered := []interface{}{}
+	for _, r := range resources {
+		resource, ok := r.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("template resource is not an object")
+		}
+		resourceType, _ := resource["type"].(string)
+		name, _ := resource["name"].(string)
+		isMaster := strings.Contains(name, "variables('masterVMNamePrefix')")
+
+		switch resourceType {
+		case "Microsoft.Compute/virtualMachines", "Microsoft.Compute/virtualMachines/extensions", "Microsoft.Network/networkInterfaces", "Microsoft.Compute/virtualMachineScaleSets":
+			if !isMaster {
+				continue
+			}
+		}
+
+		if copyLoop, ok := resource["copy"].(map[string]interface{}); ok {
+			switch copyLoop["count"] {
+			case "[sub(variables('masterCount'), variables('masterOffset'))]":
+				copyLoop["count"] = 1
+			case "[variables('masterCount')]":
+				// loops over every master, like role assignments, only cover the masters that exist
+				copyLoop["count"] = index + 1
+			}
+		}
+
+		if resourceType == "Microsoft.Compute/virtualMachines" && isMaster {
+			if properties, ok := resource["properties"].(map[string]interface{}); ok {
+				if osProfile, ok := properties["osProfile"].(map[string]interface{}); ok {
+					if customData, ok := osProfile["customData"].(string); ok {
+						customData = strings.Replace(customData, masterEtcdClusterStateExpression, initialCluster, -1)
+						customData = strings.Replace(customData, `--initial-cluster-state "new"`, `--initial-cluster-state "existing"`, -1)
+						osProfile["customData"] = customData
+					}
+				}
+			}
+		}
+
+		filtered = append(filtered, resource)
+	}
+	templateJSON["resources"] = filtered
+
+	return nil
+}
+
+// masters can be added but not removed
+func customizeDiffMasterCount(d *schema.ResourceDiff) error {
+	if d.Id() == "" || !d.HasChange("master_profile.0.count") {
+		return nil
+	}
+	old, new := d.GetChange("master_profile.0.count")
+	if new.(int) < old.(int) {
+		return fmt.Errorf("the number of masters can't be decreased from %d to %d", old.(int), new.(int))
+	}
+	return nil
+}
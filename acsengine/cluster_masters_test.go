@@ -0,0 +1,232 @@
+package acsengine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMasterIPAddresses(t *testing.T) {
+	ips, err := masterIPAddresses("10.240.255.5", "10.240.0.0/16", 3)
+	if err != nil {
+		t.Fatalf("masterIPAddresses failed: %+v", err)
+	}
+	addresses := []string{}
+	for _, ip := range ips {
+		addresses = append(addresses, ip.String())
+	}
+	assert.Equal(t, []string{"10.240.255.5", "10.240.255.15", "10.240.255.6", "10.240.255.7"}, addresses)
+
+	if _, err = masterIPAddresses("not an IP", "10.240.0.0/16", 3); err == nil {
+		t.Fatalf("masterIPAddresses should have failed")
+	}
+	if _, err = masterIPAddresses("10.240.255.250", "10.240.0.0/16", 3); err == nil {
+		t.Fatalf("masterIPAddresses should have failed for an internal load balancer IP outside of the subnet")
+	}
+	if _, err = masterIPAddresses("255.255.255.250", "", 3); err == nil {
+		t.Fatalf("masterIPAddresses should have failed for an internal load balancer IP past 255.255.255.255")
+	}
+}
+
+func TestMasterIPAddressesPastOctet(t *testing.T) {
+	ips, err := masterIPAddresses("10.240.0.250", "10.240.0.0/16", 3)
+	if err != nil {
+		t.Fatalf("masterIPAddresses failed: %+v", err)
+	}
+	addresses := []string{}
+	for _, ip := range ips {
+		addresses = append(addresses, ip.String())
+	}
+	assert.Equal(t, []string{"10.240.0.250", "10.240.1.4", "10.240.0.251", "10.240.0.252"}, addresses)
+}
+
+func TestMasterEtcdPeerURL(t *testing.T) {
+	cases := []struct {
+		Index    int
+		Expected string
+	}{
+		{
+			Index:    0,
+			Expected: "https://10.240.255.5:2380",
+		},
+		{
+			Index:    4,
+			Expected: "https://10.240.255.9:2380",
+		},
+	}
+
+	for _, tc := range cases {
+		peerURL, err := masterEtcdPeerURL("10.240.255.5", "10.240.0.0/16", tc.Index)
+		if err != nil {
+			t.Fatalf("masterEtcdPeerURL failed: %+v", err)
+		}
+		assert.Equal(t, tc.Expected, peerURL)
+	}
+
+	if _, err := masterEtcdPeerURL("", "10.240.0.0/16", 1); err == nil {
+		t.Fatalf("masterEtcdPeerURL should have failed")
+	}
+	if _, err := masterEtcdPeerURL("10.240.255.254", "10.240.255.0/24", 4); err == nil {
+		t.Fatalf("masterEtcdPeerURL should have failed for an IP outside of the subnet")
+	}
+}
+
+func TestMissingMasterIndexes(t *testing.T) {
+	cases := []struct {
+		MasterNames []string
+		Count       int
+		Expected    []int
+	}{
+		{
+			MasterNames: []string{"k8s-master-12345678-0"},
+			Count:       3,
+			Expected:    []int{1, 2},
+		},
+		{
+			MasterNames: []string{"k8s-master-12345678-0", "k8s-master-12345678-2"},
+			Count:       5,
+			Expected:    []int{1, 3, 4},
+		},
+		{
+			MasterNames: []string{"k8s-master-12345678-0", "k8s-master-12345678-1", "k8s-master-12345678-2"},
+			Count:       3,
+			Expected:    []int{},
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.Expected, missingMasterIndexes(tc.MasterNames, tc.Count))
+	}
+	assert.Equal(t, "k8s-master-12345678-1", masterVMName("12345678", 1))
+}
+
+func TestEtcdMemberAddScript(t *testing.T) {
+	script := strings.Join(etcdMemberAddScript("k8s-master-12345678-1", "https://10.240.255.6:2380"), "\n")
+
+	assert.Contains(t, script, "etcdctl member add k8s-master-12345678-1 https://10.240.255.6:2380")
+	assert.Contains(t, script, "grep -q 'peerURLs=https://10.240.255.6:2380'", "members that were already added should be skipped")
+}
+
+func TestEtcdMemberRemoveScript(t *testing.T) {
+	script := strings.Join(etcdMemberRemoveScript("https://10.240.255.6:2380", false), "\n")
+
+	assert.Contains(t, script, "grep 'peerURLs=https://10.240.255.6:2380'")
+	assert.Contains(t, script, "*unstarted*", "members that joined should be kept")
+	assert.Contains(t, script, "etcdctl member remove")
+	assert.NotContains(t, script, "--force-new-cluster")
+
+	script = strings.Join(etcdMemberRemoveScript("https://10.240.255.5:2380", true), "\n")
+	assert.Contains(t, script, "--force-new-cluster", "a single running member can't remove a member without quorum")
+	assert.NotContains(t, script, "etcdctl member remove")
+}
+
+func TestMasterTemplate(t *testing.T) {
+	templateJSON := map[string]interface{}{
+		"resources": []interface{}{
+			map[string]interface{}{
+				"type": "Microsoft.Network/networkInterfaces",
+				"name": "[concat(variables('masterVMNamePrefix'), 'nic-', copyIndex(variables('masterOffset')))]",
+				"copy": map[string]interface{}{"count": "[sub(variables('masterCount'), variables('masterOffset'))]"},
+			},
+			map[string]interface{}{
+				"type": "Microsoft.Compute/virtualMachines",
+				"name": "[concat(variables('masterVMNamePrefix'), copyIndex(variables('masterOffset')))]",
+				"copy": map[string]interface{}{"count": "[sub(variables('masterCount'), variables('masterOffset'))]"},
+				"properties": map[string]interface{}{
+					"osProfile": map[string]interface{}{
+						"customData": `[base64(concat('--initial-cluster ',variables('masterEtcdClusterStates')[div(variables('masterCount'), 2)],' --initial-cluster-state "new"'))]`,
+					},
+				},
+			},
+			map[string]interface{}{
+				"type": "Microsoft.Authorization/roleAssignments",
+				"name": "[guid(concat('Microsoft.Compute/virtualMachines/', variables('masterVMNamePrefix'), copyIndex(),'vmidentity'))]",
+				"copy": map[string]interface{}{"count": "[variables('masterCount')]"},
+			},
+			map[string]interface{}{
+				"type": "Microsoft.Network/networkInterfaces",
+				"name": "[concat(variables('agentpool1VMNamePrefix'), 'nic-', copyIndex(variables('agentpool1Offset')))]",
+			},
+			map[string]interface{}{
+				"type": "Microsoft.Compute/virtualMachines",
+				"name": "[concat(variables('agentpool1VMNamePrefix'), copyIndex(variables('agentpool1Offset')))]",
+			},
+			map[string]interface{}{
+				"type": "Microsoft.Compute/availabilitySets",
+				"name": "[variables('masterAvailabilitySet')]",
+			},
+		},
+	}
+
+	if err := masterTemplate(templateJSON, 1); err != nil {
+		t.Fatalf("masterTemplate failed: %+v", err)
+	}
+
+	resources := templateJSON["resources"].([]interface{})
+	assert.Equal(t, 4, len(resources), "agent pool resources should be removed")
+	for _, r := range resources {
+		assert.NotContains(t, r.(map[string]interface{})["name"], "agentpool1")
+	}
+
+	nic := resources[0].(map[string]interface{})
+	assert.Equal(t, 1, nic["copy"].(map[string]interface{})["count"], "only the new master should be deployed")
+	roleAssignment := resources[2].(map[string]interface{})
+	assert.Equal(t, 2, roleAssignment["copy"].(map[string]interface{})["count"], "loops over all masters should stop at the new master")
+
+	vm := resources[1].(map[string]interface{})
+	customData := vm["properties"].(map[string]interface{})["osProfile"].(map[string]interface{})["customData"].(string)
+	assert.Contains(t, customData, `--initial-cluster-state "existing"`)
+	assert.Contains(t, customData, "concat(variables('masterVMNames')[0], '=', variables('masterEtcdPeerURLs')[0], ',', variables('masterVMNames')[1], '=', variables('masterEtcdPeerURLs')[1])")
+	assert.NotContains(t, customData, masterEtcdClusterStateExpression)
+
+	if err := masterTemplate(map[string]interface{}{}, 1); err == nil {
+		t.Fatalf("masterTemplate should have failed without resources")
+	}
+}
+
+func TestCustomizeDiffMasterCount(t *testing.T) {
+	raw := mockClusterRawConfig("cluster", "southcentralus", "rg", "dnsprefix")
+	raw["master_profile"] = []interface{}{
+		map[string]interface{}{"count": 3, "dns_name_prefix": "dnsprefix"},
+	}
+	state, err := applyCluster(raw, base64Encode(`{"properties": {"agentPoolProfiles": [{"name": "agentpool1"}]}}`))
+	if err != nil {
+		t.Fatalf("failed to create cluster state: %+v", err)
+	}
+
+	cases := []struct {
+		Count       int
+		ExpectError bool
+	}{
+		{
+			Count:       5,
+			ExpectError: false,
+		},
+		{
+			Count:       3,
+			ExpectError: false,
+		},
+		{
+			Count:       1,
+			ExpectError: true,
+		},
+	}
+
+	for _, tc := range cases {
+		raw["master_profile"] = []interface{}{
+			map[string]interface{}{"count": tc.Count, "dns_name_prefix": "dnsprefix"},
+		}
+		diff, err := diffClusterState(state, raw)
+		if tc.ExpectError {
+			assert.NotNil(t, err, "decreasing the master count to %d should fail", tc.Count)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("diff failed: %+v", err)
+		}
+		if diff != nil {
+			assert.False(t, diff.RequiresNew(), "changing the master count to %d shouldn't recreate the cluster", tc.Count)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package acsengine
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/Azure/terraform-provider-acsengine/internal/operations"
+)
+
+// Changing an agent pool's VM size replaces its nodes in batches limited by the cluster's update strategy. Nodes
+// with the new size are surged into the pool before old nodes are drained and deleted, so by default the pool
+// never runs fewer nodes than its count. The api model is saved after every batch and the old nodes are looked up
+// again each time, so an interrupted resize carries on from where it stopped when it's applied again. Certificate
+// rotation replaces nodes the same way.
+
+// picks the nodes of an agent pool that still have to be replaced
+type outdatedNodes struct {
+	availabilitySetVMs func(c *ArmClient, sc *operations.ScaleClient, vms []string) ([]string, error)
+	scaleSetVMs        func(vms []operations.ScaleSetVM) []operations.ScaleSetVM
+}
+
+// a replace step adds or removes one node, and reports whether no outdated nodes are left
+type replaceStep func(c *ArmClient, sc *operations.ScaleClient, kubeconfig string, outdated outdatedNodes) (bool, error)
+
+// replaces every node in the agent pool with a node of the new VM size
+func resizeAgentPool(d *resourceData, c *ArmClient, name, vmSize string) error {
+	outdated := outdatedNodes{
+		availabilitySetVMs: func(c *ArmClient, sc *operations.ScaleClient, vms []string) ([]string, error) {
+			sizes, err := sc.AvailabilitySetVMSizes(c.StopContext)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get availability set VM sizes: %+v", err)
+			}
+			return outdatedAvailabilitySetVMs(vms, sizes, vmSize), nil
+		},
+		scaleSetVMs: func(vms []operations.ScaleSetVM) []operations.ScaleSetVM {
+			return outdatedScaleSetVMs(vms, vmSize)
+		},
+	}
+	// nodes surged into the pool are deployed with the new size
+	prepare := func(sc *operations.ScaleClient) error {
+		sc.AgentPool.VMSize = vmSize
+		return nil
+	}
+
+	if err := replaceAgentPoolNodes(d, c, name, outdated, prepare); err != nil {
+		return err
+	}
+	log.Printf("[INFO] agent pool %q resized to %s", name, vmSize)
+	return nil
+}
+
+// replaces the agent pool's outdated nodes in batches limited by the update strategy, after prepare has set up the
+// scale client to deploy up-to-date nodes
+func replaceAgentPoolNodes(d *resourceData, c *ArmClient, name string, outdated outdatedNodes, prepare func(sc *operations.ScaleClient) error) error {
+	cluster, err := d.loadContainerServiceFromApimodel(true, true)
+	if err != nil {
+		return fmt.Errorf("error parsing the api model: %+v", err)
+	}
+	agentIndex, ok := cluster.agentPoolIndex(name)
+	if !ok {
+		return fmt.Errorf("agent pool %q not found in api model", name)
+	}
+
+	keyVaultSecretRef := cluster.Properties.ServicePrincipalProfile.KeyvaultSecretRef
+	clientSecret, err := getSecretFromKeyVault(c, keyVaultSecretRef.VaultID, keyVaultSecretRef.SecretName, keyVaultSecretRef.SecretVersion)
+	if err != nil {
+		return fmt.Errorf("error getting service principal key: %+v", err)
+	}
+
+	sc := operations.NewScaleClient(clientSecret)
+	sc.ManageResourceGroup = d.managesResourceGroup()
+	if err = sc.SetScaleClient(c.StopContext, cluster.ContainerService, d.Id(), agentIndex, cluster.Properties.AgentPoolProfiles[agentIndex].Count); err != nil {
+		return fmt.Errorf("failed to initialize scale client: %+v", err)
+	}
+	d.setUpdateStrategy(sc)
+	d.setScaleClientVersion(sc)
+	sc.Client = operations.NewContextClient(sc.Client, c.StopContext)
+	if transform := d.templateTransformer(); transform != nil {
+		sc.Client = operations.NewTransformingClient(sc.Client, transform)
+	}
+	kubeconfig, err := cluster.getKubeConfig(c, true)
+	if err != nil {
+		return fmt.Errorf("error getting kube config: %+v", err)
+	}
+	if err = prepare(sc); err != nil {
+		return err
+	}
+
+	var step replaceStep = replaceAvailabilitySetStep
+	if !sc.AgentPool.IsAvailabilitySets() {
+		step = replaceScaleSetStep
+	}
+	for {
+		done, err := step(c, sc, kubeconfig, outdated)
+		if err != nil {
+			return err
+		}
+		if done {
+			break
+		}
+		if err = saveResizeProgress(d, sc); err != nil {
+			return fmt.Errorf("error saving progress: %+v", err)
+		}
+	}
+
+	return saveResizeProgress(d, sc)
+}
+
+func replaceAvailabilitySetStep(c *ArmClient, sc *operations.ScaleClient, kubeconfig string, outdated outdatedNodes) (bool, error) {
+	highestUsedIndex, currentNodeCount, windowsIndex, vms, err := sc.ScaleVMAS(c.StopContext)
+	if err != nil {
+		return false, fmt.Errorf("failed to get availability set VMs: %+v", err)
+	}
+	oldVMs, err := outdated.availabilitySetVMs(c, sc, vms)
+	if err != nil {
+		return false, err
+	}
+	if len(oldVMs) == 0 {
+		return true, nil
+	}
+
+	add, remove, err := replacementBatch(currentNodeCount, currentNodeCount-len(oldVMs), sc.DesiredAgentCount, sc.MaxSurge, sc.MaxUnavailable)
+	if err != nil {
+		return false, err
+	}
+	if add > 0 {
+		// deploys the VMs after the highest used index
+		if err = deployAgentPool(c, sc, highestUsedIndex+1+add, highestUsedIndex+1, windowsIndex); err != nil {
+			return false, fmt.Errorf("failed to add %d VMs: %+v", add, err)
+		}
+		return false, nil
+	}
+
+	vmsToDelete := oldVMs[len(oldVMs)-remove:]
+	if _, err = sc.DrainNodes(c.StopContext, kubeconfig, vmsToDelete); err != nil {
+		return false, fmt.Errorf("failed to drain nodes %v: %+v", vmsToDelete, err)
+	}
+	if err = deleteAvailabilitySetVMs(sc, vmsToDelete); err != nil {
+		return false, fmt.Errorf("failed to delete VMs %v: %+v", vmsToDelete, err)
+	}
+	log.Printf("[INFO] replaced VMs %v", vmsToDelete)
+	return false, nil
+}
+
+func replaceScaleSetStep(c *ArmClient, sc *operations.ScaleClient, kubeconfig string, outdated outdatedNodes) (bool, error) {
+	vms, err := sc.AgentPoolScaleSetVMs(c.StopContext)
+	if err != nil {
+		return false, fmt.Errorf("failed to get scale set VMs: %+v", err)
+	}
+	oldVMs := outdated.scaleSetVMs(vms)
+	if len(oldVMs) == 0 {
+		return true, nil
+	}
+
+	add, remove, err := replacementBatch(len(vms), len(vms)-len(oldVMs), sc.DesiredAgentCount, sc.MaxSurge, sc.MaxUnavailable)
+	if err != nil {
+		return false, err
+	}
+	if add > 0 {
+		_, _, windowsIndex, err := sc.ScaleVMSS(c.StopContext)
+		if err != nil {
+			return false, fmt.Errorf("failed to get scale set: %+v", err)
+		}
+		// updates the scale set's model with room for more instances, which get the new model
+		if err = deployAgentPool(c, sc, len(vms)+add, 0, windowsIndex); err != nil {
+			return false, fmt.Errorf("failed to add %d instances: %+v", add, err)
+		}
+		return false, nil
+	}
+
+	vmsToDelete, err := operations.ScaleSetVMsToDelete(oldVMs, len(oldVMs)-remove)
+	if err != nil {
+		return false, fmt.Errorf("failed to choose scale set VMs to replace: %+v", err)
+	}
+	nodes := []string{}
+	for _, vm := range vmsToDelete {
+		nodes = append(nodes, vm.NodeName)
+	}
+	if _, err = sc.DrainNodes(c.StopContext, kubeconfig, nodes); err != nil {
+		return false, fmt.Errorf("failed to drain nodes %v: %+v", nodes, err)
+	}
+	if err = deleteScaleSetVMs(c, sc, vmsToDelete); err != nil {
+		return false, err
+	}
+	log.Printf("[INFO] replaced nodes %v", nodes)
+	return false, nil
+}
+
+// returns the VMs whose value, like their size or version tag, isn't the new value, in the order they were listed
+func outdatedAvailabilitySetVMs(vms []string, values map[string]string, value string) []string {
+	outdated := []string{}
+	for _, vm := range vms {
+		if !strings.EqualFold(values[vm], value) {
+			outdated = append(outdated, vm)
+		}
+	}
+	return outdated
+}
+
+func outdatedScaleSetVMs(vms []operations.ScaleSetVM, vmSize string) []operations.ScaleSetVM {
+	outdated := []operations.ScaleSetVM{}
+	for _, vm := range vms {
+		if !strings.EqualFold(vm.VMSize, vmSize) {
+			outdated = append(outdated, vm)
+		}
+	}
+	return outdated
+}
+
+// replaces every node of the scale set agent pools that include picks, one pool at a time, with nodes created
+// from an updated scale set model
+func replaceScaleSetAgentPoolNodes(d *resourceData, c *ArmClient, cluster *containerService, include func(profile *api.AgentPoolProfile) bool) error {
+	outdated := outdatedNodes{
+		availabilitySetVMs: func(c *ArmClient, sc *operations.ScaleClient, vms []string) ([]string, error) {
+			return []string{}, nil
+		},
+		scaleSetVMs: outdatedModelScaleSetVMs,
+	}
+	prepare := func(sc *operations.ScaleClient) error {
+		return updateScaleSetModel(c, sc)
+	}
+	for _, profile := range cluster.Properties.AgentPoolProfiles {
+		if profile.IsAvailabilitySets() || !include(profile) {
+			continue
+		}
+		if err := replaceAgentPoolNodes(d, c, profile.Name, outdated, prepare); err != nil {
+			return fmt.Errorf("failed to replace nodes of agent pool %q: %+v", profile.Name, err)
+		}
+	}
+	return nil
+}
+
+// returns the scale set VMs that were created before the scale set's model was last updated
+func outdatedModelScaleSetVMs(vms []operations.ScaleSetVM) []operations.ScaleSetVM {
+	outdated := []operations.ScaleSetVM{}
+	for _, vm := range vms {
+		if !vm.LatestModelApplied {
+			outdated = append(outdated, vm)
+		}
+	}
+	return outdated
+}
+
+// redeploys the agent pool's scale sets at their current capacity, so that instances created before the
+// redeployment can be told apart from the ones created after it
+func updateScaleSetModel(c *ArmClient, sc *operations.ScaleClient) error {
+	vms, err := sc.AgentPoolScaleSetVMs(c.StopContext)
+	if err != nil {
+		return fmt.Errorf("failed to get scale set VMs: %+v", err)
+	}
+	_, _, windowsIndex, err := sc.ScaleVMSS(c.StopContext)
+	if err != nil {
+		return fmt.Errorf("failed to get scale set: %+v", err)
+	}
+	if err = deployAgentPool(c, sc, len(vms), 0, windowsIndex); err != nil {
+		return fmt.Errorf("failed to update scale set model: %+v", err)
+	}
+	return nil
+}
+
+// the api model is kept in state even if a later node fails, so the next apply knows the pool's new size
+func saveResizeProgress(d *resourceData, sc *operations.ScaleClient) error {
+	cluster := newContainerService(sc.Cluster)
+	if err := cluster.saveTemplates(d, sc.DeploymentDirectory); err != nil {
+		return err
+	}
+	d.SetPartial("api_model")
+	return nil
+}
@@ -0,0 +1,61 @@
+package acsengine
+
+import (
+	"testing"
+
+	"github.com/Azure/terraform-provider-acsengine/internal/operations"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutdatedAvailabilitySetVMs(t *testing.T) {
+	vms := []string{"k8s-agentpool1-12345678-0", "k8s-agentpool1-12345678-1", "k8s-agentpool1-12345678-2"}
+	sizes := map[string]string{
+		"k8s-agentpool1-12345678-0": "Standard_D2_v2",
+		"k8s-agentpool1-12345678-1": "standard_d4_v2",
+		"k8s-agentpool1-12345678-2": "Standard_D2_v2",
+	}
+
+	cases := []struct {
+		VMSize   string
+		Expected []string
+	}{
+		{
+			VMSize:   "Standard_D4_v2",
+			Expected: []string{"k8s-agentpool1-12345678-0", "k8s-agentpool1-12345678-2"},
+		},
+		{
+			VMSize:   "Standard_D2_v2",
+			Expected: []string{"k8s-agentpool1-12345678-1"},
+		},
+		{
+			VMSize:   "Standard_D8_v2",
+			Expected: vms,
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.Expected, outdatedAvailabilitySetVMs(vms, sizes, tc.VMSize), tc.VMSize)
+	}
+}
+
+func TestOutdatedScaleSetVMs(t *testing.T) {
+	vms := []operations.ScaleSetVM{
+		{ScaleSet: "k8s-agentpool1-12345678-vmss", InstanceID: "0", VMSize: "Standard_D2_v2"},
+		{ScaleSet: "k8s-agentpool1-12345678-vmss", InstanceID: "3", VMSize: "Standard_D4_v2"},
+		{ScaleSet: "k8s-agentpool1-12345678-vmss", InstanceID: "4", VMSize: "standard_d2_v2"},
+	}
+
+	outdated := outdatedScaleSetVMs(vms, "Standard_D4_v2")
+	assert.Equal(t, []operations.ScaleSetVM{vms[0], vms[2]}, outdated)
+	assert.Empty(t, outdatedScaleSetVMs(vms[1:2], "Standard_D4_v2"))
+}
+
+func TestOutdatedModelScaleSetVMs(t *testing.T) {
+	vms := []operations.ScaleSetVM{
+		{ScaleSet: "k8s-agentpool1-12345678-vmss", InstanceID: "0", LatestModelApplied: false},
+		{ScaleSet: "k8s-agentpool1-12345678-vmss", InstanceID: "3", LatestModelApplied: true},
+	}
+
+	assert.Equal(t, []operations.ScaleSetVM{vms[0]}, outdatedModelScaleSetVMs(vms))
+	assert.Empty(t, outdatedModelScaleSetVMs(vms[1:]))
+}
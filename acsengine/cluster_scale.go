@@ -1,6 +1,7 @@
 package acsengine
 
 import (
+	"context"
 	"fmt"
 	"log"
 
@@ -8,28 +9,53 @@ import (
 	"github.com/Azure/acs-engine/pkg/api"
 	"github.com/Azure/acs-engine/pkg/i18n"
 	ops "github.com/Azure/acs-engine/pkg/operations"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
 	"github.com/Azure/terraform-provider-acsengine/internal/operations"
 )
 
-func scaleCluster(d *resourceData, c *ArmClient, agentIndex, agentCount int) error {
+func scaleCluster(d *resourceData, c *ArmClient, poolName string, agentCount int) error {
 	cluster, err := d.loadContainerServiceFromApimodel(true, true)
 	if err != nil {
 		return fmt.Errorf("error parsing the api model: %+v", err)
 	}
+	agentIndex, ok := cluster.agentPoolIndex(poolName)
+	if !ok {
+		return fmt.Errorf("agent pool %q not found in api model", poolName)
+	}
 
 	keyVaultSecretRef := cluster.Properties.ServicePrincipalProfile.KeyvaultSecretRef
-	clientSecret, err := getSecretFromKeyVault(c, keyVaultSecretRef.VaultID, keyVaultSecretRef.SecretName, "")
+	clientSecret, err := getSecretFromKeyVault(c, keyVaultSecretRef.VaultID, keyVaultSecretRef.SecretName, keyVaultSecretRef.SecretVersion)
 	if err != nil {
 		return fmt.Errorf("error getting service principal key: %+v", err)
 	}
 
 	sc := operations.NewScaleClient(clientSecret)
-	if err = sc.SetScaleClient(cluster.ContainerService, d.Id(), agentIndex, agentCount); err != nil {
+	sc.ManageResourceGroup = d.managesResourceGroup()
+	if err = sc.SetScaleClient(c.StopContext, cluster.ContainerService, d.Id(), agentIndex, agentCount); err != nil {
 		return fmt.Errorf("failed to initialize scale client: %+v", err)
 	}
+	d.setUpdateStrategy(sc)
+	d.setScaleClientVersion(sc)
+	sc.Client = operations.NewContextClient(sc.Client, c.StopContext)
+	if transform := d.templateTransformer(); transform != nil {
+		sc.Client = operations.NewTransformingClient(sc.Client, transform)
+	}
+
+	if err = scaleNodes(c, sc); err != nil {
+		if checkpointErr := checkpointScale(d, sc); checkpointErr != nil {
+			log.Printf("[ERROR] failed to record scaling progress: %+v", checkpointErr)
+		}
+		return err
+	}
+	return saveScaledApimodel(d, sc, sc.DesiredAgentCount)
+}
 
+// adds or removes nodes until the agent pool has the desired count, starting from the nodes that exist in Azure
+// so a scale that failed partway isn't repeated
+func scaleNodes(c *ArmClient, sc *operations.ScaleClient) error {
 	var currentNodeCount, highestUsedIndex, windowsIndex int
 	var vms []string
+	var err error
 	if sc.AgentPool.IsAvailabilitySets() {
 		if highestUsedIndex, currentNodeCount, windowsIndex, vms, err = sc.ScaleVMAS(c.StopContext); err != nil {
 			return fmt.Errorf("failed to scale availability set: %+v", err)
@@ -43,18 +69,25 @@ func scaleCluster(d *resourceData, c *ArmClient, agentIndex, agentCount int) err
 			if err = scaleDownCluster(c, sc, currentNodeCount, vms); err != nil {
 				return fmt.Errorf("scaling down cluster failed: %+v", err)
 			}
-			return saveScaledApimodel(d, sc)
+			return nil
 		}
 	} else {
 		if highestUsedIndex, currentNodeCount, windowsIndex, err = sc.ScaleVMSS(c.StopContext); err != nil {
 			return fmt.Errorf("failed to scale scale set: %+v", err)
 		}
+
+		if currentNodeCount > sc.DesiredAgentCount {
+			if err = scaleDownScaleSet(c, sc); err != nil {
+				return fmt.Errorf("scaling down scale set failed: %+v", err)
+			}
+			return nil
+		}
 	}
 
 	if err = scaleUpCluster(c, sc, highestUsedIndex, currentNodeCount, windowsIndex); err != nil {
 		return fmt.Errorf("scaling cluster failed: %+v", err)
 	}
-	return saveScaledApimodel(d, sc)
+	return nil
 }
 
 func scaleDownCluster(c *ArmClient, sc *operations.ScaleClient, currentNodeCount int, vms []string) error {
@@ -69,33 +102,113 @@ func scaleDownCluster(c *ArmClient, sc *operations.ScaleClient, currentNodeCount
 	if err != nil {
 		return fmt.Errorf("error getting kube config: %+v", err)
 	}
-	if err = sc.DrainNodes(kubeconfig, vmsToDelete); err != nil {
-		return fmt.Errorf("Got error while draining the nodes to be deleted: %+v", err)
+	if err = drainNodesToDelete(c, sc, kubeconfig, vmsToDelete); err != nil {
+		return err
 	}
 
-	errList := ops.ScaleDownVMs(
-		sc.Client,
-		sc.Logger,
-		sc.SubscriptionID.String(),
-		sc.ResourceGroupName,
-		vmsToDelete...)
+	return deleteAvailabilitySetVMs(sc, vmsToDelete)
+}
+
+// drains the nodes with the highest instance IDs before deleting their instances, since
+// lowering the capacity would let Azure pick which instances to delete without draining them
+func scaleDownScaleSet(c *ArmClient, sc *operations.ScaleClient) error {
+	if sc.MasterFQDN == "" {
+		return fmt.Errorf("Master FQDN is required to scale down a Kubernetes cluster's agent pool")
+	}
+
+	vms, err := sc.AgentPoolScaleSetVMs(c.StopContext)
+	if err != nil {
+		return fmt.Errorf("failed to get scale set VMs: %+v", err)
+	}
+	vmsToDelete, err := operations.ScaleSetVMsToDelete(vms, sc.DesiredAgentCount)
+	if err != nil {
+		return fmt.Errorf("failed to choose scale set VMs to delete: %+v", err)
+	}
+	if len(vmsToDelete) == 0 {
+		log.Printf("[INFO] Scale set is currently at the desired agent count")
+		return nil
+	}
+
+	nodes := []string{}
+	for _, vm := range vmsToDelete {
+		nodes = append(nodes, vm.NodeName)
+	}
+	cluster := newContainerService(sc.Cluster)
+	kubeconfig, err := cluster.getKubeConfig(c, true)
+	if err != nil {
+		return fmt.Errorf("error getting kube config: %+v", err)
+	}
+	if err = drainNodesToDelete(c, sc, kubeconfig, nodes); err != nil {
+		return err
+	}
+
+	return deleteScaleSetVMs(c, sc, vmsToDelete)
+}
+
+// nothing is deleted unless every node drained, and the nodes that did are reported since they stay cordoned
+// until the scale down is retried
+func drainNodesToDelete(c *ArmClient, sc *operations.ScaleClient, kubeconfig string, nodes []string) error {
+	drained, err := sc.DrainNodes(c.StopContext, kubeconfig, nodes)
+	if err != nil {
+		return fmt.Errorf("drained %d of %d nodes to be deleted %v: %+v", len(drained), len(nodes), drained, err)
+	}
+	return nil
+}
+
+// deletes the instances from their scale sets, which lowers the scale sets' capacity
+func deleteScaleSetVMs(c *ArmClient, sc *operations.ScaleClient, vms []operations.ScaleSetVM) error {
+	for scaleSet, instanceIDs := range scaleSetInstanceIDs(vms) {
+		ids := instanceIDs
+		future, err := c.vmScaleSetsClient.DeleteInstances(c.StopContext, sc.ResourceGroupName, scaleSet, compute.VirtualMachineScaleSetVMInstanceRequiredIDs{
+			InstanceIds: &ids,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete instances %v of scale set %q: %+v", ids, scaleSet, err)
+		}
+		if err = future.WaitForCompletion(c.StopContext, c.vmScaleSetsClient.Client); err != nil {
+			return fmt.Errorf("failed waiting for instances %v of scale set %q to be deleted: %+v", ids, scaleSet, err)
+		}
+		log.Printf("[INFO] Deleted instances %v of scale set %q", ids, scaleSet)
+	}
+
+	return nil
+}
+
+// deletes the VMs along with their NICs and disks
+func deleteAvailabilitySetVMs(sc *operations.ScaleClient, vms []string) error {
+	errList := ops.ScaleDownVMs(sc.Client, sc.Logger, sc.SubscriptionID.String(), sc.ResourceGroupName, vms...)
 	if errList != nil {
 		errorMessage := ""
 		for element := errList.Front(); element != nil; element = element.Next() {
-			vmError, ok := element.Value.(*ops.VMScalingErrorDetails)
-			if ok {
-				error := fmt.Sprintf("Node '%s' failed to delete with error: '%s'", vmError.Name, vmError.Error.Error())
-				errorMessage = errorMessage + error
+			if vmError, ok := element.Value.(*ops.VMScalingErrorDetails); ok {
+				errorMessage += fmt.Sprintf("Node '%s' failed to delete with error: '%s'", vmError.Name, vmError.Error.Error())
 			}
 		}
-		return fmt.Errorf(errorMessage)
+		return fmt.Errorf("failed to delete VMs: %s", errorMessage)
 	}
 
 	return nil
 }
 
 func scaleUpCluster(c *ArmClient, sc *operations.ScaleClient, highestUsedIndex, currentNodeCount, windowsIndex int) error {
+	countForTemplate := setCountForTemplate(sc, highestUsedIndex, currentNodeCount)
+	return deployAgentPool(c, sc, countForTemplate, highestUsedIndex+1, windowsIndex)
+}
+
+// deploys a template containing only the agent pool being scaled, with count nodes starting from offset
+// for availability sets
+func deployAgentPool(c *ArmClient, sc *operations.ScaleClient, countForTemplate, offset, windowsIndex int) error {
+	agentPoolProfiles := sc.Cluster.Properties.AgentPoolProfiles
 	sc.Cluster.Properties.AgentPoolProfiles = []*api.AgentPoolProfile{sc.AgentPool}
+	// nodes join a pool that lags the control plane at the pool's version
+	orchestratorVersion := sc.Cluster.Properties.OrchestratorProfile.OrchestratorVersion
+	if sc.AgentPoolVersion != "" {
+		sc.Cluster.Properties.OrchestratorProfile.OrchestratorVersion = sc.AgentPoolVersion
+	}
+	defer func() {
+		sc.Cluster.Properties.AgentPoolProfiles = agentPoolProfiles
+		sc.Cluster.Properties.OrchestratorProfile.OrchestratorVersion = orchestratorVersion
+	}()
 
 	// don't format parameters! It messes things up
 	cluster := newContainerService(sc.Cluster)
@@ -115,7 +228,6 @@ func scaleUpCluster(c *ArmClient, sc *operations.ScaleClient, highestUsedIndex,
 		},
 	}
 
-	countForTemplate := setCountForTemplate(sc, highestUsedIndex, currentNodeCount)
 	addValue(parametersJSON, sc.AgentPoolToScale+"Count", countForTemplate)
 
 	setWindowsIndex(sc, windowsIndex, templateJSON)
@@ -124,7 +236,7 @@ func scaleUpCluster(c *ArmClient, sc *operations.ScaleClient, highestUsedIndex,
 		return fmt.Errorf("error transforming the template for scaling template: %+v", err)
 	}
 	if sc.AgentPool.IsAvailabilitySets() {
-		addValue(parametersJSON, fmt.Sprintf("%sOffset", sc.AgentPoolToScale), highestUsedIndex+1)
+		addValue(parametersJSON, fmt.Sprintf("%sOffset", sc.AgentPoolToScale), offset)
 	}
 
 	_, err = sc.Client.DeployTemplate(
@@ -141,10 +253,30 @@ func scaleUpCluster(c *ArmClient, sc *operations.ScaleClient, highestUsedIndex,
 	return nil
 }
 
-func saveScaledApimodel(d *resourceData, sc *operations.ScaleClient) error {
-	sc.Cluster.Properties.AgentPoolProfiles[sc.AgentPoolIndex].Count = sc.DesiredAgentCount
+// saves the agent pool's node count in the api model, checkpointing it so it's kept if a later update fails
+func saveScaledApimodel(d *resourceData, sc *operations.ScaleClient, count int) error {
+	sc.Cluster.Properties.AgentPoolProfiles[sc.AgentPoolIndex].Count = count
 	cluster := newContainerService(sc.Cluster)
-	return cluster.saveTemplates(d, sc.DeploymentDirectory)
+	if err := cluster.saveTemplates(d, sc.DeploymentDirectory); err != nil {
+		return err
+	}
+	d.SetPartial("api_model")
+	return nil
+}
+
+// records how many nodes the agent pool has after scaling failed, so the state matches the cluster
+func checkpointScale(d *resourceData, sc *operations.ScaleClient) error {
+	// scaling may have failed because the resource timeout passed
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointTimeout)
+	defer cancel()
+	count, err := sc.NodeCount(ctx)
+	if err != nil {
+		return fmt.Errorf("error counting agent pool nodes: %+v", err)
+	}
+	if count == sc.Cluster.Properties.AgentPoolProfiles[sc.AgentPoolIndex].Count {
+		return nil
+	}
+	return saveScaledApimodel(d, sc, count)
 }
 
 func setCountForTemplate(sc *operations.ScaleClient, highestUsedIndex, currentNodeCount int) int {
@@ -161,6 +293,14 @@ func setWindowsIndex(sc *operations.ScaleClient, windowsIndex int, templateJSON
 	}
 }
 
+func scaleSetInstanceIDs(vms []operations.ScaleSetVM) map[string][]string {
+	instanceIDs := map[string][]string{}
+	for _, vm := range vms {
+		instanceIDs[vm.ScaleSet] = append(instanceIDs[vm.ScaleSet], vm.InstanceID)
+	}
+	return instanceIDs
+}
+
 func vmsToDeleteList(vms []string, currentNodeCount, desiredNodeCount int) []string {
 	vmsToDelete := make([]string, 0)
 	for i := currentNodeCount - 1; i >= desiredNodeCount; i-- {
@@ -94,3 +94,18 @@ func TestVMsToDeleteList(t *testing.T) {
 		assert.Equal(t, vms[2], vmsToDelete[0], "first VM to delete should be last vm in original slice")
 	}
 }
+
+func TestScaleSetInstanceIDs(t *testing.T) {
+	vms := []operations.ScaleSetVM{
+		{ScaleSet: "k8s-agentpool1-12345678-vmss", InstanceID: "4"},
+		{ScaleSet: "k8s-agentpool1-12345678-vmss", InstanceID: "3"},
+		{ScaleSet: "k8s-agentpool1-87654321-vmss", InstanceID: "3"},
+	}
+
+	expected := map[string][]string{
+		"k8s-agentpool1-12345678-vmss": {"4", "3"},
+		"k8s-agentpool1-87654321-vmss": {"3"},
+	}
+	assert.Equal(t, expected, scaleSetInstanceIDs(vms))
+	assert.Empty(t, scaleSetInstanceIDs(nil))
+}
@@ -0,0 +1,99 @@
+package acsengine
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
+	"github.com/Azure/terraform-provider-acsengine/internal/operations"
+)
+
+// Nodes read the service principal secret from azure.json, which acs-engine writes when a node is deployed. A new
+// secret is written to the azure.json of every master and availability set agent with a run command, which then
+// restarts the components that read it. Scale set instances can't run commands with this API version, so their
+// nodes are replaced one at a time from an updated scale set model instead, like a resize.
+
+// points the cluster to another service principal secret, or another version of it, and pushes it to every node
+func updateServicePrincipalSecret(d *resourceData, c *ArmClient) error {
+	cluster, err := d.loadContainerServiceFromApimodel(true, true)
+	if err != nil {
+		return fmt.Errorf("error parsing the api model: %+v", err)
+	}
+	servicePrincipal, err := d.expandServicePrincipal()
+	if err != nil {
+		return fmt.Errorf("error expanding service principal: %+v", err)
+	}
+	keyvaultSecretRef := servicePrincipal.KeyvaultSecretRef
+	clientSecret, err := getSecretFromKeyVault(c, keyvaultSecretRef.VaultID, keyvaultSecretRef.SecretName, keyvaultSecretRef.SecretVersion)
+	if err != nil {
+		return fmt.Errorf("error getting service principal key: %+v", err)
+	}
+	cluster.Properties.ServicePrincipalProfile.KeyvaultSecretRef.SecretName = keyvaultSecretRef.SecretName
+	cluster.Properties.ServicePrincipalProfile.KeyvaultSecretRef.SecretVersion = keyvaultSecretRef.SecretVersion
+
+	client := operations.NewACSEngineClient(clientSecret)
+	client.ManageResourceGroup = d.managesResourceGroup()
+	if err = client.SetACSEngineClient(c.StopContext, cluster.ContainerService, d.Id()); err != nil {
+		return fmt.Errorf("error initializing client: %+v", err)
+	}
+	vms, err := client.ListClusterVMs(c.StopContext)
+	if err != nil {
+		return fmt.Errorf("failed to get cluster VMs: %+v", err)
+	}
+	for _, vm := range vms {
+		commandID, script := linuxCommandID, linuxServicePrincipalSecretScript(clientSecret)
+		if isWindowsVM(vm) {
+			commandID, script = windowsCommandID, windowsServicePrincipalSecretScript(clientSecret)
+		}
+		if err = runVMCommand(c, client.ResourceGroupName, *vm.Name, commandID, script); err != nil {
+			return fmt.Errorf("failed to update service principal secret on %q: %+v", *vm.Name, err)
+		}
+		log.Printf("[INFO] updated service principal secret on %q", *vm.Name)
+	}
+
+	// new nodes, including replaced scale set instances, get the secret the api model refers to
+	if err = cluster.saveTemplates(d, client.DeploymentDirectory); err != nil {
+		return fmt.Errorf("error saving the api model: %+v", err)
+	}
+	d.SetPartial("api_model")
+
+	allPools := func(profile *api.AgentPoolProfile) bool { return true }
+	if err = replaceScaleSetAgentPoolNodes(d, c, &cluster, allPools); err != nil {
+		return fmt.Errorf("failed to update service principal secret: %+v", err)
+	}
+
+	return nil
+}
+
+func isWindowsVM(vm compute.VirtualMachine) bool {
+	if vm.VirtualMachineProperties == nil || vm.StorageProfile == nil || vm.StorageProfile.OsDisk == nil {
+		return false
+	}
+	return vm.StorageProfile.OsDisk.OsType == compute.Windows
+}
+
+// the secret is passed base64 encoded so it doesn't need to be quoted for the shell or python
+func linuxServicePrincipalSecretScript(secret string) []string {
+	encoded := base64.StdEncoding.EncodeToString([]byte(secret))
+	return []string{
+		"set -e",
+		fmt.Sprintf(`python3 -c 'import base64, json; p = "/etc/kubernetes/azure.json"; c = json.load(open(p)); c["aadClientSecret"] = base64.b64decode("%s").decode(); json.dump(c, open(p, "w"), indent=4)'`, encoded),
+		"systemctl restart kubelet",
+		// the API server and controller manager run as static pods on masters, and restarting the kubelet doesn't restart them
+		"docker ps -q --filter name=k8s_kube-apiserver --filter name=k8s_kube-controller-manager | xargs -r docker restart",
+	}
+}
+
+func windowsServicePrincipalSecretScript(secret string) []string {
+	encoded := base64.StdEncoding.EncodeToString([]byte(secret))
+	return []string{
+		`$ErrorActionPreference = "Stop"`,
+		`$path = "c:\k\azure.json"`,
+		`$config = Get-Content $path -Raw | ConvertFrom-Json`,
+		fmt.Sprintf(`$config.aadClientSecret = [Text.Encoding]::UTF8.GetString([Convert]::FromBase64String("%s"))`, encoded),
+		`$config | ConvertTo-Json | Set-Content $path`,
+		"Restart-Service kubelet",
+	}
+}
@@ -0,0 +1,45 @@
+package acsengine
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServicePrincipalSecretScripts(t *testing.T) {
+	secret := `it's a "secret" $(rm -rf /)`
+	encoded := base64.StdEncoding.EncodeToString([]byte(secret))
+
+	for _, script := range [][]string{linuxServicePrincipalSecretScript(secret), windowsServicePrincipalSecretScript(secret)} {
+		joined := strings.Join(script, "\n")
+		assert.Contains(t, joined, encoded)
+		assert.NotContains(t, joined, secret, "the secret should only be passed encoded")
+		assert.Contains(t, joined, "aadClientSecret")
+	}
+	assert.Contains(t, linuxServicePrincipalSecretScript(secret), "systemctl restart kubelet")
+	assert.Contains(t, windowsServicePrincipalSecretScript(secret), "Restart-Service kubelet")
+}
+
+func TestIsWindowsVM(t *testing.T) {
+	windows := compute.VirtualMachine{
+		VirtualMachineProperties: &compute.VirtualMachineProperties{
+			StorageProfile: &compute.StorageProfile{
+				OsDisk: &compute.OSDisk{OsType: compute.Windows},
+			},
+		},
+	}
+	linux := compute.VirtualMachine{
+		VirtualMachineProperties: &compute.VirtualMachineProperties{
+			StorageProfile: &compute.StorageProfile{
+				OsDisk: &compute.OSDisk{OsType: compute.Linux},
+			},
+		},
+	}
+
+	assert.True(t, isWindowsVM(windows))
+	assert.False(t, isWindowsVM(linux))
+	assert.False(t, isWindowsVM(compute.VirtualMachine{}))
+}
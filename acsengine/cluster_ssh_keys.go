@@ -0,0 +1,91 @@
+package acsengine
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/Azure/terraform-provider-acsengine/internal/operations"
+)
+
+// Azure doesn't allow the SSH keys in a VM's OS profile to change once it's created, so the new key replaces the
+// admin user's authorized keys on masters and availability set agents through a run command. Linux scale set
+// pools get the key in their model and their nodes are replaced one at a time, like a resize. The VMs updated in
+// place are kept in `ssh_key_updated_nodes`.
+
+// replaces the admin SSH key on every Linux node
+func updateSSHKey(d *resourceData, c *ArmClient) error {
+	cluster, err := d.loadContainerServiceFromApimodel(true, true)
+	if err != nil {
+		return fmt.Errorf("error parsing the api model: %+v", err)
+	}
+	linuxProfile, err := d.expandLinuxProfile()
+	if err != nil {
+		return fmt.Errorf("error expanding linux profile: %+v", err)
+	}
+	cluster.Properties.LinuxProfile.SSH.PublicKeys = linuxProfile.SSH.PublicKeys
+
+	keyvaultSecretRef := cluster.Properties.ServicePrincipalProfile.KeyvaultSecretRef
+	clientSecret, err := getSecretFromKeyVault(c, keyvaultSecretRef.VaultID, keyvaultSecretRef.SecretName, keyvaultSecretRef.SecretVersion)
+	if err != nil {
+		return fmt.Errorf("error getting service principal key: %+v", err)
+	}
+	client := operations.NewACSEngineClient(clientSecret)
+	client.ManageResourceGroup = d.managesResourceGroup()
+	if err = client.SetACSEngineClient(c.StopContext, cluster.ContainerService, d.Id()); err != nil {
+		return fmt.Errorf("error initializing client: %+v", err)
+	}
+	vms, err := client.ListClusterVMs(c.StopContext)
+	if err != nil {
+		return fmt.Errorf("failed to get cluster VMs: %+v", err)
+	}
+	script := sshKeyScript(cluster.Properties.LinuxProfile.AdminUsername, linuxProfile.SSH.PublicKeys)
+	updated := []string{}
+	for _, vm := range vms {
+		if isWindowsVM(vm) {
+			continue
+		}
+		if err = runVMCommand(c, client.ResourceGroupName, *vm.Name, linuxCommandID, script); err != nil {
+			return fmt.Errorf("failed to update SSH key on %q after updating %v: %+v", *vm.Name, updated, err)
+		}
+		updated = append(updated, *vm.Name)
+		log.Printf("[INFO] updated SSH key on %q", *vm.Name)
+	}
+
+	if err = cluster.saveTemplates(d, client.DeploymentDirectory); err != nil {
+		return fmt.Errorf("error saving the api model: %+v", err)
+	}
+	d.SetPartial("api_model")
+	if err = d.Set("ssh_key_updated_nodes", updated); err != nil {
+		return fmt.Errorf("error setting `ssh_key_updated_nodes`: %+v", err)
+	}
+	d.SetPartial("ssh_key_updated_nodes")
+
+	linuxPools := func(profile *api.AgentPoolProfile) bool { return profile.OSType != api.Windows }
+	if err = replaceScaleSetAgentPoolNodes(d, c, &cluster, linuxPools); err != nil {
+		return fmt.Errorf("failed to update SSH key: %+v", err)
+	}
+
+	log.Printf("[INFO] updated SSH key on VMs %s and replaced the nodes of Linux scale set agent pools", strings.Join(updated, ", "))
+	return nil
+}
+
+// the keys are passed base64 encoded so they don't need to be quoted for the shell
+func sshKeyScript(adminUsername string, keys []api.PublicKey) []string {
+	authorizedKeys := ""
+	for _, key := range keys {
+		authorizedKeys += strings.TrimSpace(key.KeyData) + "\n"
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(authorizedKeys))
+	return []string{
+		"set -e",
+		fmt.Sprintf("home=$(getent passwd '%s' | cut -d: -f6)", adminUsername),
+		`mkdir -p "$home/.ssh"`,
+		fmt.Sprintf(`echo '%s' | base64 -d > "$home/.ssh/authorized_keys"`, encoded),
+		fmt.Sprintf(`chown -R '%s': "$home/.ssh"`, adminUsername),
+		`chmod 700 "$home/.ssh"`,
+		`chmod 600 "$home/.ssh/authorized_keys"`,
+	}
+}
@@ -0,0 +1,47 @@
+package acsengine
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSHKeyScript(t *testing.T) {
+	keys := []api.PublicKey{
+		{KeyData: "ssh-rsa AAAA user@host\n"},
+	}
+	script := strings.Join(sshKeyScript("azureuser", keys), "\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte("ssh-rsa AAAA user@host\n"))
+	assert.Contains(t, script, encoded)
+	assert.NotContains(t, script, "ssh-rsa", "the key should only be passed encoded")
+	assert.Contains(t, script, "getent passwd 'azureuser'")
+	assert.Contains(t, script, "authorized_keys")
+}
+
+func TestDiffSSHKeyInPlace(t *testing.T) {
+	raw := mockClusterRawConfig("cluster", "southcentralus", "rg", "dnsprefix")
+	state, err := applyCluster(raw, base64Encode(`{"properties": {"agentPoolProfiles": [{"name": "agentpool1"}]}}`))
+	if err != nil {
+		t.Fatalf("failed to create cluster state: %+v", err)
+	}
+
+	raw["linux_profile"] = []interface{}{
+		map[string]interface{}{
+			"admin_username": "azureuser",
+			"ssh": []interface{}{
+				map[string]interface{}{"key_data": "ssh-rsa BBBB"},
+			},
+		},
+	}
+	diff, err := diffClusterState(state, raw)
+	if err != nil {
+		t.Fatalf("diff failed: %+v", err)
+	}
+	if assert.NotNil(t, diff) {
+		assert.False(t, diff.RequiresNew(), "changing the SSH key shouldn't recreate the cluster")
+	}
+}
@@ -0,0 +1,192 @@
+package acsengine
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Azure/acs-engine/pkg/acsengine"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2018-05-01/network"
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-05-01/resources"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2018-02-01/storage"
+	"github.com/Azure/terraform-provider-acsengine/internal/utils"
+	"github.com/hashicorp/go-multierror"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// The cluster's tags are added to every resource in its templates when they're deployed, and changing them updates
+// the tags of the resources already in the resource group. Tags set by acs-engine or anyone else are kept, and only
+// the tags taken out of the configuration are removed. When the resource group is managed by the provider every
+// resource in it is tagged, including the load balancers and disks Kubernetes creates, and otherwise only the
+// cluster's own resources are.
+
+// tag updates are retried since they fail while a resource is being updated by another operation, such as
+// Kubernetes updating a load balancer
+var tagsBackoff = wait.Backoff{
+	Steps:    5,
+	Duration: 5 * time.Second,
+	Factor:   2.0,
+	Jitter:   0.1,
+}
+
+// updates the tags of the resource group, the api model and every resource of the cluster
+func updateClusterTags(d *resourceData, c *ArmClient) error {
+	if err := updateResourceGroupTags(d, c); err != nil {
+		return err
+	}
+
+	cluster, err := d.loadContainerServiceFromApimodel(false, false)
+	if err != nil {
+		return fmt.Errorf("error parsing the api model: %+v", err)
+	}
+	resourceGroup := d.Get("resource_group").(string)
+	include := func(r resources.GenericResource) bool { return true }
+	if !d.managesResourceGroup() {
+		nameSuffix, dnsPrefix := acsengine.GenerateClusterID(cluster.Properties), cluster.Properties.MasterProfile.DNSPrefix
+		include = func(r resources.GenericResource) bool { return isClusterResource(r, nameSuffix, dnsPrefix) }
+	}
+	tagged, err := listResources(c, resourceGroup, include)
+	if err != nil {
+		return err
+	}
+
+	o, n := d.GetChange("tags")
+	oldTags, newTags := expandClusterTags(o.(map[string]interface{})), expandClusterTags(n.(map[string]interface{}))
+	var result *multierror.Error
+	for _, r := range tagged {
+		tags, changed := mergeResourceTags(r.Tags, oldTags, newTags)
+		if !changed {
+			continue
+		}
+		if !canUpdateResourceTags(*r.Type) {
+			log.Printf("[WARN] not updating tags of %q since resources of type %q aren't tagged with the cluster", *r.Name, *r.Type)
+			continue
+		}
+		resourceType, name := *r.Type, *r.Name
+		err := utils.RetryOnFailure(tagsBackoff, func() error {
+			return c.updateResourceTags(resourceGroup, resourceType, name, tags)
+		})
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("failed to update tags of %q: %+v", name, err))
+			continue
+		}
+		log.Printf("[INFO] updated tags of %q", name)
+	}
+	return result.ErrorOrNil()
+}
+
+// returns the resource's tags with the old tags removed and the new tags set, and whether they changed
+func mergeResourceTags(current map[string]*string, oldTags, newTags map[string]string) (map[string]*string, bool) {
+	merged := map[string]*string{}
+	for name, value := range current {
+		merged[name] = value
+	}
+	changed := false
+	for name := range oldTags {
+		if _, ok := newTags[name]; ok {
+			continue
+		}
+		if _, ok := merged[name]; ok {
+			delete(merged, name)
+			changed = true
+		}
+	}
+	for name, value := range newTags {
+		if v, ok := merged[name]; ok && v != nil && *v == value {
+			continue
+		}
+		v := value
+		merged[name] = &v
+		changed = true
+	}
+	return merged, changed
+}
+
+func canUpdateResourceTags(resourceType string) bool {
+	switch strings.ToLower(resourceType) {
+	case "microsoft.compute/virtualmachines", "microsoft.compute/virtualmachinescalesets", "microsoft.compute/disks",
+		"microsoft.compute/availabilitysets", "microsoft.network/networkinterfaces", "microsoft.network/loadbalancers",
+		"microsoft.network/publicipaddresses", "microsoft.network/virtualnetworks",
+		"microsoft.network/networksecuritygroups", "microsoft.network/routetables", "microsoft.storage/storageaccounts":
+		return true
+	}
+	return false
+}
+
+func (c *ArmClient) updateResourceTags(resourceGroup, resourceType, name string, tags map[string]*string) error {
+	ctx := c.StopContext
+	switch strings.ToLower(resourceType) {
+	case "microsoft.compute/virtualmachines":
+		future, err := c.virtualMachinesClient.Update(ctx, resourceGroup, name, compute.VirtualMachineUpdate{Tags: tags})
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.virtualMachinesClient.Client)
+	case "microsoft.compute/virtualmachinescalesets":
+		future, err := c.vmScaleSetsClient.Update(ctx, resourceGroup, name, compute.VirtualMachineScaleSetUpdate{Tags: tags})
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.vmScaleSetsClient.Client)
+	case "microsoft.compute/disks":
+		future, err := c.disksClient.Update(ctx, resourceGroup, name, compute.DiskUpdate{Tags: tags})
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.disksClient.Client)
+	case "microsoft.compute/availabilitysets":
+		_, err := c.availabilitySetsClient.Update(ctx, resourceGroup, name, compute.AvailabilitySetUpdate{Tags: tags})
+		return err
+	case "microsoft.network/networkinterfaces":
+		future, err := c.interfacesClient.UpdateTags(ctx, resourceGroup, name, network.TagsObject{Tags: tags})
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.interfacesClient.Client)
+	case "microsoft.network/loadbalancers":
+		future, err := c.loadBalancersClient.UpdateTags(ctx, resourceGroup, name, network.TagsObject{Tags: tags})
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.loadBalancersClient.Client)
+	case "microsoft.network/publicipaddresses":
+		future, err := c.publicIPAddressesClient.UpdateTags(ctx, resourceGroup, name, network.TagsObject{Tags: tags})
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.publicIPAddressesClient.Client)
+	case "microsoft.network/virtualnetworks":
+		future, err := c.virtualNetworksClient.UpdateTags(ctx, resourceGroup, name, network.TagsObject{Tags: tags})
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.virtualNetworksClient.Client)
+	case "microsoft.network/networksecuritygroups":
+		future, err := c.securityGroupsClient.UpdateTags(ctx, resourceGroup, name, network.TagsObject{Tags: tags})
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.securityGroupsClient.Client)
+	case "microsoft.network/routetables":
+		future, err := c.routeTablesClient.UpdateTags(ctx, resourceGroup, name, network.TagsObject{Tags: tags})
+		if err != nil {
+			return err
+		}
+		return future.WaitForCompletion(ctx, c.routeTablesClient.Client)
+	case "microsoft.storage/storageaccounts":
+		_, err := c.storageAccountsClient.Update(ctx, resourceGroup, name, storage.AccountUpdateParameters{Tags: tags})
+		return err
+	}
+	return fmt.Errorf("tags of resources of type %q can't be updated", resourceType)
+}
+
+// returns a template transformer that adds the cluster's tags to every resource, or nil if there are none
+func (d *resourceData) tagsTransformer() func(template map[string]interface{}) error {
+	tags := expandClusterTags(d.getTags())
+	if len(tags) == 0 {
+		return nil
+	}
+	return resourceTagsTransformer(tags)
+}
@@ -0,0 +1,83 @@
+package acsengine
+
+import (
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeResourceTags(t *testing.T) {
+	cases := []struct {
+		Current  map[string]*string
+		OldTags  map[string]string
+		NewTags  map[string]string
+		Expected map[string]*string
+		Changed  bool
+	}{
+		{
+			Current:  map[string]*string{"poolName": to.StringPtr("agentpool1")},
+			OldTags:  map[string]string{},
+			NewTags:  map[string]string{"costCenter": "1234"},
+			Expected: map[string]*string{"poolName": to.StringPtr("agentpool1"), "costCenter": to.StringPtr("1234")},
+			Changed:  true,
+		},
+		{
+			Current:  map[string]*string{"poolName": to.StringPtr("agentpool1"), "costCenter": to.StringPtr("1234")},
+			OldTags:  map[string]string{"costCenter": "1234"},
+			NewTags:  map[string]string{"costCenter": "1234"},
+			Expected: map[string]*string{"poolName": to.StringPtr("agentpool1"), "costCenter": to.StringPtr("1234")},
+			Changed:  false,
+		},
+		{
+			Current:  map[string]*string{"costCenter": to.StringPtr("1234"), "owner": to.StringPtr("network")},
+			OldTags:  map[string]string{"costCenter": "1234"},
+			NewTags:  map[string]string{"environment": "production"},
+			Expected: map[string]*string{"owner": to.StringPtr("network"), "environment": to.StringPtr("production")},
+			Changed:  true,
+		},
+		{
+			Current:  nil,
+			OldTags:  map[string]string{"costCenter": "1234"},
+			NewTags:  map[string]string{},
+			Expected: map[string]*string{},
+			Changed:  false,
+		},
+	}
+
+	for _, tc := range cases {
+		merged, changed := mergeResourceTags(tc.Current, tc.OldTags, tc.NewTags)
+		assert.Equal(t, tc.Expected, merged)
+		assert.Equal(t, tc.Changed, changed)
+	}
+}
+
+func TestCanUpdateResourceTags(t *testing.T) {
+	assert.True(t, canUpdateResourceTags("Microsoft.Compute/virtualMachineScaleSets"))
+	assert.True(t, canUpdateResourceTags("microsoft.network/loadbalancers"))
+	assert.False(t, canUpdateResourceTags("Microsoft.Web/sites"))
+}
+
+func TestTemplateTransformer(t *testing.T) {
+	d := mockClusterResourceData("cluster", "southcentralus", "rg", "dnsprefix")
+	assert.Nil(t, d.templateTransformer(), "there should be no transformer without tags or boot diagnostics")
+
+	if err := d.Set("tags", map[string]interface{}{"costCenter": "1234"}); err != nil {
+		t.Fatalf("failed to set tags: %+v", err)
+	}
+	transform := d.templateTransformer()
+	if transform == nil {
+		t.Fatalf("there should be a transformer when tags are set")
+	}
+
+	template := map[string]interface{}{
+		"resources": []interface{}{
+			map[string]interface{}{"type": "Microsoft.Network/publicIPAddresses"},
+		},
+	}
+	if err := transform(template); err != nil {
+		t.Fatalf("template transformer failed: %+v", err)
+	}
+	tags := template["resources"].([]interface{})[0].(map[string]interface{})["tags"]
+	assert.Equal(t, map[string]interface{}{"costCenter": "1234"}, tags)
+}
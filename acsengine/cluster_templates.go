@@ -47,6 +47,43 @@ func expandBody(body string) (map[string]interface{}, error) {
 	return bodyMap, nil
 }
 
+func transformTemplate(template string, transform func(map[string]interface{}) error) (string, error) {
+	templateBody, err := expandBody(template)
+	if err != nil {
+		return "", fmt.Errorf("error expanding template: %+v", err)
+	}
+	if err = transform(templateBody); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(templateBody)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling template: %+v", err)
+	}
+	return string(b), nil
+}
+
+// returns a template transformer that makes every change the provider makes to acs-engine's templates, or nil if
+// there are none
+func (d *resourceData) templateTransformer() func(template map[string]interface{}) error {
+	transforms := []func(map[string]interface{}) error{}
+	for _, transform := range []func(map[string]interface{}) error{d.bootDiagnosticsTransformer(), d.tagsTransformer()} {
+		if transform != nil {
+			transforms = append(transforms, transform)
+		}
+	}
+	if len(transforms) == 0 {
+		return nil
+	}
+	return func(template map[string]interface{}) error {
+		for _, transform := range transforms {
+			if err := transform(template); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 func newContainerService(cluster *api.ContainerService) *containerService {
 	return &containerService{
 		ContainerService: cluster,
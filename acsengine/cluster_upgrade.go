@@ -1,29 +1,202 @@
 package acsengine
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/Azure/acs-engine/pkg/api"
 	"github.com/Azure/acs-engine/pkg/i18n"
 	"github.com/Azure/acs-engine/pkg/operations/kubernetesupgrade"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
+	"github.com/Azure/terraform-provider-acsengine/internal/kubernetes"
 	"github.com/Azure/terraform-provider-acsengine/internal/operations"
+	"github.com/hashicorp/terraform/helper/schema"
 )
 
-func upgradeCluster(d *resourceData, c *ArmClient, upgradeVersion string) error {
+// acs-engine has no per-pool Kubernetes version, so the version of each agent pool that doesn't run the control
+// plane version is recorded in `agent_pool_versions` instead of in the api model acs-engine renders the nodes from
+
+// limits recording the progress of a failed operation, which may run after the resource timeout has passed
+const checkpointTimeout = 5 * time.Minute
+
+// upgrades the masters and the listed agent pools to upgradeVersion one supported upgrade at a time, saving the
+// api model after each upgrade. The upgrade starts from the versions the VMs actually run, so an upgrade that
+// failed partway resumes where it stopped and nodes that are already upgraded are skipped
+func upgradeCluster(d *resourceData, c *ArmClient, upgradeVersion string, agentPools []string) error {
+	cluster, err := d.loadContainerServiceFromApimodel(true, true)
+	if err != nil {
+		return fmt.Errorf("error parsing the api model: %+v", err)
+	}
+
+	keyvaultSecretRef := cluster.Properties.ServicePrincipalProfile.KeyvaultSecretRef
+	clientSecret, err := getSecretFromKeyVault(c, keyvaultSecretRef.VaultID, keyvaultSecretRef.SecretName, keyvaultSecretRef.SecretVersion)
+	if err != nil {
+		return fmt.Errorf("error getting service principal key: %+v", err)
+	}
+	client := operations.NewACSEngineClient(clientSecret)
+	client.ManageResourceGroup = d.managesResourceGroup()
+	if err = client.SetACSEngineClient(c.StopContext, cluster.ContainerService, d.Id()); err != nil {
+		return fmt.Errorf("error initializing client: %+v", err)
+	}
+	nodeVersions, err := client.NodeVersions(c.StopContext)
+	if err != nil {
+		return fmt.Errorf("error getting node versions: %+v", err)
+	}
+
+	upgradePath, err := cluster.upgradePath(nodeVersions, d.getAgentPoolVersions(), agentPools, upgradeVersion)
+	if err != nil {
+		return fmt.Errorf("error finding upgrade path: %+v", err)
+	}
+	if len(upgradePath) == 0 {
+		// the nodes were upgraded by an earlier apply that failed before saving the api model
+		log.Printf("[INFO] Cluster already runs Kubernetes version %s", upgradeVersion)
+		cluster.Properties.OrchestratorProfile.OrchestratorVersion = upgradeVersion
+		versions := d.getAgentPoolVersions()
+		cluster.recordAgentPoolVersions(versions, d.Get("agent_pool_profiles").(*schema.Set).List())
+		if err = cluster.saveTemplates(d, client.DeploymentDirectory); err != nil {
+			return fmt.Errorf("error saving api model: %+v", err)
+		}
+		d.SetPartial("api_model")
+		return d.setAgentPoolVersions(versions)
+	}
+
+	for _, version := range upgradePath {
+		if err = upgradeClusterVersion(d, c, version, agentPools); err != nil {
+			if checkpointErr := checkpointUpgrade(d, client); checkpointErr != nil {
+				log.Printf("[ERROR] failed to record upgrade progress: %+v", checkpointErr)
+			}
+			return fmt.Errorf("error upgrading to version %s: %+v", version, err)
+		}
+		d.SetPartial("api_model")
+	}
+
+	return nil
+}
+
+// returns the upgrades that take the masters and the listed agent pools to upgradeVersion. Agent pools that lag the
+// masters, because they're pinned or because an upgrade failed partway, are upgraded to the masters' version first,
+// so masters that are already upgraded aren't recreated
+func (cluster *containerService) upgradePath(nodeVersions map[string][]string, agentPoolVersions map[string]string, agentPools []string, upgradeVersion string) ([]string, error) {
+	apiModelVersion := cluster.Properties.OrchestratorProfile.OrchestratorVersion
+	masterVersion := kubernetes.OldestVersion(nodeVersions[operations.MasterPoolName], apiModelVersion)
+
+	versions := []string{masterVersion}
+	for _, name := range agentPools {
+		index, ok := cluster.agentPoolIndex(name)
+		if !ok {
+			return nil, fmt.Errorf("agent pool %q not found in api model", name)
+		}
+		recorded := agentPoolVersions[cluster.Properties.AgentPoolProfiles[index].Name]
+		if recorded == "" {
+			recorded = apiModelVersion
+		}
+		versions = append(versions, kubernetes.OldestVersion(nodeVersions[strings.ToLower(name)], recorded))
+	}
+	agentVersion := kubernetes.OldestVersion(versions, masterVersion)
+
+	catchUpPath, err := kubernetes.UpgradePath(agentVersion, masterVersion)
+	if err != nil {
+		return nil, fmt.Errorf("error upgrading agent pools from version %s to %s: %+v", agentVersion, masterVersion, err)
+	}
+	upgradePath, err := kubernetes.UpgradePath(masterVersion, upgradeVersion)
+	if err != nil {
+		return nil, err
+	}
+	return append(catchUpPath, upgradePath...), nil
+}
+
+// records the versions the nodes run after an upgrade failed, so the state shows how far the upgrade got
+func checkpointUpgrade(d *resourceData, client *operations.ACSEngineClient) error {
+	// the upgrade may have failed because the resource timeout passed
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointTimeout)
+	defer cancel()
+	nodeVersions, err := client.NodeVersions(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting node versions: %+v", err)
+	}
+
 	cluster, err := d.loadContainerServiceFromApimodel(true, true)
 	if err != nil {
 		return fmt.Errorf("error parsing the api model: %+v", err)
 	}
+	versions := d.getAgentPoolVersions()
+	if !cluster.setNodeVersions(nodeVersions, versions) {
+		return nil
+	}
+	if err = cluster.saveTemplates(d, client.DeploymentDirectory); err != nil {
+		return fmt.Errorf("error saving api model: %+v", err)
+	}
+	d.SetPartial("api_model")
+
+	return d.setAgentPoolVersions(versions)
+}
+
+// records the oldest master version as the control plane version and the oldest version of each agent pool that
+// lags it as the pool's version in agentPoolVersions, and returns whether anything changed
+func (cluster *containerService) setNodeVersions(nodeVersions map[string][]string, agentPoolVersions map[string]string) bool {
+	orchestratorProfile := cluster.Properties.OrchestratorProfile
+	oldVersion := orchestratorProfile.OrchestratorVersion
+	masterVersion := kubernetes.OldestVersion(nodeVersions[operations.MasterPoolName], oldVersion)
+	changed := masterVersion != oldVersion
+	orchestratorProfile.OrchestratorVersion = masterVersion
+
+	for _, profile := range cluster.Properties.AgentPoolProfiles {
+		recorded := agentPoolVersions[profile.Name]
+		version := recorded
+		if version == "" {
+			version = oldVersion
+		}
+		version = kubernetes.OldestVersion(nodeVersions[strings.ToLower(profile.Name)], version)
+		if version == masterVersion {
+			if recorded == masterVersion {
+				continue // pinned to the control plane version
+			}
+			version = ""
+		}
+		if recordAgentPoolVersion(agentPoolVersions, profile.Name, version) {
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// upgrades the masters that aren't running upgradeVersion yet and the listed agent pools to upgradeVersion. acs-engine
+// upgrades the masters one at a time, and the agent pools' nodes are replaced in batches limited by the cluster's
+// update strategy, like a resize
+func upgradeClusterVersion(d *resourceData, c *ArmClient, upgradeVersion string, agentPools []string) error {
+	cluster, err := d.loadContainerServiceFromApimodel(true, true)
+	if err != nil {
+		return fmt.Errorf("error parsing the api model: %+v", err)
+	}
+	versions := d.getAgentPoolVersions()
+	cluster.recordAgentPoolVersions(versions, d.Get("agent_pool_profiles").(*schema.Set).List())
 
 	keyvaultSecretRef := cluster.Properties.ServicePrincipalProfile.KeyvaultSecretRef
-	clientSecret, err := getSecretFromKeyVault(c, keyvaultSecretRef.VaultID, keyvaultSecretRef.SecretName, "")
+	clientSecret, err := getSecretFromKeyVault(c, keyvaultSecretRef.VaultID, keyvaultSecretRef.SecretName, keyvaultSecretRef.SecretVersion)
 	if err != nil {
 		return fmt.Errorf("error getting service principal key: %+v", err)
 	}
 
 	uc := operations.NewUpgradeClient(clientSecret)
-	if err := uc.SetUpgradeClient(cluster.ContainerService, d.Id(), upgradeVersion); err != nil {
+	uc.ManageResourceGroup = d.managesResourceGroup()
+	if err := uc.SetUpgradeClient(c.StopContext, cluster.ContainerService, d.Id(), upgradeVersion); err != nil {
 		return fmt.Errorf("error initializing upgrade client: %+v", err)
 	}
+	// acs-engine only upgrades the masters, the agent pools are upgraded within the update strategy below
+	if err := uc.SetAgentPoolsToUpgrade([]string{}); err != nil {
+		return fmt.Errorf("error initializing upgrade client: %+v", err)
+	}
+	uc.SetTimeout(c.StopContext)
+	uc.Client = operations.NewContextClient(uc.Client, c.StopContext)
+	uc.Client = operations.NewAgentPoolFilteringClient(uc.Client, uc.AgentPoolsToUpgrade)
+	if transform := d.templateTransformer(); transform != nil {
+		uc.Client = operations.NewTransformingClient(uc.Client, transform)
+	}
 
 	upgradeCluster := kubernetesupgrade.UpgradeCluster{
 		Translator: &i18n.Translator{
@@ -49,8 +222,283 @@ func upgradeCluster(d *resourceData, c *ArmClient, upgradeVersion string) error
 		uc.AgentPoolsToUpgrade,
 		acsEngineVersion)
 	if err != nil {
-		return fmt.Errorf("failed to deploy upgraded cluster: %+v", err)
+		return fmt.Errorf("failed to upgrade masters: %+v", err)
+	}
+
+	// the agent pools' new nodes are deployed from the api model with the upgraded control plane version
+	if err = cluster.saveTemplates(d, uc.DeploymentDirectory); err != nil {
+		return err
+	}
+	d.SetPartial("api_model")
+	if err = d.setAgentPoolVersions(versions); err != nil {
+		return err
+	}
+
+	for _, name := range agentPools {
+		if err = upgradeAgentPool(d, c, name, upgradeVersion); err != nil {
+			return fmt.Errorf("failed to upgrade agent pool %q: %+v", name, err)
+		}
+		log.Printf("[INFO] agent pool %q upgraded to Kubernetes version %s", name, upgradeVersion)
+	}
+
+	return nil
+}
+
+// replaces the agent pool's nodes that don't run upgradeVersion with nodes that do
+func upgradeAgentPool(d *resourceData, c *ArmClient, name, upgradeVersion string) error {
+	outdated := outdatedNodes{
+		availabilitySetVMs: func(c *ArmClient, sc *operations.ScaleClient, vms []string) ([]string, error) {
+			tags, err := sc.AvailabilitySetVMTags(c.StopContext, orchestratorTag)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get availability set VM versions: %+v", err)
+			}
+			return outdatedAvailabilitySetVMs(vms, tags, orchestratorTagValue(upgradeVersion)), nil
+		},
+		scaleSetVMs: outdatedModelScaleSetVMs,
+	}
+	prepare := func(sc *operations.ScaleClient) error {
+		sc.AgentPoolVersion = upgradeVersion
+		if sc.AgentPool.IsAvailabilitySets() {
+			return nil
+		}
+		// instances created before the scale set's model has the new version are replaced
+		return updateScaleSetModel(c, sc)
+	}
+	return replaceAgentPoolNodes(d, c, name, outdated, prepare)
+}
+
+// acs-engine tags every VM with the orchestrator and Kubernetes version it was deployed with
+const orchestratorTag = "orchestrator"
+
+func orchestratorTagValue(version string) string {
+	return fmt.Sprintf("%s:%s", api.Kubernetes, version)
+}
+
+// recreates the master nodes at the current Kubernetes version so master profile changes
+// that acs-engine only applies to new VMs, like custom files, reach the cluster
+func updateMasterNodes(d *resourceData, c *ArmClient) error {
+	cluster, err := d.loadContainerServiceFromApimodel(true, true)
+	if err != nil {
+		return fmt.Errorf("error parsing the api model: %+v", err)
+	}
+	masterProfile, err := d.expandMasterProfile()
+	if err != nil {
+		return fmt.Errorf("error expanding master profile: %+v", err)
+	}
+	cluster.Properties.MasterProfile.CustomFiles = masterProfile.CustomFiles
+
+	allMasters := func(vm compute.VirtualMachine) bool { return true }
+	return recreateMasterNodes(d, c, &cluster, allMasters, nil)
+}
+
+// recreates the master VMs that outdated picks one at a time, deploying them with the cluster's api model and
+// transform if it isn't nil
+func recreateMasterNodes(d *resourceData, c *ArmClient, cluster *containerService, outdated func(vm compute.VirtualMachine) bool, transform operations.TemplateTransformer) error {
+	keyvaultSecretRef := cluster.Properties.ServicePrincipalProfile.KeyvaultSecretRef
+	clientSecret, err := getSecretFromKeyVault(c, keyvaultSecretRef.VaultID, keyvaultSecretRef.SecretName, keyvaultSecretRef.SecretVersion)
+	if err != nil {
+		return fmt.Errorf("error getting service principal key: %+v", err)
+	}
+
+	uc := operations.NewUpgradeClient(clientSecret)
+	uc.ManageResourceGroup = d.managesResourceGroup()
+	if err = uc.SetUpgradeClient(c.StopContext, cluster.ContainerService, d.Id(), cluster.Properties.OrchestratorProfile.OrchestratorVersion); err != nil {
+		return fmt.Errorf("error initializing upgrade client: %+v", err)
+	}
+	uc.SetTimeout(c.StopContext)
+	uc.Client = operations.NewContextClient(uc.Client, c.StopContext)
+	if templateTransform := d.templateTransformer(); templateTransform != nil {
+		uc.Client = operations.NewTransformingClient(uc.Client, templateTransform)
+	}
+	if transform != nil {
+		uc.Client = operations.NewTransformingClient(uc.Client, transform)
+	}
+
+	kubeconfig, err := cluster.getKubeConfig(c, true)
+	if err != nil {
+		return fmt.Errorf("failed to generate kube config: %+v", err)
+	}
+	vms, err := uc.ListMasterVMs(c.StopContext)
+	if err != nil {
+		return fmt.Errorf("failed to get master VMs: %+v", err)
+	}
+	masterVMs, upToDateMasterVMs := []compute.VirtualMachine{}, []compute.VirtualMachine{}
+	for _, vm := range vms {
+		if outdated(vm) {
+			masterVMs = append(masterVMs, vm)
+		} else {
+			upToDateMasterVMs = append(upToDateMasterVMs, vm)
+		}
+	}
+
+	// the upgrader recreates every master VM in the topology that isn't upgraded, and there are no agent pools to upgrade
+	topology := kubernetesupgrade.ClusterTopology{
+		DataModel:           uc.Cluster,
+		SubscriptionID:      uc.SubscriptionID.String(),
+		Location:            uc.Location,
+		ResourceGroup:       uc.ResourceGroupName,
+		NameSuffix:          uc.NameSuffix,
+		AgentPoolsToUpgrade: map[string]bool{kubernetesupgrade.MasterPoolName: true},
+		AgentPools:          map[string]*kubernetesupgrade.AgentPoolTopology{},
+		MasterVMs:           &masterVMs,
+		UpgradedMasterVMs:   &upToDateMasterVMs,
+	}
+	upgrader := &kubernetesupgrade.Upgrader{}
+	upgrader.Init(&i18n.Translator{Locale: uc.Locale}, uc.Logger, topology, uc.Client, kubeconfig, uc.Timeout, acsEngineVersion)
+	if err = upgrader.RunUpgrade(); err != nil {
+		return fmt.Errorf("failed to recreate master nodes: %+v", err)
 	}
 
 	return cluster.saveTemplates(d, uc.DeploymentDirectory)
 }
+
+// returns the existing agent pools whose Kubernetes version changes, where a pool without a `kubernetes_version`
+// runs the control plane version
+func agentPoolsToUpgrade(oldVersion, newVersion string, oldProfiles, newProfiles []interface{}) []string {
+	oldByName := agentPoolProfilesByName(oldProfiles)
+	pools := []string{}
+	for _, p := range newProfiles {
+		profile := p.(map[string]interface{})
+		name := profile["name"].(string)
+		oldProfile, ok := oldByName[name]
+		if !ok {
+			continue
+		}
+		if agentPoolProfileVersion(profile, newVersion) != agentPoolProfileVersion(oldProfile, oldVersion) {
+			pools = append(pools, name)
+		}
+	}
+	sort.Strings(pools)
+	return pools
+}
+
+func (d *resourceData) agentPoolsToUpgrade() []string {
+	oldVersion, newVersion := d.GetChange("kubernetes_version")
+	o, n := d.GetChange("agent_pool_profiles")
+	return agentPoolsToUpgrade(oldVersion.(string), newVersion.(string), o.(*schema.Set).List(), n.(*schema.Set).List())
+}
+
+func agentPoolProfileVersion(profile map[string]interface{}, clusterVersion string) string {
+	if version, ok := profile["kubernetes_version"].(string); ok && version != "" {
+		return version
+	}
+	return clusterVersion
+}
+
+// a pool's `kubernetes_version` can lag the control plane, but a pool can only stay at its current version or be
+// upgraded to the control plane version since that's the only version acs-engine upgrades to
+func customizeDiffAgentPoolVersions(d *schema.ResourceDiff) error {
+	if !d.HasChange("kubernetes_version") && !d.HasChange("agent_pool_profiles") {
+		return nil
+	}
+	oldVersion, newVersion := d.GetChange("kubernetes_version")
+	o, n := d.GetChange("agent_pool_profiles")
+	oldByName := agentPoolProfilesByName(o.(*schema.Set).List())
+
+	for _, p := range n.(*schema.Set).List() {
+		profile := p.(map[string]interface{})
+		name := profile["name"].(string)
+		version := agentPoolProfileVersion(profile, newVersion.(string))
+		if version == newVersion.(string) {
+			continue
+		}
+		if d.Id() == "" {
+			return fmt.Errorf("agent pool %q: `kubernetes_version` must match the cluster's `kubernetes_version` when the cluster is created", name)
+		}
+		if err := kubernetes.ValidateAgentPoolVersion(version, newVersion.(string)); err != nil {
+			return fmt.Errorf("agent pool %q: %+v", name, err)
+		}
+		if oldProfile, ok := oldByName[name]; ok && version != agentPoolProfileVersion(oldProfile, oldVersion.(string)) {
+			return fmt.Errorf("agent pool %q can only stay at Kubernetes version %s or be upgraded to the control plane version %s",
+				name, agentPoolProfileVersion(oldProfile, oldVersion.(string)), newVersion.(string))
+		}
+	}
+
+	return nil
+}
+
+// returns the Kubernetes version of each agent pool that doesn't run the control plane version, by pool name
+func (d *resourceData) getAgentPoolVersions() map[string]string {
+	versions := map[string]string{}
+	if v, ok := d.Get("agent_pool_versions").(map[string]interface{}); ok {
+		for name, version := range v {
+			versions[name] = version.(string)
+		}
+	}
+	return versions
+}
+
+func (d *resourceData) setAgentPoolVersions(versions map[string]string) error {
+	if err := d.Set("agent_pool_versions", versions); err != nil {
+		return fmt.Errorf("error setting `agent_pool_versions`: %+v", err)
+	}
+	d.SetPartial("agent_pool_versions")
+	return nil
+}
+
+// sets up the scale client to deploy nodes at the agent pool's recorded version
+func (d *resourceData) setScaleClientVersion(sc *operations.ScaleClient) {
+	sc.AgentPoolVersion = d.getAgentPoolVersions()[sc.AgentPoolToScale]
+}
+
+// returns whether the recorded version changed
+func recordAgentPoolVersion(versions map[string]string, name, version string) bool {
+	if versions[name] == version {
+		return false
+	}
+	if version == "" {
+		delete(versions, name)
+	} else {
+		versions[name] = version
+	}
+	return true
+}
+
+// records the `kubernetes_version` of each agent pool in versions, forgets the versions of pools that aren't in
+// the api model, and returns whether anything changed
+func (cluster *containerService) recordAgentPoolVersions(versions map[string]string, profiles []interface{}) bool {
+	changed := false
+	for name := range versions {
+		if _, ok := cluster.agentPoolIndex(name); !ok {
+			delete(versions, name)
+			changed = true
+		}
+	}
+	for _, p := range profiles {
+		profile := p.(map[string]interface{})
+		index, ok := cluster.agentPoolIndex(profile["name"].(string))
+		if !ok {
+			continue
+		}
+		version, _ := profile["kubernetes_version"].(string)
+		if recordAgentPoolVersion(versions, cluster.Properties.AgentPoolProfiles[index].Name, version) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// records `kubernetes_version` changes that don't need an upgrade, like pinning a pool to the control plane version
+func updateAgentPoolVersions(d *resourceData) error {
+	cluster, err := d.loadContainerServiceFromApimodel(true, true)
+	if err != nil {
+		return fmt.Errorf("error parsing the api model: %+v", err)
+	}
+	versions := d.getAgentPoolVersions()
+	if !cluster.recordAgentPoolVersions(versions, d.Get("agent_pool_profiles").(*schema.Set).List()) {
+		return nil
+	}
+	return d.setAgentPoolVersions(versions)
+}
+
+func customizeDiffUpgradePath(d *schema.ResourceDiff) error {
+	if d.Id() == "" || !d.HasChange("kubernetes_version") {
+		return nil
+	}
+	old, new := d.GetChange("kubernetes_version")
+	upgradePath, err := kubernetes.UpgradePath(old.(string), new.(string))
+	if err != nil {
+		return fmt.Errorf("error upgrading Kubernetes version: %+v", err)
+	}
+	return d.SetNew("upgrade_path", upgradePath)
+}
@@ -0,0 +1,337 @@
+package acsengine
+
+import (
+	"testing"
+
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgentPoolsToUpgrade(t *testing.T) {
+	pool1 := map[string]interface{}{"name": "agentpool1"}
+	pool2 := map[string]interface{}{"name": "agentpool2", "kubernetes_version": "1.10.3"}
+
+	cases := []struct {
+		Description string
+		OldVersion  string
+		NewVersion  string
+		OldProfiles []interface{}
+		NewProfiles []interface{}
+		Expected    []string
+	}{
+		{
+			Description: "upgrading the control plane upgrades pools without a version",
+			OldVersion:  "1.10.3",
+			NewVersion:  "1.11.2",
+			OldProfiles: []interface{}{pool1, pool2},
+			NewProfiles: []interface{}{pool2, pool1},
+			Expected:    []string{"agentpool1"},
+		},
+		{
+			Description: "upgrading only the control plane",
+			OldVersion:  "1.10.3",
+			NewVersion:  "1.11.2",
+			OldProfiles: []interface{}{pool1, pool2},
+			NewProfiles: []interface{}{
+				map[string]interface{}{"name": "agentpool1", "kubernetes_version": "1.10.3"},
+				pool2,
+			},
+			Expected: []string{},
+		},
+		{
+			Description: "upgrading a pool that lags the control plane",
+			OldVersion:  "1.11.2",
+			NewVersion:  "1.11.2",
+			OldProfiles: []interface{}{pool1, pool2},
+			NewProfiles: []interface{}{
+				pool1,
+				map[string]interface{}{"name": "agentpool2", "kubernetes_version": ""},
+			},
+			Expected: []string{"agentpool2"},
+		},
+		{
+			Description: "pinning a pool to the control plane version",
+			OldVersion:  "1.11.2",
+			NewVersion:  "1.11.2",
+			OldProfiles: []interface{}{pool1},
+			NewProfiles: []interface{}{
+				map[string]interface{}{"name": "agentpool1", "kubernetes_version": "1.11.2"},
+			},
+			Expected: []string{},
+		},
+		{
+			Description: "adding a pool",
+			OldVersion:  "1.10.3",
+			NewVersion:  "1.11.2",
+			OldProfiles: []interface{}{pool2},
+			NewProfiles: []interface{}{pool1, pool2},
+			Expected:    []string{},
+		},
+	}
+
+	for _, tc := range cases {
+		pools := agentPoolsToUpgrade(tc.OldVersion, tc.NewVersion, tc.OldProfiles, tc.NewProfiles)
+		assert.Equal(t, tc.Expected, pools, tc.Description)
+	}
+}
+
+func TestRecordAgentPoolVersions(t *testing.T) {
+	cluster := newContainerService(&api.ContainerService{
+		Properties: &api.Properties{
+			AgentPoolProfiles: []*api.AgentPoolProfile{
+				{Name: "agentpool1"},
+				{Name: "agentpool2", CustomNodeLabels: map[string]string{"team": "web"}},
+			},
+		},
+	})
+	versions := map[string]string{"agentpool2": "1.10.3", "removedpool": "1.10.3"}
+
+	changed := cluster.recordAgentPoolVersions(versions, []interface{}{
+		map[string]interface{}{"name": "agentpool1", "kubernetes_version": "1.10.3"},
+		map[string]interface{}{"name": "agentpool2", "kubernetes_version": ""},
+		map[string]interface{}{"name": "agentpool3", "kubernetes_version": "1.10.3"},
+	})
+	assert.True(t, changed)
+	assert.Equal(t, map[string]string{"agentpool1": "1.10.3"}, versions)
+	assert.Nil(t, cluster.Properties.AgentPoolProfiles[0].CustomNodeLabels, "versions shouldn't be recorded as node labels")
+	assert.Equal(t, map[string]string{"team": "web"}, cluster.Properties.AgentPoolProfiles[1].CustomNodeLabels)
+
+	changed = cluster.recordAgentPoolVersions(versions, []interface{}{
+		map[string]interface{}{"name": "agentpool1", "kubernetes_version": "1.10.3"},
+		map[string]interface{}{"name": "agentpool2"},
+	})
+	assert.False(t, changed, "versions that are already recorded aren't a change")
+}
+
+func TestAgentPoolVersionsState(t *testing.T) {
+	d := mockClusterResourceData("cluster", "southcentralus", "rg", "dnsprefix")
+	assert.Equal(t, map[string]string{}, d.getAgentPoolVersions())
+
+	if err := d.setAgentPoolVersions(map[string]string{"agentpool2": "1.10.3"}); err != nil {
+		t.Fatalf("setAgentPoolVersions failed: %+v", err)
+	}
+	assert.Equal(t, map[string]string{"agentpool2": "1.10.3"}, d.getAgentPoolVersions())
+}
+
+func TestCustomizeDiffAgentPoolVersions(t *testing.T) {
+	raw := mockClusterRawConfig("cluster", "southcentralus", "rg", "dnsprefix")
+	raw["kubernetes_version"] = "1.10.3"
+	raw["agent_pool_profiles"] = []interface{}{
+		map[string]interface{}{"name": "agentpool1", "count": 1},
+		map[string]interface{}{"name": "agentpool2", "count": 1},
+	}
+	state, err := applyCluster(raw, base64Encode(`{"properties": {"agentPoolProfiles": [{"name": "agentpool1"}, {"name": "agentpool2"}]}}`))
+	if err != nil {
+		t.Fatalf("failed to create cluster state: %+v", err)
+	}
+
+	cases := []struct {
+		Description  string
+		Version      string
+		PoolVersions map[string]string
+		ExpectError  bool
+	}{
+		{
+			Description:  "upgrading the control plane and one pool",
+			Version:      "1.11.2",
+			PoolVersions: map[string]string{"agentpool2": "1.10.3"},
+			ExpectError:  false,
+		},
+		{
+			Description:  "upgrading only the control plane",
+			Version:      "1.11.2",
+			PoolVersions: map[string]string{"agentpool1": "1.10.3", "agentpool2": "1.10.3"},
+			ExpectError:  false,
+		},
+		{
+			Description:  "a pool newer than the control plane",
+			Version:      "1.10.3",
+			PoolVersions: map[string]string{"agentpool2": "1.11.2"},
+			ExpectError:  true,
+		},
+		{
+			Description:  "upgrading a pool to a version other than the control plane version",
+			Version:      "1.11.2",
+			PoolVersions: map[string]string{"agentpool2": "1.10.5"},
+			ExpectError:  true,
+		},
+		{
+			Description:  "adding a pool that lags the control plane",
+			Version:      "1.10.3",
+			PoolVersions: map[string]string{"agentpool3": "1.9.8"},
+			ExpectError:  false,
+		},
+		{
+			Description:  "adding a pool that lags the control plane by 2 minor versions",
+			Version:      "1.10.3",
+			PoolVersions: map[string]string{"agentpool3": "1.8.13"},
+			ExpectError:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		raw["kubernetes_version"] = tc.Version
+		pools := []interface{}{}
+		for _, name := range []string{"agentpool1", "agentpool2", "agentpool3"} {
+			version, ok := tc.PoolVersions[name]
+			if name == "agentpool3" && !ok {
+				continue
+			}
+			pools = append(pools, map[string]interface{}{"name": name, "count": 1, "kubernetes_version": version})
+		}
+		raw["agent_pool_profiles"] = pools
+
+		_, err := diffClusterState(state, raw)
+		if tc.ExpectError {
+			assert.NotNil(t, err, tc.Description)
+		} else {
+			assert.Nil(t, err, tc.Description)
+		}
+	}
+
+	raw = mockClusterRawConfig("cluster", "southcentralus", "rg", "dnsprefix")
+	raw["kubernetes_version"] = "1.10.3"
+	raw["agent_pool_profiles"] = []interface{}{
+		map[string]interface{}{"name": "agentpool1", "count": 1, "kubernetes_version": "1.9.8"},
+	}
+	if _, err = diffCluster(raw); err == nil {
+		t.Fatalf("creating a cluster with a pool that lags the control plane should fail")
+	}
+}
+
+func TestCustomizeDiffUpgradePath(t *testing.T) {
+	raw := mockClusterRawConfig("cluster", "southcentralus", "rg", "dnsprefix")
+	raw["kubernetes_version"] = "1.8.13"
+	state, err := applyCluster(raw, base64Encode(`{"properties": {"agentPoolProfiles": [{"name": "agentpool1"}]}}`))
+	if err != nil {
+		t.Fatalf("failed to create cluster state: %+v", err)
+	}
+
+	raw["kubernetes_version"] = "1.11.2"
+	diff, err := diffClusterState(state, raw)
+	if err != nil {
+		t.Fatalf("diff failed: %+v", err)
+	}
+	assert.Equal(t, "3", diff.Attributes["upgrade_path.#"].New)
+	assert.Equal(t, "1.9.10", diff.Attributes["upgrade_path.0"].New)
+	assert.Equal(t, "1.10.6", diff.Attributes["upgrade_path.1"].New)
+	assert.Equal(t, "1.11.2", diff.Attributes["upgrade_path.2"].New)
+	assert.False(t, diff.RequiresNew(), "upgrading shouldn't recreate the cluster")
+
+	raw["kubernetes_version"] = "1.8.1"
+	if _, err = diffClusterState(state, raw); err == nil {
+		t.Fatalf("downgrading should fail")
+	}
+}
+
+func TestClusterUpgradePath(t *testing.T) {
+	cluster := newContainerService(&api.ContainerService{
+		Properties: &api.Properties{
+			OrchestratorProfile: &api.OrchestratorProfile{OrchestratorVersion: "1.9.10"},
+			AgentPoolProfiles: []*api.AgentPoolProfile{
+				{Name: "agentpool1"},
+				{Name: "agentpool2"},
+			},
+		},
+	})
+	versions := map[string]string{"agentpool2": "1.8.13"}
+
+	cases := []struct {
+		Description    string
+		NodeVersions   map[string][]string
+		AgentPools     []string
+		UpgradeVersion string
+		Expected       []string
+	}{
+		{
+			Description:    "upgrading from the api model version",
+			NodeVersions:   map[string][]string{},
+			AgentPools:     []string{"agentpool1"},
+			UpgradeVersion: "1.11.2",
+			Expected:       []string{"1.10.6", "1.11.2"},
+		},
+		{
+			Description: "resuming after the masters were upgraded",
+			NodeVersions: map[string][]string{
+				"master":     {"1.10.6", "1.10.6", "1.10.6"},
+				"agentpool1": {"1.10.6", "1.9.10"},
+			},
+			AgentPools:     []string{"agentpool1"},
+			UpgradeVersion: "1.11.2",
+			Expected:       []string{"1.10.6", "1.11.2"},
+		},
+		{
+			Description: "resuming after every node was upgraded",
+			NodeVersions: map[string][]string{
+				"master":     {"1.11.2"},
+				"agentpool1": {"1.11.2"},
+			},
+			AgentPools:     []string{"AgentPool1"},
+			UpgradeVersion: "1.11.2",
+			Expected:       []string{},
+		},
+		{
+			Description:    "upgrading a pool that lags the masters",
+			NodeVersions:   map[string][]string{"master": {"1.9.10"}},
+			AgentPools:     []string{"agentpool2"},
+			UpgradeVersion: "1.9.10",
+			Expected:       []string{"1.9.10"},
+		},
+	}
+
+	for _, tc := range cases {
+		path, err := cluster.upgradePath(tc.NodeVersions, versions, tc.AgentPools, tc.UpgradeVersion)
+		if err != nil {
+			t.Fatalf("%s: upgradePath failed: %+v", tc.Description, err)
+		}
+		assert.Equal(t, tc.Expected, path, tc.Description)
+	}
+
+	if _, err := cluster.upgradePath(map[string][]string{}, versions, []string{"agentpool3"}, "1.11.2"); err == nil {
+		t.Fatalf("upgradePath should have failed for a pool that isn't in the api model")
+	}
+}
+
+func TestSetNodeVersions(t *testing.T) {
+	cluster := newContainerService(&api.ContainerService{
+		Properties: &api.Properties{
+			OrchestratorProfile: &api.OrchestratorProfile{OrchestratorVersion: "1.9.10"},
+			AgentPoolProfiles: []*api.AgentPoolProfile{
+				{Name: "agentpool1"},
+				{Name: "agentpool2"},
+				{Name: "agentpool3"},
+			},
+		},
+	})
+	versions := map[string]string{"agentpool3": "1.10.6"}
+
+	changed := cluster.setNodeVersions(map[string][]string{
+		"master":     {"1.9.10", "1.10.6"},
+		"agentpool1": {"1.9.10"},
+	}, versions)
+	assert.False(t, changed, "nothing is recorded until every master is upgraded")
+
+	changed = cluster.setNodeVersions(map[string][]string{
+		"master":     {"1.10.6", "1.10.6"},
+		"agentpool1": {"1.10.6", "1.9.10"},
+		"agentpool2": {"1.10.6"},
+		"agentpool3": {"1.10.6"},
+	}, versions)
+	assert.True(t, changed)
+	assert.Equal(t, "1.10.6", cluster.Properties.OrchestratorProfile.OrchestratorVersion)
+	assert.Equal(t, "1.9.10", versions["agentpool1"], "a partly upgraded pool lags the control plane")
+	assert.Equal(t, "", versions["agentpool2"], "an upgraded pool follows the control plane")
+	assert.Equal(t, "1.10.6", versions["agentpool3"], "a pool pinned to the control plane version stays pinned")
+}
+
+func TestOutdatedVersionAvailabilitySetVMs(t *testing.T) {
+	vms := []string{"k8s-agentpool1-12345678-0", "k8s-agentpool1-12345678-1", "k8s-agentpool1-12345678-2"}
+	tags := map[string]string{
+		"k8s-agentpool1-12345678-0": "Kubernetes:1.10.3",
+		"k8s-agentpool1-12345678-1": "Kubernetes:1.11.2",
+	}
+
+	outdated := outdatedAvailabilitySetVMs(vms, tags, orchestratorTagValue("1.11.2"))
+	assert.Equal(t, []string{"k8s-agentpool1-12345678-0", "k8s-agentpool1-12345678-2"}, outdated)
+	assert.Empty(t, outdatedAvailabilitySetVMs(vms[1:2], tags, orchestratorTagValue("1.11.2")))
+}
@@ -0,0 +1,112 @@
+package acsengine
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/Azure/acs-engine/pkg/i18n"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// Most invalid configurations, such as a bad CIDR or a Windows agent pool without a `windows_profile`, are only
+// caught by acs-engine when the templates are generated during apply. The planned configuration is expanded into an
+// api model and validated the same way the api model in state is, without calling Azure, so these errors show up in
+// the plan instead. Configurations with values that aren't known until apply are validated during apply.
+
+func customizeDiffAPIModel(d *schema.ResourceDiff) error {
+	data, known := plannedResourceData(d)
+	if !known {
+		log.Printf("[DEBUG] not validating the cluster's api model since parts of its configuration are only known after apply")
+		return nil
+	}
+
+	cluster, err := newResourceData(data).setContainerService()
+	if err != nil {
+		return fmt.Errorf("error expanding the cluster's configuration: %+v", err)
+	}
+	if err = validateContainerService(&cluster, d.Id() != ""); err != nil {
+		return fmt.Errorf("invalid cluster configuration: %+v", err)
+	}
+
+	return nil
+}
+
+// returns resource data with the planned configuration, and whether all of the configuration is known
+func plannedResourceData(d *schema.ResourceDiff) (*schema.ResourceData, bool) {
+	r := resourceArmACSEngineKubernetesCluster()
+	data := r.Data(nil)
+	for key, s := range r.Schema {
+		if !s.Optional && !s.Required {
+			continue
+		}
+		if !plannedValueKnown(d, key, s) {
+			return nil, false
+		}
+		// optional computed values that aren't known yet are left to their defaults when expanded
+		if !d.NewValueKnown(key) {
+			continue
+		}
+		if err := data.Set(key, d.Get(key)); err != nil {
+			log.Printf("[DEBUG] failed to set planned %q: %+v", key, err)
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+// returns whether the configured value and, for blocks, every configured value in them is known at plan time
+func plannedValueKnown(d *schema.ResourceDiff, key string, s *schema.Schema) bool {
+	if !d.NewValueKnown(key) {
+		return s.Computed
+	}
+	elem, ok := s.Elem.(*schema.Resource)
+	if !ok {
+		return true
+	}
+	if s.Type == schema.TypeSet {
+		// set elements with values that aren't known yet are keyed by a placeholder hash starting with ~
+		for _, k := range d.GetChangedKeysPrefix(key + ".") {
+			if strings.HasPrefix(strings.TrimPrefix(k, key+"."), "~") {
+				return false
+			}
+		}
+		return true
+	}
+	if s.Type != schema.TypeList {
+		return true
+	}
+	items, _ := d.Get(key).([]interface{})
+	for i := range items {
+		for name, field := range elem.Schema {
+			if !field.Optional && !field.Required {
+				continue
+			}
+			if !plannedValueKnown(d, fmt.Sprintf("%s.%d.%s", key, i, name), field) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validates the cluster with acs-engine's vlabs validation, the same way the api model is validated when loaded
+func validateContainerService(cluster *containerService, isUpdate bool) error {
+	locale, err := i18n.LoadTranslations()
+	if err != nil {
+		return fmt.Errorf("error loading translations: %+v", err)
+	}
+	apiloader := &api.Apiloader{
+		Translator: &i18n.Translator{
+			Locale: locale,
+		},
+	}
+
+	apimodel, err := apiloader.SerializeContainerService(cluster.ContainerService, apiVersion)
+	if err != nil {
+		return fmt.Errorf("error serializing the api model: %+v", err)
+	}
+	_, err = apiloader.LoadContainerService(apimodel, apiVersion, true, isUpdate, nil)
+	return err
+}
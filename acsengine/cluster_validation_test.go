@@ -0,0 +1,80 @@
+package acsengine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/config"
+)
+
+func TestCustomizeDiffAPIModel(t *testing.T) {
+	cases := []struct {
+		Description string
+		Change      func(raw map[string]interface{})
+		Error       string
+	}{
+		{
+			Description: "a valid cluster",
+			Change:      func(raw map[string]interface{}) {},
+		},
+		{
+			Description: "a Windows pool without a Windows profile",
+			Change: func(raw map[string]interface{}) {
+				raw["kubernetes_version"] = "1.10.0"
+				raw["agent_pool_profiles"] = []interface{}{
+					map[string]interface{}{"name": "agentpool1", "count": 1, "os_type": "Windows"},
+				}
+			},
+			Error: "WindowsProfile",
+		},
+		{
+			Description: "a Windows pool with a version that doesn't support Windows",
+			Change: func(raw map[string]interface{}) {
+				raw["kubernetes_version"] = "1.8.15"
+				raw["windows_profile"] = []interface{}{
+					map[string]interface{}{"admin_username": "azureuser", "admin_password": "Password1234$"},
+				}
+				raw["agent_pool_profiles"] = []interface{}{
+					map[string]interface{}{"name": "agentpool1", "count": 1, "os_type": "Windows"},
+				}
+			},
+			Error: `not supported with OsType "Windows"`,
+		},
+		{
+			Description: "a pool with a value that's only known after apply",
+			Change: func(raw map[string]interface{}) {
+				raw["kubernetes_version"] = "1.10.0"
+				raw["agent_pool_profiles"] = []interface{}{
+					map[string]interface{}{"name": "agentpool1", "count": 1, "os_type": "Windows", "vm_size": config.UnknownVariableValue},
+				}
+			},
+		},
+		{
+			Description: "an invalid service principal key vault",
+			Change: func(raw map[string]interface{}) {
+				raw["service_principal"] = []interface{}{
+					map[string]interface{}{"client_id": "client", "vault_id": "vault", "secret_name": "secret"},
+				}
+			},
+			Error: "keyvault secret reference",
+		},
+	}
+
+	for _, tc := range cases {
+		raw := mockClusterRawConfig("cluster", "southcentralus", "rg", "dnsprefix")
+		tc.Change(raw)
+		_, err := diffCluster(raw)
+		if tc.Error == "" {
+			if err != nil {
+				t.Fatalf("%s: diff failed: %+v", tc.Description, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Fatalf("%s: diff should have failed", tc.Description)
+		}
+		if !strings.Contains(err.Error(), "invalid cluster configuration") || !strings.Contains(err.Error(), tc.Error) {
+			t.Fatalf("%s: unexpected error: %+v", tc.Description, err)
+		}
+	}
+}
@@ -10,8 +10,11 @@ import (
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/keyvault/mgmt/keyvault"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
 	vaultsvc "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2018-05-01/network"
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-05-01/resources"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2018-02-01/storage"
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/adal"
 	"github.com/Azure/go-autorest/autorest/azure"
@@ -31,9 +34,25 @@ type ArmClient struct {
 
 	StopContext context.Context
 
-	deploymentsClient    resources.DeploymentsClient
-	providersClient      resources.ProvidersClient
-	resourceGroupsClient resources.GroupsClient
+	deploymentsClient          resources.DeploymentsClient
+	deploymentOperationsClient resources.DeploymentOperationsClient
+	providersClient            resources.ProvidersClient
+	resourceGroupsClient       resources.GroupsClient
+	resourcesClient            resources.Client
+
+	availabilitySetsClient compute.AvailabilitySetsClient
+	disksClient            compute.DisksClient
+	virtualMachinesClient  compute.VirtualMachinesClient
+	vmScaleSetsClient      compute.VirtualMachineScaleSetsClient
+
+	interfacesClient        network.InterfacesClient
+	loadBalancersClient     network.LoadBalancersClient
+	publicIPAddressesClient network.PublicIPAddressesClient
+	routeTablesClient       network.RouteTablesClient
+	securityGroupsClient    network.SecurityGroupsClient
+	virtualNetworksClient   network.VirtualNetworksClient
+
+	storageAccountsClient storage.AccountsClient
 
 	keyVaultClient           keyvault.VaultsClient
 	keyVaultManagementClient vaultsvc.BaseClient
@@ -47,6 +66,39 @@ func (c *ArmClient) configureClient(client *autorest.Client, auth autorest.Autho
 	client.PollingDuration = 60 * time.Minute
 }
 
+// returns a copy of the client whose StopContext is cancelled after timeout and whose long running operations
+// poll for up to timeout, so an operation fails within its resource timeout
+func (c *ArmClient) withTimeout(timeout time.Duration) (*ArmClient, context.CancelFunc) {
+	client := *c
+	ctx, cancel := context.WithTimeout(c.StopContext, timeout)
+	client.StopContext = ctx
+
+	for _, autorestClient := range []*autorest.Client{
+		&client.deploymentsClient.Client,
+		&client.deploymentOperationsClient.Client,
+		&client.providersClient.Client,
+		&client.resourceGroupsClient.Client,
+		&client.resourcesClient.Client,
+		&client.availabilitySetsClient.Client,
+		&client.disksClient.Client,
+		&client.virtualMachinesClient.Client,
+		&client.vmScaleSetsClient.Client,
+		&client.interfacesClient.Client,
+		&client.loadBalancersClient.Client,
+		&client.publicIPAddressesClient.Client,
+		&client.routeTablesClient.Client,
+		&client.securityGroupsClient.Client,
+		&client.virtualNetworksClient.Client,
+		&client.storageAccountsClient.Client,
+		&client.keyVaultClient.Client,
+		&client.keyVaultManagementClient.Client,
+	} {
+		autorestClient.PollingDuration = timeout
+	}
+
+	return &client, cancel
+}
+
 func withRequestLogging() autorest.SendDecorator {
 	return func(s autorest.Sender) autorest.Sender {
 		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
@@ -167,6 +219,9 @@ func getArmClient(c *authentication.Config) (*ArmClient, error) {
 	})
 
 	client.registerResourcesClients(endpoint, c.SubscriptionID, auth)
+	client.registerComputeClients(endpoint, c.SubscriptionID, auth)
+	client.registerNetworkClients(endpoint, c.SubscriptionID, auth)
+	client.registerStorageClients(endpoint, c.SubscriptionID, auth)
 	client.registerKeyVaultClients(endpoint, c.SubscriptionID, auth, keyVaultAuth, sender)
 
 	return &client, nil
@@ -191,6 +246,10 @@ func (c *ArmClient) registerResourcesClients(endpoint, subscriptionID string, au
 	c.configureClient(&deploymentsClient.Client, auth)
 	c.deploymentsClient = deploymentsClient
 
+	deploymentOperationsClient := resources.NewDeploymentOperationsClientWithBaseURI(endpoint, subscriptionID)
+	c.configureClient(&deploymentOperationsClient.Client, auth)
+	c.deploymentOperationsClient = deploymentOperationsClient
+
 	resourceGroupsClient := resources.NewGroupsClientWithBaseURI(endpoint, subscriptionID)
 	c.configureClient(&resourceGroupsClient.Client, auth)
 	c.resourceGroupsClient = resourceGroupsClient
@@ -198,6 +257,60 @@ func (c *ArmClient) registerResourcesClients(endpoint, subscriptionID string, au
 	providersClient := resources.NewProvidersClientWithBaseURI(endpoint, subscriptionID)
 	c.configureClient(&providersClient.Client, auth)
 	c.providersClient = providersClient
+
+	resourcesClient := resources.NewClientWithBaseURI(endpoint, subscriptionID)
+	c.configureClient(&resourcesClient.Client, auth)
+	c.resourcesClient = resourcesClient
+}
+
+func (c *ArmClient) registerComputeClients(endpoint, subscriptionID string, auth autorest.Authorizer) {
+	availabilitySetsClient := compute.NewAvailabilitySetsClientWithBaseURI(endpoint, subscriptionID)
+	c.configureClient(&availabilitySetsClient.Client, auth)
+	c.availabilitySetsClient = availabilitySetsClient
+
+	disksClient := compute.NewDisksClientWithBaseURI(endpoint, subscriptionID)
+	c.configureClient(&disksClient.Client, auth)
+	c.disksClient = disksClient
+
+	virtualMachinesClient := compute.NewVirtualMachinesClientWithBaseURI(endpoint, subscriptionID)
+	c.configureClient(&virtualMachinesClient.Client, auth)
+	c.virtualMachinesClient = virtualMachinesClient
+
+	vmScaleSetsClient := compute.NewVirtualMachineScaleSetsClientWithBaseURI(endpoint, subscriptionID)
+	c.configureClient(&vmScaleSetsClient.Client, auth)
+	c.vmScaleSetsClient = vmScaleSetsClient
+}
+
+func (c *ArmClient) registerNetworkClients(endpoint, subscriptionID string, auth autorest.Authorizer) {
+	interfacesClient := network.NewInterfacesClientWithBaseURI(endpoint, subscriptionID)
+	c.configureClient(&interfacesClient.Client, auth)
+	c.interfacesClient = interfacesClient
+
+	loadBalancersClient := network.NewLoadBalancersClientWithBaseURI(endpoint, subscriptionID)
+	c.configureClient(&loadBalancersClient.Client, auth)
+	c.loadBalancersClient = loadBalancersClient
+
+	publicIPAddressesClient := network.NewPublicIPAddressesClientWithBaseURI(endpoint, subscriptionID)
+	c.configureClient(&publicIPAddressesClient.Client, auth)
+	c.publicIPAddressesClient = publicIPAddressesClient
+
+	routeTablesClient := network.NewRouteTablesClientWithBaseURI(endpoint, subscriptionID)
+	c.configureClient(&routeTablesClient.Client, auth)
+	c.routeTablesClient = routeTablesClient
+
+	securityGroupsClient := network.NewSecurityGroupsClientWithBaseURI(endpoint, subscriptionID)
+	c.configureClient(&securityGroupsClient.Client, auth)
+	c.securityGroupsClient = securityGroupsClient
+
+	virtualNetworksClient := network.NewVirtualNetworksClientWithBaseURI(endpoint, subscriptionID)
+	c.configureClient(&virtualNetworksClient.Client, auth)
+	c.virtualNetworksClient = virtualNetworksClient
+}
+
+func (c *ArmClient) registerStorageClients(endpoint, subscriptionID string, auth autorest.Authorizer) {
+	storageAccountsClient := storage.NewAccountsClientWithBaseURI(endpoint, subscriptionID)
+	c.configureClient(&storageAccountsClient.Client, auth)
+	c.storageAccountsClient = storageAccountsClient
 }
 
 func (c *ArmClient) registerKeyVaultClients(endpoint, subscriptionID string, auth autorest.Authorizer, keyVaultAuth autorest.Authorizer, sender autorest.Sender) {
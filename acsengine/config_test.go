@@ -1,8 +1,10 @@
 package acsengine
 
 import (
+	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/hashicorp/terraform/terraform"
@@ -53,3 +55,24 @@ func TestAzureEnvironmentFromName(t *testing.T) {
 		assert.Equal(t, tc.output, env.Name)
 	}
 }
+
+func TestArmClientWithTimeout(t *testing.T) {
+	c := &ArmClient{StopContext: context.Background()}
+	c.deploymentsClient.PollingDuration = 60 * time.Minute
+
+	client, cancel := c.withTimeout(2 * time.Hour)
+	defer cancel()
+
+	deadline, ok := client.StopContext.Deadline()
+	assert.True(t, ok, "the client's context should have a deadline")
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), deadline, time.Minute)
+	assert.Equal(t, 2*time.Hour, client.deploymentsClient.PollingDuration)
+	assert.Equal(t, 2*time.Hour, client.vmScaleSetsClient.PollingDuration)
+
+	_, ok = c.StopContext.Deadline()
+	assert.False(t, ok, "the original client's context shouldn't change")
+	assert.Equal(t, 60*time.Minute, c.deploymentsClient.PollingDuration, "the original client's polling duration shouldn't change")
+
+	cancel()
+	assert.NotNil(t, client.StopContext.Err(), "cancelling should stop the client's context")
+}
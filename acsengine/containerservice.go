@@ -84,6 +84,7 @@ func flattenServicePrincipal(profile api.ServicePrincipalProfile) ([]interface{}
 	values["client_id"] = clientID
 	values["vault_id"] = vaultID
 	values["secret_name"] = secretName
+	values["secret_version"] = keyVaultSecretRef.SecretVersion
 
 	profiles = append(profiles, values)
 
@@ -111,13 +112,16 @@ func flattenMasterProfile(profile api.MasterProfile, location string) ([]interfa
 	if profile.OSDiskSizeGB != 0 {
 		values["os_disk_size"] = profile.OSDiskSizeGB
 	}
+	if profile.IPAddressCount != 0 {
+		values["ip_address_count"] = profile.IPAddressCount
+	}
 
 	profiles = append(profiles, values)
 
 	return profiles, nil
 }
 
-func flattenAgentPoolProfiles(profiles []*api.AgentPoolProfile) ([]interface{}, error) {
+func flattenAgentPoolProfiles(profiles []*api.AgentPoolProfile, versions map[string]string) ([]interface{}, error) {
 	agentPoolProfiles := []interface{}{}
 
 	for _, pf := range profiles {
@@ -135,6 +139,21 @@ func flattenAgentPoolProfiles(profiles []*api.AgentPoolProfile) ([]interface{},
 		if profile.OSType != "" {
 			values["os_type"] = string(profile.OSType)
 		}
+		if profile.IPAddressCount != 0 {
+			values["ip_address_count"] = profile.IPAddressCount
+		}
+		if version := versions[profile.Name]; version != "" {
+			values["kubernetes_version"] = version
+		}
+		if profile.EnableAutoScaling != nil && *profile.EnableAutoScaling {
+			values["enable_auto_scaling"] = true
+			if profile.MinCount != nil {
+				values["min_count"] = *profile.MinCount
+			}
+			if profile.MaxCount != nil {
+				values["max_count"] = *profile.MaxCount
+			}
+		}
 
 		agentPoolProfiles = append(agentPoolProfiles, values)
 	}
@@ -225,14 +244,17 @@ func (d *resourceData) expandServicePrincipal() (api.ServicePrincipalProfile, er
 	clientID := config["client_id"].(string)
 	vaultID := config["vault_id"].(string)
 	secretName := config["secret_name"].(string)
-	// secretVersion := config["version"].(string)
+	secretVersion := ""
+	if v, ok := config["secret_version"]; ok {
+		secretVersion = v.(string)
+	}
 
 	principal := api.ServicePrincipalProfile{
 		ClientID: clientID,
 		KeyvaultSecretRef: &api.KeyvaultSecretRef{
-			VaultID:    vaultID,
-			SecretName: secretName,
-			// SecretVersion: version,
+			VaultID:       vaultID,
+			SecretName:    secretName,
+			SecretVersion: secretVersion,
 		},
 	}
 
@@ -263,6 +285,16 @@ func (d *resourceData) expandMasterProfile() (api.MasterProfile, error) {
 		profile.OSDiskSizeGB = osDiskSizeGB
 	}
 
+	if ipAddressCount, ok := config["ip_address_count"].(int); ok {
+		profile.IPAddressCount = ipAddressCount
+	}
+
+	customFiles, err := expandCustomFiles(config["custom_file"])
+	if err != nil {
+		return api.MasterProfile{}, err
+	}
+	profile.CustomFiles = customFiles
+
 	return profile, nil
 }
 
@@ -272,7 +304,7 @@ func (d *resourceData) expandAgentPoolProfiles() ([]*api.AgentPoolProfile, error
 	if !ok {
 		return []*api.AgentPoolProfile{}, fmt.Errorf("cluster 'agent_pool_profiles' not found")
 	}
-	configs = v.([]interface{})
+	configs = v.(*schema.Set).List()
 	profiles := make([]*api.AgentPoolProfile, 0, len(configs))
 
 	for _, c := range configs {
@@ -294,8 +326,25 @@ func (d *resourceData) expandAgentPoolProfiles() ([]*api.AgentPoolProfile, error
 			profile.OSDiskSizeGB = osDiskSizeGB
 		}
 
+		if ipAddressCount, ok := config["ip_address_count"].(int); ok {
+			profile.IPAddressCount = ipAddressCount
+		}
+
+		if v, ok := config["enable_auto_scaling"]; ok && v.(bool) {
+			minCount := config["min_count"].(int)
+			maxCount := config["max_count"].(int)
+			if err := validateAgentPoolAutoScaling(name, count, minCount, maxCount); err != nil {
+				return nil, err
+			}
+			enableAutoScaling := true
+			profile.EnableAutoScaling = &enableAutoScaling
+			profile.MinCount = &minCount
+			profile.MaxCount = &maxCount
+		}
+
 		profiles = append(profiles, profile)
 	}
+	sortAgentPoolProfiles(profiles)
 
 	return profiles, nil
 }
@@ -372,6 +421,12 @@ func (d *resourceData) setContainerService() (containerService, error) {
 		cluster.Properties.WindowsProfile = windowsProfile
 	}
 
+	d.expandNetworkPlugin(&cluster)
+
+	if err := cluster.setClusterAutoscalerAddon(); err != nil {
+		return containerService{}, fmt.Errorf("error setting cluster autoscaler: %+v", err)
+	}
+
 	return cluster, nil
 }
 
@@ -401,6 +456,8 @@ func (d *resourceData) loadContainerServiceFromApimodel(validate, isUpdate bool)
 
 	// make sure the location is normalized
 
+	cluster.setAgentPoolAutoScaling()
+
 	return cluster, nil
 }
 
@@ -426,7 +483,7 @@ func (d *resourceData) setStateAPIModel(cluster *containerService) error {
 	return nil
 }
 
-func (d *resourceData) setStateProfiles(cluster *containerService) error {
+func (d *resourceData) setStateProfiles(cluster *containerService, agentPoolVersions map[string]string) error {
 	linuxProfile, err := flattenLinuxProfile(*cluster.Properties.LinuxProfile)
 	if err != nil {
 		return fmt.Errorf("Error flattening `linux_profile`: %+v", err)
@@ -449,11 +506,14 @@ func (d *resourceData) setStateProfiles(cluster *containerService) error {
 	if err != nil {
 		return fmt.Errorf("Error flattening `master_profile`: %+v", err)
 	}
+	if customFiles := cluster.Properties.MasterProfile.CustomFiles; customFiles != nil {
+		masterProfile[0].(map[string]interface{})["custom_file"] = flattenCustomFiles(customFiles, d.getCustomFileHashes())
+	}
 	if err = d.Set("master_profile", masterProfile); err != nil {
 		return fmt.Errorf("Error setting 'master_profile': %+v", err)
 	}
 
-	agentPoolProfiles, err := flattenAgentPoolProfiles(cluster.Properties.AgentPoolProfiles)
+	agentPoolProfiles, err := flattenAgentPoolProfiles(cluster.Properties.AgentPoolProfiles, agentPoolVersions)
 	if err != nil {
 		return fmt.Errorf("Error flattening `agent_pool_profiles`: %+v", err)
 	}
@@ -465,7 +525,7 @@ func (d *resourceData) setStateProfiles(cluster *containerService) error {
 }
 
 func (d *resourceData) setResourceStateProfiles(cluster *containerService) error {
-	if err := d.setStateProfiles(cluster); err != nil {
+	if err := d.setStateProfiles(cluster, d.getAgentPoolVersions()); err != nil {
 		return err
 	}
 
@@ -481,7 +541,7 @@ func (d *resourceData) setResourceStateProfiles(cluster *containerService) error
 }
 
 func (d *resourceData) setDataSourceStateProfiles(cluster *containerService) error {
-	if err := d.setStateProfiles(cluster); err != nil {
+	if err := d.setStateProfiles(cluster, nil); err != nil {
 		return err
 	}
 
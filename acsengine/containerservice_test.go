@@ -98,6 +98,7 @@ func TestFlattenServicePrincipal(t *testing.T) {
 	clientID := "client id"
 	vaultID := "vault id"
 	profile := tester.MockExpandServicePrincipal(clientID, vaultID)
+	profile.KeyvaultSecretRef.SecretVersion = "version"
 
 	servicePrincipal, err := flattenServicePrincipal(profile)
 	if err != nil {
@@ -109,6 +110,7 @@ func TestFlattenServicePrincipal(t *testing.T) {
 	val, ok := spPf["client_id"]
 	assert.True(t, ok, "flattenServicePrincipal failed: Master count does not exist")
 	assert.Equal(t, clientID, val)
+	assert.Equal(t, "version", spPf["secret_version"])
 }
 
 func TestFlattenUnsetServicePrincipal(t *testing.T) {
@@ -230,7 +232,7 @@ func TestFlattenAgentPoolProfiles(t *testing.T) {
 	profile2 := tester.MockExpandAgentPoolProfile(name, count, vmSize, osDiskSize, false)
 
 	profiles := []*api.AgentPoolProfile{profile1, profile2}
-	agentPoolProfiles, err := flattenAgentPoolProfiles(profiles)
+	agentPoolProfiles, err := flattenAgentPoolProfiles(profiles, nil)
 	if err != nil {
 		t.Fatalf("flattenAgentPoolProfiles failed: %v", err)
 	}
@@ -269,7 +271,7 @@ func TestFlattenAgentPoolProfilesWithOSType(t *testing.T) {
 	profile2 := tester.MockExpandAgentPoolProfile(name, count, vmSize, 0, true)
 
 	profiles := []*api.AgentPoolProfile{profile1, profile2}
-	agentPoolProfiles, err := flattenAgentPoolProfiles(profiles)
+	agentPoolProfiles, err := flattenAgentPoolProfiles(profiles, nil)
 	if err != nil {
 		t.Fatalf("flattenAgentPoolProfiles failed: %v", err)
 	}
@@ -294,7 +296,7 @@ func TestFlattenAgentPoolProfilesWithOSType(t *testing.T) {
 func TestFlattenUnsetAgentPoolProfiles(t *testing.T) {
 	profile := &api.AgentPoolProfile{}
 	profiles := []*api.AgentPoolProfile{profile}
-	if _, err := flattenAgentPoolProfiles(profiles); err == nil {
+	if _, err := flattenAgentPoolProfiles(profiles, nil); err == nil {
 		t.Fatalf("flattenAgentPoolProfiles should have failed with unset values")
 	}
 }
@@ -336,6 +338,7 @@ func TestExpandServicePrincipal(t *testing.T) {
 
 	clientID := testClientID()
 	servicePrincipals := tester.MockFlattenServicePrincipal()
+	servicePrincipals[0].(map[string]interface{})["secret_version"] = "version"
 	d.Set("service_principal", servicePrincipals)
 
 	servicePrincipal, err := d.expandServicePrincipal()
@@ -344,6 +347,7 @@ func TestExpandServicePrincipal(t *testing.T) {
 	}
 
 	assert.Equal(t, clientID, servicePrincipal.ClientID)
+	assert.Equal(t, "version", servicePrincipal.KeyvaultSecretRef.SecretVersion)
 }
 
 func TestExpandMasterProfile(t *testing.T) {
@@ -377,7 +381,7 @@ func TestExpandAgentPoolProfiles(t *testing.T) {
 	agentPoolProfiles = append(agentPoolProfiles, agentPoolProfile0)
 	agentPoolProfile1 := tester.MockFlattenAgentPoolProfiles(agentPool2Name, agentPool2Count, "Standard_D2_v2", agentPool2osDiskSize, true)
 	agentPoolProfiles = append(agentPoolProfiles, agentPoolProfile1)
-	d.Set("agent_pool_profiles", &agentPoolProfiles)
+	d.Set("agent_pool_profiles", agentPoolProfiles)
 
 	profiles, err := d.expandAgentPoolProfiles()
 	if err != nil {
@@ -448,7 +452,7 @@ func TestSetProfiles(t *testing.T) {
 	d := mockClusterResourceData("name1", "westus", "testrg", "creativeMasterDNSPrefix")
 	cluster := mockCluster("name2", "southcentralus", dnsPrefix)
 
-	if err := d.setStateProfiles(cluster); err != nil {
+	if err := d.setStateProfiles(cluster, nil); err != nil {
 		t.Fatalf("setProfiles failed: %+v", err)
 	}
 	v, ok := d.GetOk("master_profile.0.dns_name_prefix")
@@ -488,3 +492,89 @@ func TestSetDataSourceProfiles(t *testing.T) {
 	assert.True(t, ok, "failed to get 'master_profile.0.dns_name_prefix'")
 	assert.Equal(t, dnsPrefix, v.(string), "'master_profile.0.dns_name_prefix' is not set correctly")
 }
+
+func TestExpandAgentPoolProfilesWithAutoScaling(t *testing.T) {
+	cases := []struct {
+		Count       int
+		MinCount    int
+		MaxCount    int
+		ExpectError bool
+	}{
+		{Count: 2, MinCount: 1, MaxCount: 5, ExpectError: false},
+		{Count: 2, MinCount: 0, MaxCount: 0, ExpectError: true},
+		{Count: 2, MinCount: 5, MaxCount: 1, ExpectError: true},
+		{Count: 6, MinCount: 1, MaxCount: 5, ExpectError: true},
+	}
+
+	for _, tc := range cases {
+		d := mockClusterResourceData("name", "southcentralus", "rg", "prefix")
+
+		agentPoolProfile := tester.MockFlattenAgentPoolProfiles("agentpool1", tc.Count, "Standard_D2_v2", 0, false)
+		agentPoolProfile["enable_auto_scaling"] = true
+		agentPoolProfile["min_count"] = tc.MinCount
+		agentPoolProfile["max_count"] = tc.MaxCount
+		agentPoolProfiles := []interface{}{agentPoolProfile}
+		d.Set("agent_pool_profiles", agentPoolProfiles)
+
+		profiles, err := d.expandAgentPoolProfiles()
+		if tc.ExpectError {
+			assert.NotNil(t, err, "expected error for count %d, min count %d and max count %d", tc.Count, tc.MinCount, tc.MaxCount)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("expand agent pool profiles failed: %v", err)
+		}
+
+		assert.True(t, *profiles[0].EnableAutoScaling, "auto scaling should be enabled")
+		assert.Equal(t, tc.MinCount, *profiles[0].MinCount)
+		assert.Equal(t, tc.MaxCount, *profiles[0].MaxCount)
+	}
+}
+
+func TestFlattenAgentPoolProfilesWithAutoScaling(t *testing.T) {
+	enabled := true
+	minCount, maxCount := 1, 5
+	profile := tester.MockExpandAgentPoolProfile("agentpool1", 2, "Standard_D2_v2", 0, false)
+	profile.EnableAutoScaling = &enabled
+	profile.MinCount = &minCount
+	profile.MaxCount = &maxCount
+
+	agentPoolProfiles, err := flattenAgentPoolProfiles([]*api.AgentPoolProfile{profile}, nil)
+	if err != nil {
+		t.Fatalf("flattenAgentPoolProfiles failed: %v", err)
+	}
+
+	agentPf := agentPoolProfiles[0].(map[string]interface{})
+	assert.Equal(t, true, agentPf["enable_auto_scaling"])
+	assert.Equal(t, minCount, agentPf["min_count"])
+	assert.Equal(t, maxCount, agentPf["max_count"])
+}
+
+func TestExpandAgentPoolProfilesWithKubernetesVersion(t *testing.T) {
+	d := mockClusterResourceData("name", "southcentralus", "rg", "prefix")
+
+	agentPoolProfile := tester.MockFlattenAgentPoolProfiles("agentpool1", 1, "Standard_D2_v2", 0, false)
+	agentPoolProfile["kubernetes_version"] = "1.10.3"
+	d.Set("agent_pool_profiles", []interface{}{agentPoolProfile})
+
+	profiles, err := d.expandAgentPoolProfiles()
+	if err != nil {
+		t.Fatalf("expand agent pool profiles failed: %v", err)
+	}
+
+	assert.Nil(t, profiles[0].CustomNodeLabels, "the version shouldn't be recorded as a node label")
+}
+
+func TestFlattenAgentPoolProfilesWithKubernetesVersion(t *testing.T) {
+	profile1 := tester.MockExpandAgentPoolProfile("agentpool1", 1, "Standard_D2_v2", 0, false)
+	profile2 := tester.MockExpandAgentPoolProfile("agentpool2", 1, "Standard_D2_v2", 0, false)
+
+	agentPoolProfiles, err := flattenAgentPoolProfiles([]*api.AgentPoolProfile{profile1, profile2}, map[string]string{"agentpool2": "1.10.3"})
+	if err != nil {
+		t.Fatalf("flattenAgentPoolProfiles failed: %v", err)
+	}
+
+	_, ok := agentPoolProfiles[0].(map[string]interface{})["kubernetes_version"]
+	assert.False(t, ok, "a pool running the control plane version shouldn't have a version")
+	assert.Equal(t, "1.10.3", agentPoolProfiles[1].(map[string]interface{})["kubernetes_version"])
+}
@@ -0,0 +1,129 @@
+package acsengine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/hashicorp/terraform/helper/hashcode"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// acs-engine embeds custom files in the master custom data, so files are read from the local machine.
+// Each custom file is keyed by a hash of its contents, which is read from the source file for config and
+// from `content_hash` for state, so a changed file shows up in the plan as a master profile update.
+
+func customFileSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeSet,
+		Optional: true,
+		Set:      customFileHash,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"source": {
+					Type:     schema.TypeString,
+					Required: true,
+				},
+				"dest": {
+					Type:     schema.TypeString,
+					Required: true,
+				},
+				"content_hash": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+			},
+		},
+	}
+}
+
+func customFileHash(v interface{}) int {
+	m := v.(map[string]interface{})
+	source := m["source"].(string)
+	contentHash, _ := m["content_hash"].(string)
+	if contentHash == "" {
+		// a missing file is reported when the master profile is expanded
+		contentHash, _ = fileContentHash(source)
+	}
+
+	return hashcode.String(fmt.Sprintf("%s-%s-%s", source, m["dest"].(string), contentHash))
+}
+
+func fileContentHash(source string) (string, error) {
+	contents, err := ioutil.ReadFile(source)
+	if err != nil {
+		return "", fmt.Errorf("error reading custom file %q: %+v", source, err)
+	}
+	sum := sha256.Sum256(contents)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func expandCustomFiles(v interface{}) (*[]api.CustomFile, error) {
+	set, ok := v.(*schema.Set)
+	if !ok || set.Len() == 0 {
+		return nil, nil
+	}
+
+	customFiles := []api.CustomFile{}
+	for _, f := range set.List() {
+		config := f.(map[string]interface{})
+		source := config["source"].(string)
+		if _, err := fileContentHash(source); err != nil {
+			return nil, err
+		}
+		customFiles = append(customFiles, api.CustomFile{
+			Source: source,
+			Dest:   config["dest"].(string),
+		})
+	}
+
+	return &customFiles, nil
+}
+
+// contentHashes maps custom file destinations to the hashes of the file contents that were deployed.
+// A set is returned since nested sets can't be written from a list.
+func flattenCustomFiles(customFiles *[]api.CustomFile, contentHashes map[string]string) *schema.Set {
+	files := []interface{}{}
+	if customFiles == nil {
+		return schema.NewSet(customFileHash, files)
+	}
+
+	for _, customFile := range *customFiles {
+		values := map[string]interface{}{}
+		values["source"] = customFile.Source
+		values["dest"] = customFile.Dest
+		if contentHash, ok := contentHashes[customFile.Dest]; ok {
+			values["content_hash"] = contentHash
+		}
+		files = append(files, values)
+	}
+
+	return schema.NewSet(customFileHash, files)
+}
+
+// returns the content hash of each custom file, keyed by destination. Hashes already in state are kept
+// so they still describe what was deployed, and new files are hashed from their source.
+func (d *resourceData) getCustomFileHashes() map[string]string {
+	hashes := map[string]string{}
+	set, ok := d.Get("master_profile.0.custom_file").(*schema.Set)
+	if !ok {
+		return hashes
+	}
+
+	for _, f := range set.List() {
+		config := f.(map[string]interface{})
+		contentHash, _ := config["content_hash"].(string)
+		if contentHash == "" {
+			var err error
+			if contentHash, err = fileContentHash(config["source"].(string)); err != nil {
+				continue
+			}
+		}
+		hashes[config["dest"].(string)] = contentHash
+	}
+
+	return hashes
+}
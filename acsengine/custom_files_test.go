@@ -0,0 +1,143 @@
+package acsengine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeCustomFile(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "customfiles")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %+v", err)
+	}
+	source := filepath.Join(dir, "audit-policy.yaml")
+	if err = ioutil.WriteFile(source, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write custom file: %+v", err)
+	}
+	return source
+}
+
+func TestCustomFileHash(t *testing.T) {
+	source := writeCustomFile(t, "apiVersion: audit.k8s.io/v1beta1")
+	defer os.RemoveAll(filepath.Dir(source))
+	dest := "/etc/kubernetes/audit-policy.yaml"
+
+	contentHash, err := fileContentHash(source)
+	if err != nil {
+		t.Fatalf("fileContentHash failed: %+v", err)
+	}
+	config := map[string]interface{}{"source": source, "dest": dest}
+	state := map[string]interface{}{"source": source, "dest": dest, "content_hash": contentHash}
+	assert.Equal(t, customFileHash(state), customFileHash(config), "unchanged file should have the same hash as state")
+
+	if err = ioutil.WriteFile(source, []byte("apiVersion: audit.k8s.io/v1"), 0644); err != nil {
+		t.Fatalf("failed to write custom file: %+v", err)
+	}
+	assert.NotEqual(t, customFileHash(state), customFileHash(config), "changed file should not have the same hash as state")
+}
+
+func TestExpandCustomFiles(t *testing.T) {
+	source := writeCustomFile(t, "kind: Policy")
+	defer os.RemoveAll(filepath.Dir(source))
+	dest := "/etc/kubernetes/audit-policy.yaml"
+
+	set := schema.NewSet(customFileHash, []interface{}{
+		map[string]interface{}{"source": source, "dest": dest},
+	})
+	customFiles, err := expandCustomFiles(set)
+	if err != nil {
+		t.Fatalf("expandCustomFiles failed: %+v", err)
+	}
+	assert.Equal(t, []api.CustomFile{{Source: source, Dest: dest}}, *customFiles)
+
+	customFiles, err = expandCustomFiles(schema.NewSet(customFileHash, []interface{}{}))
+	if err != nil {
+		t.Fatalf("expandCustomFiles failed: %+v", err)
+	}
+	assert.Nil(t, customFiles, "there should be no custom files")
+
+	set = schema.NewSet(customFileHash, []interface{}{
+		map[string]interface{}{"source": filepath.Join(filepath.Dir(source), "missing.yaml"), "dest": dest},
+	})
+	if _, err = expandCustomFiles(set); err == nil {
+		t.Fatalf("expandCustomFiles should have failed for a missing file")
+	}
+}
+
+func TestFlattenCustomFiles(t *testing.T) {
+	customFiles := &[]api.CustomFile{
+		{Source: "/tmp/audit-policy.yaml", Dest: "/etc/kubernetes/audit-policy.yaml"},
+		{Source: "/tmp/admission.yaml", Dest: "/etc/kubernetes/admission.yaml"},
+	}
+	hashes := map[string]string{"/etc/kubernetes/audit-policy.yaml": "abc123"}
+
+	files := flattenCustomFiles(customFiles, hashes)
+	if files.Len() != 2 {
+		t.Fatalf("expected 2 custom files, got %d", files.Len())
+	}
+	for _, f := range files.List() {
+		file := f.(map[string]interface{})
+		contentHash, ok := file["content_hash"]
+		if file["dest"] == "/etc/kubernetes/audit-policy.yaml" {
+			assert.Equal(t, "/tmp/audit-policy.yaml", file["source"])
+			assert.Equal(t, "abc123", contentHash)
+		} else {
+			assert.False(t, ok, "content hash should not be set for unknown file")
+		}
+	}
+
+	assert.Equal(t, 0, flattenCustomFiles(nil, hashes).Len())
+}
+
+func TestGetCustomFileHashes(t *testing.T) {
+	source := writeCustomFile(t, "kind: AdmissionConfiguration")
+	defer os.RemoveAll(filepath.Dir(source))
+	contentHash, err := fileContentHash(source)
+	if err != nil {
+		t.Fatalf("fileContentHash failed: %+v", err)
+	}
+
+	d := mockClusterResourceData("cluster", "southcentralus", "rg", "dnsprefix")
+	masterProfile := d.Get("master_profile").([]interface{})[0].(map[string]interface{})
+	masterProfile["custom_file"] = schema.NewSet(customFileHash, []interface{}{
+		map[string]interface{}{"source": source, "dest": "/etc/kubernetes/admission.yaml"},
+		map[string]interface{}{"source": "/tmp/old.yaml", "dest": "/etc/kubernetes/old.yaml", "content_hash": "abc123"},
+	})
+	if err = d.Set("master_profile", []interface{}{masterProfile}); err != nil {
+		t.Fatalf("failed to set master profile: %+v", err)
+	}
+
+	hashes := d.getCustomFileHashes()
+	assert.Equal(t, contentHash, hashes["/etc/kubernetes/admission.yaml"], "new file should be hashed from source")
+	assert.Equal(t, "abc123", hashes["/etc/kubernetes/old.yaml"], "hash in state should be kept")
+}
+
+func TestSetProfilesWithCustomFiles(t *testing.T) {
+	d := mockClusterResourceData("cluster", "southcentralus", "rg", "dnsprefix")
+	dest := "/etc/kubernetes/admission.yaml"
+	masterProfile := d.Get("master_profile").([]interface{})[0].(map[string]interface{})
+	masterProfile["custom_file"] = schema.NewSet(customFileHash, []interface{}{
+		map[string]interface{}{"source": "/tmp/admission.yaml", "dest": dest, "content_hash": "abc123"},
+	})
+	if err := d.Set("master_profile", []interface{}{masterProfile}); err != nil {
+		t.Fatalf("failed to set master profile: %+v", err)
+	}
+
+	cluster := mockCluster("cluster", "southcentralus", "dnsprefix")
+	cluster.Properties.MasterProfile.CustomFiles = &[]api.CustomFile{{Source: "/tmp/admission.yaml", Dest: dest}}
+	if err := d.setStateProfiles(cluster, nil); err != nil {
+		t.Fatalf("setStateProfiles failed: %+v", err)
+	}
+
+	files := d.Get("master_profile.0.custom_file").(*schema.Set).List()
+	if len(files) != 1 {
+		t.Fatalf("expected 1 custom file, got %d", len(files))
+	}
+	assert.Equal(t, "abc123", files[0].(map[string]interface{})["content_hash"], "content hash should be kept")
+}
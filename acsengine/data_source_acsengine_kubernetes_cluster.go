@@ -24,6 +24,11 @@ func dataSourceACSEngineKubernetesCluster() *schema.Resource {
 
 			"kubernetes_version": kubernetesVersionForDataSourceSchema(),
 
+			"network_plugin": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
 			"linux_profile": {
 				Type:     schema.TypeList,
 				Computed: true,
@@ -101,6 +106,27 @@ func dataSourceACSEngineKubernetesCluster() *schema.Resource {
 							Type:     schema.TypeInt,
 							Computed: true,
 						},
+						"ip_address_count": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"custom_file": {
+							Type:     schema.TypeSet,
+							Computed: true,
+							Set:      customFileHash,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"source": {
+										Type:     schema.TypeString,
+										Computed: true,
+									},
+									"dest": {
+										Type:     schema.TypeString,
+										Computed: true,
+									},
+								},
+							},
+						},
 					},
 				},
 			},
@@ -122,6 +148,10 @@ func dataSourceACSEngineKubernetesCluster() *schema.Resource {
 							Type:     schema.TypeInt,
 							Computed: true,
 						},
+						"ip_address_count": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
 						"vm_size": {
 							Type:     schema.TypeString,
 							Computed: true,
@@ -130,6 +160,18 @@ func dataSourceACSEngineKubernetesCluster() *schema.Resource {
 							Type:     schema.TypeString,
 							Computed: true,
 						},
+						"enable_auto_scaling": {
+							Type:     schema.TypeBool,
+							Computed: true,
+						},
+						"min_count": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"max_count": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
 					},
 				},
 			},
@@ -224,6 +266,10 @@ func dataSourceACSEngineK8sClusterRead(data *schema.ResourceData, m interface{})
 		return fmt.Errorf("Error setting kubernetes_version: %+v", err)
 	}
 
+	if err = d.setNetworkPlugin(&cluster); err != nil {
+		return err
+	}
+
 	if err := d.setDataSourceStateProfiles(&cluster); err != nil {
 		return err
 	}
@@ -0,0 +1,98 @@
+package acsengine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-05-01/resources"
+)
+
+// ARM only reports that a deployment failed, so like acs-engine's DeploymentError the deployment's operations are
+// listed to find which of the template's resources failed and why. The full list of operations is logged at debug
+// level.
+
+// returns the deployment's error along with the resources that failed to deploy
+func deploymentError(c *ArmClient, resourceGroup, name string, err error) error {
+	operations, listErr := listDeploymentOperations(c, resourceGroup, name)
+	if listErr != nil {
+		log.Printf("[WARN] failed to list operations of deployment %q: %+v", name, listErr)
+		return fmt.Errorf("error creating deployment: %+v", err)
+	}
+	if b, marshalErr := json.MarshalIndent(operations, "", "  "); marshalErr == nil {
+		log.Printf("[DEBUG] operations of deployment %q: %s", name, string(b))
+	}
+
+	failed := failedDeploymentOperations(operations)
+	if len(failed) == 0 {
+		return fmt.Errorf("error creating deployment: %+v", err)
+	}
+	return fmt.Errorf("error creating deployment: %+v\n\nresources that failed to deploy:\n%s", err, strings.Join(failed, "\n"))
+}
+
+func listDeploymentOperations(c *ArmClient, resourceGroup, name string) ([]resources.DeploymentOperation, error) {
+	// the deployment may have failed because the resource timeout passed
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointTimeout)
+	defer cancel()
+	iter, err := c.deploymentOperationsClient.ListComplete(ctx, resourceGroup, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	operations := []resources.DeploymentOperation{}
+	for iter.NotDone() {
+		operations = append(operations, iter.Value())
+		if err = iter.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return operations, nil
+}
+
+// returns a line for each failed operation with its resource and status message
+func failedDeploymentOperations(operations []resources.DeploymentOperation) []string {
+	failed := []string{}
+	for _, operation := range operations {
+		properties := operation.Properties
+		if properties == nil || properties.ProvisioningState == nil || !strings.EqualFold(*properties.ProvisioningState, "Failed") {
+			continue
+		}
+		resource := "unknown resource"
+		if target := properties.TargetResource; target != nil && target.ResourceType != nil && target.ResourceName != nil {
+			resource = fmt.Sprintf("%s %q", *target.ResourceType, *target.ResourceName)
+		}
+		status := ""
+		if properties.StatusCode != nil {
+			status = *properties.StatusCode + ": "
+		}
+		failed = append(failed, fmt.Sprintf("  * %s: %s%s", resource, status, deploymentStatusMessage(properties.StatusMessage)))
+	}
+	return failed
+}
+
+// status messages are usually an ARM error, whose details hold the errors of nested deployments and extensions
+func deploymentStatusMessage(statusMessage interface{}) string {
+	armError, ok := statusMessage.(map[string]interface{})
+	if nested, isNested := armError["error"].(map[string]interface{}); isNested {
+		armError = nested
+	}
+	text, hasMessage := armError["message"].(string)
+	if !ok || !hasMessage {
+		b, err := json.Marshal(statusMessage)
+		if err != nil {
+			return fmt.Sprintf("%v", statusMessage)
+		}
+		return string(b)
+	}
+
+	if code, ok := armError["code"].(string); ok {
+		text = fmt.Sprintf("%s: %s", code, text)
+	}
+	if details, ok := armError["details"].([]interface{}); ok {
+		for _, detail := range details {
+			text += "; " + deploymentStatusMessage(detail)
+		}
+	}
+	return text
+}
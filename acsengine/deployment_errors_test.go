@@ -0,0 +1,84 @@
+package acsengine
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-05-01/resources"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailedDeploymentOperations(t *testing.T) {
+	operations := []resources.DeploymentOperation{
+		{
+			Properties: &resources.DeploymentOperationProperties{
+				ProvisioningState: to.StringPtr("Succeeded"),
+				TargetResource: &resources.TargetResource{
+					ResourceType: to.StringPtr("Microsoft.Network/loadBalancers"),
+					ResourceName: to.StringPtr("k8s-master-lb-12345678"),
+				},
+			},
+		},
+		{
+			Properties: &resources.DeploymentOperationProperties{
+				ProvisioningState: to.StringPtr("Failed"),
+				StatusCode:        to.StringPtr("Conflict"),
+				StatusMessage: map[string]interface{}{
+					"error": map[string]interface{}{
+						"code":    "OperationNotAllowed",
+						"message": "Operation results in exceeding quota limits of Core.",
+					},
+				},
+				TargetResource: &resources.TargetResource{
+					ResourceType: to.StringPtr("Microsoft.Compute/virtualMachines"),
+					ResourceName: to.StringPtr("k8s-master-12345678-0"),
+				},
+			},
+		},
+		{
+			Properties: &resources.DeploymentOperationProperties{
+				ProvisioningState: to.StringPtr("Failed"),
+				StatusMessage:     "timed out",
+			},
+		},
+		{},
+	}
+
+	expected := []string{
+		`  * Microsoft.Compute/virtualMachines "k8s-master-12345678-0": Conflict: OperationNotAllowed: Operation results in exceeding quota limits of Core.`,
+		`  * unknown resource: "timed out"`,
+	}
+	assert.Equal(t, expected, failedDeploymentOperations(operations))
+}
+
+func TestDeploymentStatusMessage(t *testing.T) {
+	cases := []struct {
+		StatusMessage interface{}
+		Expected      string
+	}{
+		{
+			StatusMessage: map[string]interface{}{
+				"error": map[string]interface{}{
+					"code":    "VMExtensionProvisioningError",
+					"message": "VM has reported a failure when processing extension 'cse-master-0'.",
+					"details": []interface{}{
+						map[string]interface{}{"code": "50", "message": "exit status 50"},
+					},
+				},
+			},
+			Expected: "VMExtensionProvisioningError: VM has reported a failure when processing extension 'cse-master-0'.; 50: exit status 50",
+		},
+		{
+			StatusMessage: map[string]interface{}{"status": "Failed"},
+			Expected:      `{"status":"Failed"}`,
+		},
+		{
+			StatusMessage: nil,
+			Expected:      "null",
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.Expected, deploymentStatusMessage(tc.StatusMessage))
+	}
+}
@@ -0,0 +1,166 @@
+package acsengine
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/acs-engine/pkg/acsengine"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2018-02-01/storage"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// acs-engine doesn't add boot diagnostics to Kubernetes templates and the vlabs api model doesn't store a
+// diagnostics profile, so the profile is kept in state and added to templates before they are deployed
+
+func diagnosticsProfileSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"enabled": {
+					Type:     schema.TypeBool,
+					Required: true,
+					ForceNew: true,
+				},
+				"storage_uri": {
+					Type:     schema.TypeString,
+					Optional: true,
+					Computed: true,
+					ForceNew: true,
+				},
+			},
+		},
+	}
+}
+
+// returns whether boot diagnostics are enabled and the storage URI they are written to
+func (d *resourceData) getDiagnosticsProfile() (bool, string) {
+	v, ok := d.GetOk("diagnostics_profile")
+	if !ok {
+		return false, ""
+	}
+	profiles := v.([]interface{})
+	if len(profiles) == 0 || profiles[0] == nil {
+		return false, ""
+	}
+	config := profiles[0].(map[string]interface{})
+
+	return config["enabled"].(bool), config["storage_uri"].(string)
+}
+
+func (d *resourceData) setDiagnosticsStorageURI(storageURI string) error {
+	profile := map[string]interface{}{
+		"enabled":     true,
+		"storage_uri": storageURI,
+	}
+	if err := d.Set("diagnostics_profile", []interface{}{profile}); err != nil {
+		return fmt.Errorf("Error setting `diagnostics_profile`: %+v", err)
+	}
+
+	return nil
+}
+
+// creates a storage account for boot diagnostics in the cluster resource group if one wasn't given
+func createDiagnosticsStorageAccount(d *resourceData, c *ArmClient, cluster *containerService) error {
+	enabled, storageURI := d.getDiagnosticsProfile()
+	if !enabled || storageURI != "" {
+		return nil
+	}
+
+	name := diagnosticsStorageAccountName(cluster.Properties.MasterProfile.DNSPrefix, acsengine.GenerateClusterID(cluster.Properties))
+	location := cluster.Location
+	parameters := storage.AccountCreateParameters{
+		Sku: &storage.Sku{
+			Name: storage.StandardLRS,
+		},
+		Kind:     storage.StorageV2,
+		Location: &location,
+		Tags:     expandTags(d.getTags()),
+	}
+
+	accountsClient := c.storageAccountsClient
+	future, err := accountsClient.Create(c.StopContext, cluster.ResourceGroup, name, parameters)
+	if err != nil {
+		return fmt.Errorf("error creating storage account %q: %+v", name, err)
+	}
+	if err = future.WaitForCompletion(c.StopContext, accountsClient.Client); err != nil {
+		return fmt.Errorf("error waiting for storage account %q: %+v", name, err)
+	}
+	account, err := future.Result(accountsClient)
+	if err != nil {
+		return fmt.Errorf("error getting storage account %q: %+v", name, err)
+	}
+	if account.AccountProperties == nil || account.PrimaryEndpoints == nil || account.PrimaryEndpoints.Blob == nil {
+		return fmt.Errorf("blob endpoint not found for storage account %q", name)
+	}
+	log.Printf("[INFO] boot diagnostics storage account %q created", name)
+
+	return d.setDiagnosticsStorageURI(*account.PrimaryEndpoints.Blob)
+}
+
+// storage account names must be 3 to 24 lowercase letters and numbers and globally unique
+func diagnosticsStorageAccountName(dnsPrefix, clusterID string) string {
+	prefix := strings.ToLower(regexp.MustCompile(`[^a-zA-Z0-9]`).ReplaceAllString(dnsPrefix, ""))
+	if len(prefix) > 12 {
+		prefix = prefix[:12]
+	}
+	return fmt.Sprintf("%sdiag%s", prefix, clusterID)
+}
+
+// returns a template transformer that enables boot diagnostics, or nil if they are disabled
+func (d *resourceData) bootDiagnosticsTransformer() func(template map[string]interface{}) error {
+	enabled, storageURI := d.getDiagnosticsProfile()
+	if !enabled {
+		return nil
+	}
+	return func(template map[string]interface{}) error {
+		return setBootDiagnostics(template, storageURI)
+	}
+}
+
+// enables boot diagnostics on every VM and VM scale set in the template
+func setBootDiagnostics(template map[string]interface{}, storageURI string) error {
+	if storageURI == "" {
+		return fmt.Errorf("storage URI is required for boot diagnostics")
+	}
+	resources, ok := template["resources"].([]interface{})
+	if !ok {
+		return fmt.Errorf("template resources not found")
+	}
+
+	diagnosticsProfile := func() map[string]interface{} {
+		return map[string]interface{}{
+			"bootDiagnostics": map[string]interface{}{
+				"enabled":    true,
+				"storageUri": storageURI,
+			},
+		}
+	}
+
+	for _, r := range resources {
+		resource, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		properties, ok := resource["properties"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch resource["type"] {
+		case "Microsoft.Compute/virtualMachines":
+			properties["diagnosticsProfile"] = diagnosticsProfile()
+		case "Microsoft.Compute/virtualMachineScaleSets":
+			vmProfile, ok := properties["virtualMachineProfile"].(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("virtual machine profile not found for scale set %v", resource["name"])
+			}
+			vmProfile["diagnosticsProfile"] = diagnosticsProfile()
+		}
+	}
+
+	return nil
+}
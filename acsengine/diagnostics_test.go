@@ -0,0 +1,97 @@
+package acsengine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDiagnosticsProfile(t *testing.T) {
+	d := mockClusterResourceData("cluster", "southcentralus", "rg", "dnsprefix")
+
+	enabled, storageURI := d.getDiagnosticsProfile()
+	assert.False(t, enabled, "boot diagnostics should be disabled by default")
+	assert.Equal(t, "", storageURI)
+	assert.Nil(t, d.bootDiagnosticsTransformer(), "there should be no transformer when boot diagnostics are disabled")
+
+	uri := "https://diagnostics.blob.core.windows.net/"
+	if err := d.setDiagnosticsStorageURI(uri); err != nil {
+		t.Fatalf("setDiagnosticsStorageURI failed: %+v", err)
+	}
+
+	enabled, storageURI = d.getDiagnosticsProfile()
+	assert.True(t, enabled, "boot diagnostics should be enabled")
+	assert.Equal(t, uri, storageURI)
+	assert.NotNil(t, d.bootDiagnosticsTransformer(), "there should be a transformer when boot diagnostics are enabled")
+}
+
+func TestDiagnosticsStorageAccountName(t *testing.T) {
+	cases := []struct {
+		DNSPrefix string
+		Expected  string
+	}{
+		{
+			DNSPrefix: "prefix",
+			Expected:  "prefixdiag12345678",
+		},
+		{
+			DNSPrefix: "Creative-DNS-Prefix-That-Is-Long",
+			Expected:  "creativednspdiag12345678",
+		},
+	}
+
+	for _, tc := range cases {
+		name := diagnosticsStorageAccountName(tc.DNSPrefix, "12345678")
+		assert.Equal(t, tc.Expected, name)
+		assert.True(t, len(name) <= 24, "storage account name %q is too long", name)
+	}
+}
+
+func TestSetBootDiagnostics(t *testing.T) {
+	uri := "https://diagnostics.blob.core.windows.net/"
+	template := map[string]interface{}{
+		"resources": []interface{}{
+			map[string]interface{}{
+				"type":       "Microsoft.Compute/virtualMachines",
+				"properties": map[string]interface{}{},
+			},
+			map[string]interface{}{
+				"type": "Microsoft.Compute/virtualMachineScaleSets",
+				"properties": map[string]interface{}{
+					"virtualMachineProfile": map[string]interface{}{},
+				},
+			},
+			map[string]interface{}{
+				"type":       "Microsoft.Network/networkInterfaces",
+				"properties": map[string]interface{}{},
+			},
+		},
+	}
+
+	if err := setBootDiagnostics(template, uri); err != nil {
+		t.Fatalf("setBootDiagnostics failed: %+v", err)
+	}
+
+	resources := template["resources"].([]interface{})
+	vm := resources[0].(map[string]interface{})["properties"].(map[string]interface{})
+	bootDiagnostics := vm["diagnosticsProfile"].(map[string]interface{})["bootDiagnostics"].(map[string]interface{})
+	assert.Equal(t, true, bootDiagnostics["enabled"])
+	assert.Equal(t, uri, bootDiagnostics["storageUri"])
+
+	vmss := resources[1].(map[string]interface{})["properties"].(map[string]interface{})["virtualMachineProfile"].(map[string]interface{})
+	_, ok := vmss["diagnosticsProfile"]
+	assert.True(t, ok, "diagnostics profile should be set on scale set")
+
+	nic := resources[2].(map[string]interface{})["properties"].(map[string]interface{})
+	_, ok = nic["diagnosticsProfile"]
+	assert.False(t, ok, "diagnostics profile should not be set on network interface")
+}
+
+func TestSetBootDiagnosticsWithoutStorageURI(t *testing.T) {
+	template := map[string]interface{}{
+		"resources": []interface{}{},
+	}
+	if err := setBootDiagnostics(template, ""); err == nil {
+		t.Fatalf("setBootDiagnostics should have failed without a storage URI")
+	}
+}
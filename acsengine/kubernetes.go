@@ -18,6 +18,15 @@ func kubernetesVersionSchema() *schema.Schema {
 	}
 }
 
+// an agent pool without a version runs the control plane version
+func agentPoolKubernetesVersionSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:         schema.TypeString,
+		Optional:     true,
+		ValidateFunc: validateKubernetesVersion,
+	}
+}
+
 func kubernetesVersionForDataSourceSchema() *schema.Schema {
 	return &schema.Schema{
 		Type:     schema.TypeString,
@@ -3,6 +3,9 @@ package acsengine
 import (
 	"github.com/Azure/terraform-provider-acsengine/internal/resource"
 	"github.com/Azure/terraform-provider-acsengine/internal/tester"
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
 )
 
 func mockClusterResourceData(name, location, resourceGroup, dnsPrefix string) *resourceData {
@@ -37,7 +40,7 @@ func mockClusterResourceData(name, location, resourceGroup, dnsPrefix string) *r
 	agentPoolProfiles = append(agentPoolProfiles, agentPoolProfile0)
 	agentPoolProfile1 := tester.MockFlattenAgentPoolProfiles(agentPool2Name, agentPool2Count, "Standard_D2_v2", agentPool2osDiskSize, true)
 	agentPoolProfiles = append(agentPoolProfiles, agentPoolProfile1)
-	d.Set("agent_pool_profiles", &agentPoolProfiles)
+	d.Set("agent_pool_profiles", agentPoolProfiles)
 
 	d.Set("tags", map[string]interface{}{})
 
@@ -51,3 +54,78 @@ func mockCluster(name, location, dnsPrefix string) *containerService {
 	cluster := tester.MockContainerService(name, location, dnsPrefix)
 	return newContainerService(cluster)
 }
+
+// returns the raw configuration of a cluster, which can be changed and used to plan a new resource
+func mockClusterRawConfig(name, location, resourceGroup, dnsPrefix string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":           name,
+		"location":       location,
+		"resource_group": resourceGroup,
+		"linux_profile": []interface{}{
+			map[string]interface{}{
+				"admin_username": "azureuser",
+				"ssh": []interface{}{
+					map[string]interface{}{"key_data": "ssh-rsa AAAA"},
+				},
+			},
+		},
+		"service_principal": []interface{}{
+			map[string]interface{}{
+				"client_id":   "client",
+				"vault_id":    "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.KeyVault/vaults/vault",
+				"secret_name": "secret",
+			},
+		},
+		"master_profile": []interface{}{
+			map[string]interface{}{
+				"count":           1,
+				"dns_name_prefix": dnsPrefix,
+			},
+		},
+		"agent_pool_profiles": []interface{}{
+			map[string]interface{}{
+				"name":  "agentpool1",
+				"count": 1,
+			},
+		},
+	}
+}
+
+// plans a new cluster resource from raw configuration
+func diffCluster(raw map[string]interface{}) (*terraform.InstanceDiff, error) {
+	return diffClusterState(nil, raw)
+}
+
+// plans changes to a cluster resource from raw configuration
+func diffClusterState(state *terraform.InstanceState, raw map[string]interface{}) (*terraform.InstanceDiff, error) {
+	c, err := config.NewRawConfig(raw)
+	if err != nil {
+		return nil, err
+	}
+	r := resourceArmACSEngineKubernetesCluster()
+	return r.Diff(state, terraform.NewResourceConfig(c), nil)
+}
+
+// returns the state of a cluster created from raw configuration, without deploying anything
+func applyCluster(raw map[string]interface{}, apimodel string) (*terraform.InstanceState, error) {
+	diff, err := diffCluster(raw)
+	if err != nil {
+		return nil, err
+	}
+	r := resourceArmACSEngineKubernetesCluster()
+	r.Create = func(data *schema.ResourceData, m interface{}) error {
+		data.SetId("id")
+		return data.Set("api_model", apimodel)
+	}
+	return r.Apply(nil, diff, nil)
+}
+
+// returns the agent pool with the given name, since agent pools are a set
+func mockAgentPool(d *resourceData, name string) map[string]interface{} {
+	for _, p := range d.Get("agent_pool_profiles").(*schema.Set).List() {
+		if profile := p.(map[string]interface{}); profile["name"] == name {
+			return profile
+		}
+	}
+	return nil
+}
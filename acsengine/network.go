@@ -0,0 +1,120 @@
+package acsengine
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/Azure/acs-engine/pkg/acsengine"
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/apparentlymart/go-cidr/cidr"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+)
+
+// Azure reserves the first four addresses and the last address of every subnet
+const azureReservedSubnetAddresses = 5
+
+func networkPluginSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeString,
+		Optional: true,
+		Computed: true,
+		ForceNew: true,
+		ValidateFunc: validation.StringInSlice([]string{
+			acsengine.NetworkPluginKubenet,
+			acsengine.NetworkPluginAzure,
+		}, false),
+	}
+}
+
+// agent pools are checked for changes in customizeDiffAgentPools, since new pools can be added in place
+func ipAddressCountSchema(forceNew bool) *schema.Schema {
+	return &schema.Schema{
+		Type:         schema.TypeInt,
+		Optional:     true,
+		Computed:     true,
+		ForceNew:     forceNew,
+		ValidateFunc: validation.IntBetween(1, 256),
+	}
+}
+
+func (d *resourceData) expandNetworkPlugin(cluster *containerService) {
+	networkPlugin, ok := d.GetOk("network_plugin")
+	if !ok {
+		return
+	}
+	if cluster.Properties.OrchestratorProfile.KubernetesConfig == nil {
+		cluster.Properties.OrchestratorProfile.KubernetesConfig = &api.KubernetesConfig{}
+	}
+	cluster.Properties.OrchestratorProfile.KubernetesConfig.NetworkPlugin = networkPlugin.(string)
+}
+
+func (d *resourceData) setNetworkPlugin(cluster *containerService) error {
+	networkPlugin := acsengine.DefaultNetworkPlugin
+	if kubernetesConfig := cluster.Properties.OrchestratorProfile.KubernetesConfig; kubernetesConfig != nil && kubernetesConfig.NetworkPlugin != "" {
+		networkPlugin = kubernetesConfig.NetworkPlugin
+	}
+	if err := d.Set("network_plugin", networkPlugin); err != nil {
+		return fmt.Errorf("error setting `network_plugin`: %+v", err)
+	}
+
+	return nil
+}
+
+// Masters and agents share the subnet acs-engine creates for the cluster, which is much larger with
+// Azure CNI since pods get addresses from it too
+func clusterSubnet(networkPlugin string) string {
+	if networkPlugin == acsengine.NetworkPluginAzure {
+		return acsengine.DefaultKubernetesSubnet
+	}
+	return acsengine.DefaultKubernetesMasterSubnet
+}
+
+// returns the number of addresses acs-engine allocates for each node when `ip_address_count` isn't set
+func defaultIPAddressCount(networkPlugin string) int {
+	if networkPlugin == acsengine.NetworkPluginAzure {
+		return 1 + acsengine.DefaultKubernetesMaxPodsVNETIntegrated
+	}
+	return 1
+}
+
+func validateSubnetCapacity(subnet string, requiredAddresses int) error {
+	_, network, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return fmt.Errorf("error parsing subnet %q: %+v", subnet, err)
+	}
+	available := cidr.AddressCount(network) - azureReservedSubnetAddresses
+	if uint64(requiredAddresses) > available {
+		return fmt.Errorf("subnet %q has %d usable addresses but the cluster needs %d, lower `ip_address_count` or the node count", subnet, available, requiredAddresses)
+	}
+
+	return nil
+}
+
+// checks at plan time that the cluster subnet has room for every node's addresses, so it isn't
+// found to be full part of the way through a deployment
+func customizeDiffSubnetCapacity(d *schema.ResourceDiff) error {
+	networkPlugin := acsengine.DefaultNetworkPlugin
+	if v, ok := d.GetOk("network_plugin"); ok {
+		networkPlugin = v.(string)
+	}
+	ipAddressCount := func(v interface{}) int {
+		if count, ok := v.(int); ok && count > 0 {
+			return count
+		}
+		return defaultIPAddressCount(networkPlugin)
+	}
+
+	required := d.Get("master_profile.0.count").(int) * ipAddressCount(d.Get("master_profile.0.ip_address_count"))
+	for _, p := range d.Get("agent_pool_profiles").(*schema.Set).List() {
+		profile := p.(map[string]interface{})
+		count := profile["count"].(int)
+		// auto scaled pools can grow to their maximum size
+		if profile["enable_auto_scaling"].(bool) && profile["max_count"].(int) > count {
+			count = profile["max_count"].(int)
+		}
+		required += count * ipAddressCount(profile["ip_address_count"])
+	}
+
+	return validateSubnetCapacity(clusterSubnet(networkPlugin), required)
+}
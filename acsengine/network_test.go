@@ -0,0 +1,91 @@
+package acsengine
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Azure/acs-engine/pkg/acsengine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSubnetCapacity(t *testing.T) {
+	cases := []struct {
+		Subnet      string
+		Required    int
+		ExpectError bool
+	}{
+		{Subnet: "10.240.0.0/16", Required: 100, ExpectError: false},
+		{Subnet: "10.240.0.0/24", Required: 251, ExpectError: false},
+		{Subnet: "10.240.0.0/24", Required: 252, ExpectError: true},
+		{Subnet: "not a subnet", Required: 1, ExpectError: true},
+	}
+
+	for _, tc := range cases {
+		err := validateSubnetCapacity(tc.Subnet, tc.Required)
+		if tc.ExpectError {
+			assert.NotNil(t, err, "expected an error for %d addresses in subnet %s", tc.Required, tc.Subnet)
+		} else {
+			assert.Nil(t, err, "expected no error for %d addresses in subnet %s", tc.Required, tc.Subnet)
+		}
+	}
+}
+
+func TestClusterSubnet(t *testing.T) {
+	assert.Equal(t, acsengine.DefaultKubernetesSubnet, clusterSubnet(acsengine.NetworkPluginAzure))
+	assert.Equal(t, acsengine.DefaultKubernetesMasterSubnet, clusterSubnet(acsengine.NetworkPluginKubenet))
+	assert.Equal(t, 31, defaultIPAddressCount(acsengine.NetworkPluginAzure))
+	assert.Equal(t, 1, defaultIPAddressCount(acsengine.NetworkPluginKubenet))
+}
+
+func TestNetworkPlugin(t *testing.T) {
+	d := mockClusterResourceData("cluster", "southcentralus", "rg", "dnsprefix")
+	cluster := mockCluster("cluster", "southcentralus", "dnsprefix")
+
+	if err := d.setNetworkPlugin(cluster); err != nil {
+		t.Fatalf("setNetworkPlugin failed: %+v", err)
+	}
+	assert.Equal(t, acsengine.NetworkPluginKubenet, d.Get("network_plugin").(string), "network plugin should default to kubenet")
+
+	d.Set("network_plugin", acsengine.NetworkPluginAzure)
+	d.expandNetworkPlugin(cluster)
+	assert.Equal(t, acsengine.NetworkPluginAzure, cluster.Properties.OrchestratorProfile.KubernetesConfig.NetworkPlugin)
+}
+
+func TestCustomizeDiffSubnetCapacity(t *testing.T) {
+	cases := []struct {
+		NetworkPlugin  string
+		PoolCount      int
+		IPAddressCount int
+		ExpectError    bool
+	}{
+		{NetworkPlugin: "azure", PoolCount: 3, IPAddressCount: 0, ExpectError: false},
+		{NetworkPlugin: "azure", PoolCount: 3, IPAddressCount: 256, ExpectError: false},
+		{NetworkPlugin: "kubenet", PoolCount: 3, IPAddressCount: 0, ExpectError: false},
+		{NetworkPlugin: "kubenet", PoolCount: 2, IPAddressCount: 256, ExpectError: false},
+		{NetworkPlugin: "kubenet", PoolCount: 3, IPAddressCount: 256, ExpectError: true},
+	}
+
+	for _, tc := range cases {
+		raw := mockClusterRawConfig("cluster", "southcentralus", "rg", "dnsprefix")
+		raw["network_plugin"] = tc.NetworkPlugin
+		pools := []interface{}{}
+		for i := 0; i < tc.PoolCount; i++ {
+			pool := map[string]interface{}{
+				"name":  fmt.Sprintf("agentpool%d", i),
+				"count": 100,
+			}
+			if tc.IPAddressCount > 0 {
+				pool["ip_address_count"] = tc.IPAddressCount
+			}
+			pools = append(pools, pool)
+		}
+		raw["agent_pool_profiles"] = pools
+
+		_, err := diffCluster(raw)
+		if tc.ExpectError {
+			assert.NotNil(t, err, "expected an error for %d pools with %d addresses using %s", tc.PoolCount, tc.IPAddressCount, tc.NetworkPlugin)
+		} else {
+			assert.Nil(t, err, "expected no error for %d pools with %d addresses using %s", tc.PoolCount, tc.IPAddressCount, tc.NetworkPlugin)
+		}
+	}
+}
@@ -17,10 +17,10 @@ package acsengine
 
 import (
 	"fmt"
-	"strconv"
+	"log"
+	"time"
 
 	"github.com/Azure/acs-engine/pkg/api"
-	"github.com/Azure/terraform-provider-acsengine/internal/kubernetes"
 	"github.com/Azure/terraform-provider-acsengine/internal/resource"
 	"github.com/Azure/terraform-provider-acsengine/internal/response"
 	"github.com/hashicorp/terraform/helper/schema"
@@ -36,6 +36,17 @@ func resourceArmACSEngineKubernetesCluster() *schema.Resource {
 		Importer: &schema.ResourceImporter{
 			State: resourceACSEngineK8sClusterImport,
 		},
+		CustomizeDiff: resourceACSEngineK8sClusterCustomizeDiff,
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(90 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(180 * time.Minute),
+			Delete: schema.DefaultTimeout(60 * time.Minute),
+		},
+
+		SchemaVersion: 1,
+		MigrateState:  resourceACSEngineK8sClusterMigrateState,
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -48,8 +59,16 @@ func resourceArmACSEngineKubernetesCluster() *schema.Resource {
 
 			"kubernetes_version": kubernetesVersionSchema(),
 
+			"upgrade_path": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
 			"location": locationSchema(),
 
+			"network_plugin": networkPluginSchema(),
+
 			"linux_profile": {
 				Type:     schema.TypeList,
 				Required: true,
@@ -70,7 +89,6 @@ func resourceArmACSEngineKubernetesCluster() *schema.Resource {
 									"key_data": {
 										Type:     schema.TypeString,
 										Required: true,
-										ForceNew: true,
 									},
 								},
 							},
@@ -120,13 +138,11 @@ func resourceArmACSEngineKubernetesCluster() *schema.Resource {
 						"secret_name": {
 							Type:     schema.TypeString,
 							Required: true,
-							ForceNew: true,
 						},
-						// "secret_version": {
-						// 	Type:     schema.TypeString,
-						// 	Required: true,
-						// 	ForceNew: true,
-						// },
+						"secret_version": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
 					},
 				},
 			},
@@ -141,7 +157,6 @@ func resourceArmACSEngineKubernetesCluster() *schema.Resource {
 							Type:         schema.TypeInt,
 							Optional:     true,
 							Default:      1,
-							ForceNew:     true,
 							ValidateFunc: validateMasterProfileCount,
 						},
 						"dns_name_prefix": {
@@ -165,42 +180,61 @@ func resourceArmACSEngineKubernetesCluster() *schema.Resource {
 							Optional: true,
 							ForceNew: true,
 						},
+						"ip_address_count": ipAddressCountSchema(true),
+						"custom_file":      customFileSchema(),
 					},
 				},
 			},
 
 			"agent_pool_profiles": {
-				Type:     schema.TypeList, // may need to keep list sorted
+				Type:     schema.TypeSet,
 				Required: true,
+				Set:      agentPoolProfileHash,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
 						"name": {
 							Type:     schema.TypeString,
 							Required: true,
-							ForceNew: true,
 						},
 						"count": {
+							Type:             schema.TypeInt,
+							Optional:         true,
+							Default:          1,
+							ValidateFunc:     validateAgentPoolProfileCount,
+							DiffSuppressFunc: autoScalingCountDiffSuppressFunc,
+						},
+						"current_count": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"enable_auto_scaling": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+						"min_count": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validateAgentPoolProfileCount,
+						},
+						"max_count": {
 							Type:         schema.TypeInt,
 							Optional:     true,
-							Default:      1,
 							ValidateFunc: validateAgentPoolProfileCount,
 						},
 						"vm_size": {
 							Type:             schema.TypeString,
 							Optional:         true,
 							Default:          "Standard_DS1_v2",
-							ForceNew:         true,
 							DiffSuppressFunc: ignoreCaseDiffSuppressFunc,
 						},
 						"os_disk_size": {
 							Type:     schema.TypeInt,
 							Optional: true,
-							ForceNew: true,
 						},
 						"os_type": {
 							Type:     schema.TypeString,
 							Optional: true,
-							ForceNew: true,
 							Default:  api.Linux,
 							ValidateFunc: validation.StringInSlice([]string{
 								string(api.Linux),
@@ -208,10 +242,28 @@ func resourceArmACSEngineKubernetesCluster() *schema.Resource {
 							}, true),
 							DiffSuppressFunc: ignoreCaseDiffSuppressFunc,
 						},
+						"ip_address_count":   ipAddressCountSchema(false),
+						"kubernetes_version": agentPoolKubernetesVersionSchema(),
 					},
 				},
 			},
 
+			"diagnostics_profile": diagnosticsProfileSchema(),
+
+			"update_strategy": updateStrategySchema(),
+
+			"manage_resource_group": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+
+			"delete_resource_group": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+
 			"kube_config": {
 				Type:     schema.TypeList,
 				Computed: true,
@@ -250,6 +302,39 @@ func resourceArmACSEngineKubernetesCluster() *schema.Resource {
 
 			"kube_config_raw": kubeConfigRawSchema(),
 
+			"certificate_rotation_id": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"certificate_authority_rotation_id": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"agent_pool_versions": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
+			"pending_certificate_fingerprint": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"ssh_key_updated_nodes": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
+			"certificate_expiry": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
 			"api_model": {
 				Type:      schema.TypeString,
 				Computed:  true,
@@ -268,21 +353,35 @@ const (
 
 func resourceACSEngineK8sClusterCreate(data *schema.ResourceData, m interface{}) error {
 	d := newResourceData(data)
-	client := m.(*ArmClient)
+	client, cancel := m.(*ArmClient).withTimeout(d.Timeout(schema.TimeoutCreate))
+	defer cancel()
 
 	cluster, err := d.setContainerService()
 	if err != nil {
 		return fmt.Errorf("failed to set cluster: %+v", err)
 	}
 
-	if err := createClusterResourceGroup(d, client); err != nil {
-		return fmt.Errorf("failed to create resource group: %+v", err)
+	if d.managesResourceGroup() {
+		if err := createClusterResourceGroup(d, client); err != nil {
+			return fmt.Errorf("failed to create resource group: %+v", err)
+		}
+	} else if err := verifyClusterResourceGroup(d, client); err != nil {
+		return err
+	}
+
+	if err := createDiagnosticsStorageAccount(d, client, &cluster); err != nil {
+		return fmt.Errorf("failed to create boot diagnostics storage account: %+v", err)
 	}
 
 	template, parameters, err := generateACSEngineTemplate(client, cluster, true)
 	if err != nil {
 		return fmt.Errorf("failed to generate ACS Engine template: %+v", err)
 	}
+	if transform := d.templateTransformer(); transform != nil {
+		if template, err = transformTemplate(template, transform); err != nil {
+			return fmt.Errorf("failed to transform template: %+v", err)
+		}
+	}
 	if err = d.setStateAPIModel(&cluster); err != nil {
 		return fmt.Errorf("error setting API model: %+v", err)
 	}
@@ -304,7 +403,18 @@ func resourceACSEngineK8sClusterRead(data *schema.ResourceData, m interface{}) e
 		d.SetId("")
 		return err
 	}
-	client := m.(*ArmClient)
+	client, cancel := m.(*ArmClient).withTimeout(d.Timeout(schema.TimeoutRead))
+	defer cancel()
+
+	exists, err := clusterResourceGroupExists(client, id.ResourceGroup)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		log.Printf("[WARN] resource group of cluster %q not found, removing from state", d.Id())
+		d.SetId("")
+		return nil
+	}
 
 	if err = d.Set("resource_group", id.ResourceGroup); err != nil {
 		return fmt.Errorf("error setting `resource_group`: %+v", err)
@@ -324,11 +434,46 @@ func resourceACSEngineK8sClusterRead(data *schema.ResourceData, m interface{}) e
 	if err = d.Set("kubernetes_version", cluster.Properties.OrchestratorProfile.OrchestratorVersion); err != nil {
 		return fmt.Errorf("error setting `kubernetes_version`: %+v", err)
 	}
+	// the path is only planned while an upgrade is pending
+	if err = d.Set("upgrade_path", []string{}); err != nil {
+		return fmt.Errorf("error setting `upgrade_path`: %+v", err)
+	}
+	if err = d.setNetworkPlugin(&cluster); err != nil {
+		return err
+	}
 
 	if err = d.setResourceStateProfiles(&cluster); err != nil {
 		return err
 	}
 
+	nodes, err := getClusterNodes(client, &cluster, id.ResourceGroup)
+	if err != nil {
+		return fmt.Errorf("error getting cluster nodes: %+v", err)
+	}
+	if nodes["master"] == nil {
+		// Azure prunes old deployments from a resource group's history, so a missing deployment only means the
+		// cluster is gone if its masters are too
+		deploymentName, _, err := deploymentNameAndResourceGroup(d.Id())
+		if err != nil {
+			return fmt.Errorf("error parsing Azure resource ID %q: %+v", d.Id(), err)
+		}
+		exists, err := clusterDeploymentExists(client, id.ResourceGroup, deploymentName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			log.Printf("[WARN] deployment and masters of cluster %q not found, removing from state", d.Id())
+			d.SetId("")
+			return nil
+		}
+	}
+	if err = d.setAgentPoolCurrentCounts(poolNodeCounts(nodes)); err != nil {
+		return err
+	}
+	if err = d.setClusterNodeDrift(nodes); err != nil {
+		return err
+	}
+
 	if err = d.setTags(cluster.Tags); err != nil {
 		return err
 	}
@@ -337,11 +482,16 @@ func resourceACSEngineK8sClusterRead(data *schema.ResourceData, m interface{}) e
 		return err
 	}
 
+	if err = d.setCertificateExpiry(client, &cluster); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func resourceACSEngineK8sClusterDelete(d *schema.ResourceData, m interface{}) error {
-	client := m.(*ArmClient)
+	client, cancel := m.(*ArmClient).withTimeout(d.Timeout(schema.TimeoutDelete))
+	defer cancel()
 	rgClient := client.resourceGroupsClient
 
 	id, err := resource.ParseAzureResourceID(d.Id())
@@ -349,6 +499,11 @@ func resourceACSEngineK8sClusterDelete(d *schema.ResourceData, m interface{}) er
 		return fmt.Errorf("error parsing Azure resource ID %q: %+v", d.Id(), err)
 	}
 
+	// an externally managed resource group is never deleted
+	if data := newResourceData(d); !data.Get("delete_resource_group").(bool) || !data.managesResourceGroup() {
+		return deleteClusterResources(data, client, id.ResourceGroup)
+	}
+
 	deleteFuture, err := rgClient.Delete(client.StopContext, id.ResourceGroup)
 	if err != nil {
 		if response.WasNotFound(deleteFuture.Response()) {
@@ -376,43 +531,110 @@ func resourceACSEngineK8sClusterDelete(d *schema.ResourceData, m interface{}) er
 	return nil
 }
 
+func resourceACSEngineK8sClusterCustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	if err := customizeDiffSubnetCapacity(d); err != nil {
+		return err
+	}
+	if err := customizeDiffAgentPools(d); err != nil {
+		return err
+	}
+	if err := customizeDiffMasterCount(d); err != nil {
+		return err
+	}
+	if err := customizeDiffUpgradePath(d); err != nil {
+		return err
+	}
+	if err := customizeDiffAgentPoolVersions(d); err != nil {
+		return err
+	}
+	if err := customizeDiffCertificateRotation(d); err != nil {
+		return err
+	}
+	if err := customizeDiffUpdateStrategy(d); err != nil {
+		return err
+	}
+	if err := customizeDiffManageResourceGroup(d); err != nil {
+		return err
+	}
+	if err := customizeDiffAPIModel(d); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func resourceACSEngineK8sClusterUpdate(data *schema.ResourceData, m interface{}) error {
 	d := newResourceData(data)
-	c := m.(*ArmClient)
+	c, cancel := m.(*ArmClient).withTimeout(d.Timeout(schema.TimeoutUpdate))
+	defer cancel()
 	var err error
 
 	d.Partial(true)
 
 	if d.HasChange("kubernetes_version") {
-		old, new := d.GetChange("kubernetes_version")
-		if err = kubernetes.ValidateKubernetesVersionUpgrade(new.(string), old.(string)); err != nil {
-			return fmt.Errorf("error upgrading Kubernetes version: %+v", err)
-		}
-		if err = upgradeCluster(d, c, new.(string)); err != nil {
+		if err = upgradeCluster(d, c, d.Get("kubernetes_version").(string), d.agentPoolsToUpgrade()); err != nil {
 			return fmt.Errorf("error upgrading Kubernetes version: %+v", err)
 		}
 
 		d.SetPartial("kubernetes_version")
+	} else if agentPools := d.agentPoolsToUpgrade(); len(agentPools) > 0 {
+		// the masters already run the control plane version, so only the agent pools are upgraded
+		if err = upgradeCluster(d, c, d.Get("kubernetes_version").(string), agentPools); err != nil {
+			return fmt.Errorf("error upgrading agent pools: %+v", err)
+		}
+	}
+
+	if d.HasChange("service_principal.0.secret_name") || d.HasChange("service_principal.0.secret_version") {
+		if err = updateServicePrincipalSecret(d, c); err != nil {
+			return fmt.Errorf("error updating service principal secret: %+v", err)
+		}
+
+		d.SetPartial("service_principal")
+	}
+
+	if d.HasChange("linux_profile.0.ssh.0.key_data") {
+		if err = updateSSHKey(d, c); err != nil {
+			return fmt.Errorf("error updating SSH key: %+v", err)
+		}
+
+		d.SetPartial("linux_profile")
+	}
+
+	if d.HasChange("master_profile.0.count") {
+		if err = scaleMasters(d, c, d.Get("master_profile.0.count").(int)); err != nil {
+			return fmt.Errorf("error adding master nodes: %+v", err)
+		}
+	}
+
+	if d.HasChange("master_profile.0.custom_file") {
+		if err = updateMasterNodes(d, c); err != nil {
+			return fmt.Errorf("error updating master nodes: %+v", err)
+		}
+	}
+
+	if d.HasChange("master_profile.0.count") || d.HasChange("master_profile.0.custom_file") {
+		d.SetPartial("master_profile")
+	}
+
+	if d.HasChange("certificate_rotation_id") || d.HasChange("certificate_authority_rotation_id") {
+		if err = rotateCertificates(d, c, d.HasChange("certificate_authority_rotation_id")); err != nil {
+			return fmt.Errorf("error rotating certificates: %+v", err)
+		}
+
+		d.SetPartial("certificate_rotation_id")
+		d.SetPartial("certificate_authority_rotation_id")
 	}
 
-	agentPoolProfiles := d.Get("agent_pool_profiles").([]interface{})
-	for i := 0; i < len(agentPoolProfiles); i++ {
-		profileCount := "agent_pool_profiles." + strconv.Itoa(i) + ".count"
-		if d.HasChange(profileCount) {
-			count, ok := d.GetOk(profileCount)
-			if !ok {
-				return fmt.Errorf("failed to get agent pool profile count")
-			}
-			if err = scaleCluster(d, c, i, count.(int)); err != nil {
-				return fmt.Errorf("error scaling agent pool: %+v", err)
-			}
+	if d.HasChange("agent_pool_profiles") {
+		if err = updateAgentPools(d, c); err != nil {
+			return fmt.Errorf("error updating agent pools: %+v", err)
 		}
 
-		d.SetPartial(profileCount)
+		d.SetPartial("agent_pool_profiles")
 	}
 
 	if d.HasChange("tags") {
-		if err = updateResourceGroupTags(d, c); err != nil {
+		if err = updateClusterTags(d, c); err != nil {
 			return fmt.Errorf("error updating tags: %+v", err)
 		}
 
@@ -0,0 +1,79 @@
+package acsengine
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func resourceACSEngineK8sClusterMigrateState(v int, is *terraform.InstanceState, meta interface{}) (*terraform.InstanceState, error) {
+	switch v {
+	case 0:
+		log.Println("[INFO] Found ACS Engine Kubernetes cluster state v0; migrating to v1")
+		return migrateK8sClusterStateV0toV1(is)
+	default:
+		return is, fmt.Errorf("Unexpected schema version: %d", v)
+	}
+}
+
+// v0 stored `agent_pool_profiles` as a list, so pool attributes are moved from list indexes to set hash codes
+func migrateK8sClusterStateV0toV1(is *terraform.InstanceState) (*terraform.InstanceState, error) {
+	if is.Empty() {
+		log.Println("[DEBUG] Empty InstanceState; nothing to migrate.")
+		return is, nil
+	}
+
+	log.Printf("[DEBUG] Attributes before migration: %#v", is.Attributes)
+
+	count, ok := is.Attributes["agent_pool_profiles.#"]
+	if !ok {
+		return is, nil
+	}
+	poolCount, err := strconv.Atoi(count)
+	if err != nil {
+		return is, fmt.Errorf("error parsing agent pool count %q: %+v", count, err)
+	}
+
+	// prefixes are all found before renaming so a hash code can't be mistaken for a list index
+	prefixes := map[string]string{}
+	for i := 0; i < poolCount; i++ {
+		oldPrefix := fmt.Sprintf("agent_pool_profiles.%d.", i)
+		name, ok := is.Attributes[oldPrefix+"name"]
+		if !ok {
+			return is, fmt.Errorf("agent pool %d has no name", i)
+		}
+		profile := map[string]interface{}{"name": name}
+		for _, field := range []string{"vm_size", "os_type"} {
+			if v, ok := is.Attributes[oldPrefix+field]; ok {
+				profile[field] = v
+			}
+		}
+		for _, field := range []string{"count", "os_disk_size", "min_count", "max_count"} {
+			profile[field] = 0
+			if v, ok := is.Attributes[oldPrefix+field]; ok {
+				if profile[field], err = strconv.Atoi(v); err != nil {
+					return is, fmt.Errorf("error parsing agent pool %s %q: %+v", field, v, err)
+				}
+			}
+		}
+		profile["enable_auto_scaling"] = is.Attributes[oldPrefix+"enable_auto_scaling"] == "true"
+		prefixes[oldPrefix] = fmt.Sprintf("agent_pool_profiles.%d.", agentPoolProfileHash(profile))
+	}
+
+	attributes := map[string]string{}
+	for k, v := range is.Attributes {
+		if parts := strings.SplitN(k, ".", 3); len(parts) == 3 {
+			if newPrefix, ok := prefixes[parts[0]+"."+parts[1]+"."]; ok {
+				k = newPrefix + parts[2]
+			}
+		}
+		attributes[k] = v
+	}
+	is.Attributes = attributes
+
+	log.Printf("[DEBUG] Attributes after migration: %#v", is.Attributes)
+	return is, nil
+}
@@ -0,0 +1,112 @@
+package acsengine
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACSEngineK8sClusterMigrateState(t *testing.T) {
+	agentPool1 := fmt.Sprintf("agent_pool_profiles.%d", agentPoolProfileHash(map[string]interface{}{
+		"name":                "agentpool1",
+		"count":               1,
+		"vm_size":             "Standard_D2_v2",
+		"os_type":             "Linux",
+		"os_disk_size":        0,
+		"enable_auto_scaling": false,
+	}))
+	agentPool2 := fmt.Sprintf("agent_pool_profiles.%d", agentPoolProfileHash(map[string]interface{}{
+		"name":                "agentpool2",
+		"count":               3,
+		"vm_size":             "Standard_D2_v2",
+		"os_type":             "Windows",
+		"os_disk_size":        30,
+		"enable_auto_scaling": true,
+		"min_count":           1,
+		"max_count":           5,
+	}))
+	cases := map[string]struct {
+		StateVersion int
+		Attributes   map[string]string
+		Expected     map[string]string
+		ExpectError  bool
+	}{
+		"v0_1_agent_pools": {
+			StateVersion: 0,
+			Attributes: map[string]string{
+				"name":                                      "cluster",
+				"agent_pool_profiles.#":                     "2",
+				"agent_pool_profiles.0.name":                "agentpool1",
+				"agent_pool_profiles.0.count":               "1",
+				"agent_pool_profiles.0.vm_size":             "Standard_D2_v2",
+				"agent_pool_profiles.0.os_type":             "Linux",
+				"agent_pool_profiles.0.enable_auto_scaling": "false",
+				"agent_pool_profiles.1.name":                "agentpool2",
+				"agent_pool_profiles.1.count":               "3",
+				"agent_pool_profiles.1.vm_size":             "Standard_D2_v2",
+				"agent_pool_profiles.1.os_type":             "Windows",
+				"agent_pool_profiles.1.os_disk_size":        "30",
+				"agent_pool_profiles.1.enable_auto_scaling": "true",
+				"agent_pool_profiles.1.min_count":           "1",
+				"agent_pool_profiles.1.max_count":           "5",
+			},
+			Expected: map[string]string{
+				"name":                              "cluster",
+				"agent_pool_profiles.#":             "2",
+				agentPool1 + ".name":                "agentpool1",
+				agentPool1 + ".count":               "1",
+				agentPool1 + ".vm_size":             "Standard_D2_v2",
+				agentPool1 + ".os_type":             "Linux",
+				agentPool1 + ".enable_auto_scaling": "false",
+				agentPool2 + ".name":                "agentpool2",
+				agentPool2 + ".count":               "3",
+				agentPool2 + ".vm_size":             "Standard_D2_v2",
+				agentPool2 + ".os_type":             "Windows",
+				agentPool2 + ".os_disk_size":        "30",
+				agentPool2 + ".enable_auto_scaling": "true",
+				agentPool2 + ".min_count":           "1",
+				agentPool2 + ".max_count":           "5",
+			},
+		},
+		"v0_1_no_agent_pools": {
+			StateVersion: 0,
+			Attributes: map[string]string{
+				"name": "cluster",
+			},
+			Expected: map[string]string{
+				"name": "cluster",
+			},
+		},
+		"v0_1_missing_name": {
+			StateVersion: 0,
+			Attributes: map[string]string{
+				"agent_pool_profiles.#":       "1",
+				"agent_pool_profiles.0.count": "1",
+			},
+			ExpectError: true,
+		},
+		"unknown_version": {
+			StateVersion: 1,
+			Attributes:   map[string]string{},
+			ExpectError:  true,
+		},
+	}
+
+	for name, tc := range cases {
+		is := &terraform.InstanceState{
+			ID:         "id",
+			Attributes: tc.Attributes,
+		}
+		is, err := resourceACSEngineK8sClusterMigrateState(tc.StateVersion, is, nil)
+		if tc.ExpectError {
+			assert.NotNil(t, err, "expected an error for %s", name)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("bad: %s, err: %+v", name, err)
+		}
+		assert.Equal(t, tc.Expected, is.Attributes, name)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform/helper/acctest"
@@ -36,7 +37,7 @@ func TestAccACSEngineK8sCluster_createBasic(t *testing.T) {
 					resource.TestCheckResourceAttr(tfResourceName, "linux_profile.0.admin_username", "acctestuser"+strconv.Itoa(ri)),
 					resource.TestCheckResourceAttr(tfResourceName, "master_profile.0.dns_name_prefix", "acctestmaster"+strconv.Itoa(ri)),
 					resource.TestCheckResourceAttr(tfResourceName, "master_profile.0.fqdn", "acctestmaster"+strconv.Itoa(ri)+"."+location+".cloudapp.azure.com"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.name", "agentpool1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "name", "agentpool1"),
 				),
 			},
 		},
@@ -68,8 +69,8 @@ func TestAccACSEngineK8sCluster_createMultipleAgentPools(t *testing.T) {
 					resource.TestCheckResourceAttr(tfResourceName, "linux_profile.0.admin_username", "acctestuser"+strconv.Itoa(ri)),
 					resource.TestCheckResourceAttr(tfResourceName, "master_profile.0.dns_name_prefix", "acctestmaster"+strconv.Itoa(ri)),
 					resource.TestCheckResourceAttr(tfResourceName, "master_profile.0.fqdn", "acctestmaster"+strconv.Itoa(ri)+"."+location+".cloudapp.azure.com"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.name", "agentpool1"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.1.name", "agentpool2"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "name", "agentpool1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool2", "name", "agentpool2"),
 				),
 			},
 		},
@@ -106,9 +107,9 @@ func TestAccACSEngineK8sCluster_createVersion10AndAbove(t *testing.T) {
 					resource.TestCheckResourceAttr(tfResourceName, "master_profile.0.dns_name_prefix", "acctestmaster"+strconv.Itoa(ri)),
 					resource.TestCheckResourceAttr(tfResourceName, "master_profile.0.vm_size", vmSize),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", version),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.name", "agentpool1"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "1"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.vm_size", vmSize),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "name", "agentpool1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "vm_size", vmSize),
 				),
 			},
 		},
@@ -135,14 +136,14 @@ func TestAccACSEngineK8sCluster_scaleUp(t *testing.T) {
 				Config: config,
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "1"),
 				),
 			},
 			{
 				Config: updatedConfig,
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "2"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "2"),
 				),
 			},
 		},
@@ -170,14 +171,14 @@ func TestAccACSEngineK8sCluster_scaleDown(t *testing.T) {
 				Config: config,
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "2"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "2"),
 				),
 			},
 			{
 				Config: updatedConfig,
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "1"),
 				),
 			},
 		},
@@ -205,21 +206,21 @@ func TestAccACSEngineK8sCluster_scaleUpDown(t *testing.T) {
 				Config: config,
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "1"),
 				),
 			},
 			{
 				Config: scaledUpConfig,
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "2"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "2"),
 				),
 			},
 			{
 				Config: config,
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "1"),
 				),
 			},
 		},
@@ -247,21 +248,21 @@ func TestAccACSEngineK8sCluster_scaleDownUp(t *testing.T) {
 				Config: config,
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "2"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "2"),
 				),
 			},
 			{
 				Config: scaledDownConfig,
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "1"),
 				),
 			},
 			{
 				Config: config,
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "2"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "2"),
 				),
 			},
 		},
@@ -371,7 +372,7 @@ func TestAccACSEngineK8sCluster_upgradeVersion10AndAbove(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.10.0"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "1"),
 				),
 			},
 			{
@@ -379,7 +380,7 @@ func TestAccACSEngineK8sCluster_upgradeVersion10AndAbove(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.10.1"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "1"),
 				),
 			},
 		},
@@ -409,7 +410,7 @@ func TestAccACSEngineK8sCluster_updateUpgradeScaleUp(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.8.13"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "1"),
 				),
 			},
 			{
@@ -417,7 +418,7 @@ func TestAccACSEngineK8sCluster_updateUpgradeScaleUp(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.9.8"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "1"),
 				),
 			},
 			{
@@ -425,7 +426,7 @@ func TestAccACSEngineK8sCluster_updateUpgradeScaleUp(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.9.8"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "2"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "2"),
 				),
 			},
 		},
@@ -455,7 +456,7 @@ func TestAccACSEngineK8sCluster_updateScaleUpUpgrade(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.8.13"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "1"),
 				),
 			},
 			{
@@ -463,7 +464,7 @@ func TestAccACSEngineK8sCluster_updateScaleUpUpgrade(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.8.13"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "2"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "2"),
 				),
 			},
 			{
@@ -471,7 +472,7 @@ func TestAccACSEngineK8sCluster_updateScaleUpUpgrade(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.9.8"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "2"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "2"),
 				),
 			},
 		},
@@ -501,7 +502,7 @@ func TestAccACSEngineK8sCluster_updateUpgradeScaleDown(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.8.13"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "2"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "2"),
 				),
 			},
 			{
@@ -509,7 +510,7 @@ func TestAccACSEngineK8sCluster_updateUpgradeScaleDown(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.9.8"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "2"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "2"),
 				),
 			},
 			{
@@ -517,7 +518,7 @@ func TestAccACSEngineK8sCluster_updateUpgradeScaleDown(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.9.8"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "1"),
 				),
 			},
 		},
@@ -547,7 +548,7 @@ func TestAccACSEngineK8sCluster_updateScaleDownUpgrade(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.8.13"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "2"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "2"),
 				),
 			},
 			{
@@ -555,7 +556,7 @@ func TestAccACSEngineK8sCluster_updateScaleDownUpgrade(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.8.13"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "1"),
 				),
 			},
 			{
@@ -563,7 +564,7 @@ func TestAccACSEngineK8sCluster_updateScaleDownUpgrade(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.9.8"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "1"),
 				),
 			},
 		},
@@ -592,7 +593,7 @@ func TestAccACSEngineK8sCluster_updateScaleUpgradeInOne(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.8.13"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "1"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "1"),
 				),
 			},
 			{
@@ -600,7 +601,7 @@ func TestAccACSEngineK8sCluster_updateScaleUpgradeInOne(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testCheckACSEngineClusterExists(tfResourceName),
 					resource.TestCheckResourceAttr(tfResourceName, "kubernetes_version", "1.9.8"),
-					resource.TestCheckResourceAttr(tfResourceName, "agent_pool_profiles.0.count", "2"),
+					testCheckACSEngineClusterAgentPoolAttr(tfResourceName, "agentpool1", "count", "2"),
 				),
 			},
 		},
@@ -1029,3 +1030,24 @@ func primaryInstanceState(s *terraform.State, name string) (*terraform.InstanceS
 	}
 	return is, nil
 }
+
+// agent pools are a set, so pool attributes are found by the name of the pool
+func testCheckACSEngineClusterAgentPoolAttr(name, poolName, key, value string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		is, err := primaryInstanceState(s, name)
+		if err != nil {
+			return err
+		}
+		for k, v := range is.Attributes {
+			if !strings.HasPrefix(k, "agent_pool_profiles.") || !strings.HasSuffix(k, ".name") || v != poolName {
+				continue
+			}
+			attr := strings.TrimSuffix(k, "name") + key
+			if is.Attributes[attr] != value {
+				return fmt.Errorf("%s: Attribute '%s' expected %#v, got %#v", name, attr, value, is.Attributes[attr])
+			}
+			return nil
+		}
+		return fmt.Errorf("%s: agent pool %q not found", name, poolName)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-05-01/resources"
+	"github.com/Azure/terraform-provider-acsengine/internal/response"
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
@@ -93,3 +94,36 @@ func createClusterResourceGroup(d *resourceData, client *ArmClient) error {
 
 	return nil
 }
+
+// an externally managed resource group has to exist in the cluster's location, and is never created or modified
+func verifyClusterResourceGroup(d *resourceData, client *ArmClient) error {
+	name := d.Get("resource_group").(string)
+	location := azureRMNormalizeLocation(d.Get("location").(string))
+
+	group, err := client.resourceGroupsClient.Get(client.StopContext, name)
+	if err != nil {
+		if response.WasNotFound(group.Response.Response) {
+			return fmt.Errorf("resource group %q doesn't exist, and it isn't created since `manage_resource_group` is false", name)
+		}
+		return fmt.Errorf("Error retrieving resource group: %+v", err)
+	}
+	if group.Location == nil || azureRMNormalizeLocation(*group.Location) != location {
+		return fmt.Errorf("resource group %q isn't in the cluster's location %q", name, location)
+	}
+
+	return nil
+}
+
+// switching between a managed and an external resource group in place would only change who the provider thinks
+// owns the group, and recreating the cluster would delete a managed group before the new cluster expects it to exist
+func customizeDiffManageResourceGroup(d *schema.ResourceDiff) error {
+	if d.Id() == "" || !d.HasChange("manage_resource_group") {
+		return nil
+	}
+	old, new := d.GetChange("manage_resource_group")
+	return fmt.Errorf("`manage_resource_group` can't be changed from %t to %t on an existing cluster", old.(bool), new.(bool))
+}
+
+func (d *resourceData) managesResourceGroup() bool {
+	return d.Get("manage_resource_group").(bool)
+}
@@ -92,3 +92,24 @@ func TestResourceGroupNameDiffSuppress(t *testing.T) {
 	}
 
 }
+
+func TestCustomizeDiffManageResourceGroup(t *testing.T) {
+	raw := mockClusterRawConfig("cluster", "southcentralus", "rg", "dnsprefix")
+	state, err := applyCluster(raw, base64Encode(`{"properties": {"agentPoolProfiles": [{"name": "agentpool1"}]}}`))
+	if err != nil {
+		t.Fatalf("failed to create cluster state: %+v", err)
+	}
+
+	raw["manage_resource_group"] = true
+	if _, err = diffClusterState(state, raw); err != nil {
+		t.Fatalf("diff failed: %+v", err)
+	}
+
+	raw["manage_resource_group"] = false
+	_, err = diffClusterState(state, raw)
+	assert.NotNil(t, err, "changing manage_resource_group on an existing cluster should fail")
+
+	if _, err = diffCluster(raw); err != nil {
+		t.Fatalf("a new cluster should accept an external resource group: %+v", err)
+	}
+}
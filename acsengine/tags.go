@@ -118,11 +118,13 @@ func (d *resourceData) setTags(tagMap map[string]string) error {
 	return nil
 }
 
-// only updates resource group tags
+// only updates resource group tags, and leaves an externally managed resource group alone
 // I don't like that this function depends on containerservice.go and that file depends on tags.go
 func updateResourceGroupTags(d *resourceData, c *ArmClient) error {
-	if err := createClusterResourceGroup(d, c); err != nil { // this should update... let's see if it works
-		return fmt.Errorf("failed to update resource group: %+v", err)
+	if d.managesResourceGroup() {
+		if err := createClusterResourceGroup(d, c); err != nil { // this should update... let's see if it works
+			return fmt.Errorf("failed to update resource group: %+v", err)
+		}
 	}
 
 	tags := d.getTags()
@@ -137,3 +139,31 @@ func updateResourceGroupTags(d *resourceData, c *ArmClient) error {
 
 	return cluster.saveTemplates(d, deploymentDirectory)
 }
+
+// returns a template transformer that adds the tags to every resource in the template, keeping the tags
+// acs-engine sets
+func resourceTagsTransformer(tags map[string]string) func(template map[string]interface{}) error {
+	return func(template map[string]interface{}) error {
+		resources, ok := template["resources"].([]interface{})
+		if !ok {
+			return fmt.Errorf("template resources not found")
+		}
+		for _, r := range resources {
+			resource, ok := r.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			resourceTags, ok := resource["tags"].(map[string]interface{})
+			if !ok {
+				resourceTags = map[string]interface{}{}
+				resource["tags"] = resourceTags
+			}
+			for name, value := range tags {
+				if _, ok := resourceTags[name]; !ok {
+					resourceTags[name] = value
+				}
+			}
+		}
+		return nil
+	}
+}
@@ -188,6 +188,35 @@ func TestSetTags(t *testing.T) {
 	}
 }
 
+func TestResourceTagsTransformer(t *testing.T) {
+	template := map[string]interface{}{
+		"resources": []interface{}{
+			map[string]interface{}{
+				"type": "Microsoft.Compute/virtualMachines",
+				"tags": map[string]interface{}{"poolName": "master"},
+			},
+			map[string]interface{}{
+				"type": "Microsoft.Network/loadBalancers",
+			},
+		},
+	}
+
+	transform := resourceTagsTransformer(map[string]string{"costCenter": "1234", "poolName": "other"})
+	if err := transform(template); err != nil {
+		t.Fatalf("resourceTagsTransformer failed: %+v", err)
+	}
+
+	resources := template["resources"].([]interface{})
+	vmTags := resources[0].(map[string]interface{})["tags"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"poolName": "master", "costCenter": "1234"}, vmTags, "tags set by acs-engine should be kept")
+	lbTags := resources[1].(map[string]interface{})["tags"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"costCenter": "1234", "poolName": "other"}, lbTags)
+
+	if err := transform(map[string]interface{}{}); err == nil {
+		t.Fatalf("resourceTagsTransformer should have failed without resources")
+	}
+}
+
 func testCheckACSEngineClusterTagsExists(name string) resource.TestCheckFunc {
 	return func(s *terraform.State) error {
 		is, err := primaryInstanceState(s, name)
@@ -0,0 +1,91 @@
+package acsengine
+
+import (
+	"fmt"
+
+	"github.com/Azure/terraform-provider-acsengine/internal/operations"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+)
+
+// an update strategy limits how far an agent pool's node count can go above or below its count while its nodes
+// are replaced, and how many nodes are drained at once. By default one node is surged into the pool before an
+// old node is removed
+const (
+	defaultMaxSurge       = 1
+	defaultMaxUnavailable = 0
+)
+
+func updateStrategySchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"max_surge": {
+					Type:         schema.TypeInt,
+					Optional:     true,
+					Default:      defaultMaxSurge,
+					ValidateFunc: validation.IntAtLeast(0),
+				},
+				"max_unavailable": {
+					Type:         schema.TypeInt,
+					Optional:     true,
+					Default:      defaultMaxUnavailable,
+					ValidateFunc: validation.IntAtLeast(0),
+				},
+			},
+		},
+	}
+}
+
+// returns the maximum number of nodes added above an agent pool's count and removed below it at a time
+func (d *resourceData) getUpdateStrategy() (int, int) {
+	v, ok := d.GetOk("update_strategy")
+	if !ok {
+		return defaultMaxSurge, defaultMaxUnavailable
+	}
+	strategies := v.([]interface{})
+	if len(strategies) == 0 || strategies[0] == nil {
+		return defaultMaxSurge, defaultMaxUnavailable
+	}
+	config := strategies[0].(map[string]interface{})
+
+	return config["max_surge"].(int), config["max_unavailable"].(int)
+}
+
+func (d *resourceData) setUpdateStrategy(sc *operations.ScaleClient) {
+	sc.MaxSurge, sc.MaxUnavailable = d.getUpdateStrategy()
+}
+
+// returns how many up-to-date nodes to add, or otherwise how many outdated nodes to remove, to make progress
+// replacing an agent pool's nodes without going more than maxSurge above or maxUnavailable below its count
+func replacementBatch(current, upToDate, desired, maxSurge, maxUnavailable int) (int, int, error) {
+	if maxSurge+maxUnavailable < 1 {
+		return 0, 0, fmt.Errorf("max surge and max unavailable can't both be 0")
+	}
+	if add := minInt(desired-upToDate, desired+maxSurge-current); add > 0 {
+		return add, 0, nil
+	}
+	return 0, minInt(current-upToDate, current-desired+maxUnavailable), nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// nodes can't be replaced if the pool's node count can go neither above nor below its count
+func customizeDiffUpdateStrategy(d *schema.ResourceDiff) error {
+	if _, ok := d.GetOk("update_strategy"); !ok {
+		return nil
+	}
+	maxSurge, maxUnavailable := d.Get("update_strategy.0.max_surge").(int), d.Get("update_strategy.0.max_unavailable").(int)
+	if maxSurge+maxUnavailable < 1 {
+		return fmt.Errorf("`max_surge` and `max_unavailable` in `update_strategy` can't both be 0")
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package acsengine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplacementBatch(t *testing.T) {
+	cases := []struct {
+		Current        int
+		UpToDate       int
+		Desired        int
+		MaxSurge       int
+		MaxUnavailable int
+		ExpectedAdd    int
+		ExpectedRemove int
+	}{
+		// surges one node before removing one, never going below the desired count
+		{Current: 3, UpToDate: 0, Desired: 3, MaxSurge: 1, MaxUnavailable: 0, ExpectedAdd: 1},
+		{Current: 4, UpToDate: 1, Desired: 3, MaxSurge: 1, MaxUnavailable: 0, ExpectedRemove: 1},
+		{Current: 4, UpToDate: 3, Desired: 3, MaxSurge: 1, MaxUnavailable: 0, ExpectedRemove: 1},
+		// surges as many nodes as allowed
+		{Current: 3, UpToDate: 0, Desired: 3, MaxSurge: 2, MaxUnavailable: 0, ExpectedAdd: 2},
+		{Current: 3, UpToDate: 0, Desired: 3, MaxSurge: 5, MaxUnavailable: 0, ExpectedAdd: 3},
+		{Current: 6, UpToDate: 3, Desired: 3, MaxSurge: 5, MaxUnavailable: 0, ExpectedRemove: 3},
+		// removes nodes first without surge
+		{Current: 3, UpToDate: 0, Desired: 3, MaxSurge: 0, MaxUnavailable: 1, ExpectedRemove: 1},
+		{Current: 2, UpToDate: 0, Desired: 3, MaxSurge: 0, MaxUnavailable: 1, ExpectedAdd: 1},
+		{Current: 3, UpToDate: 1, Desired: 3, MaxSurge: 0, MaxUnavailable: 2, ExpectedRemove: 2},
+		// surges and removes
+		{Current: 4, UpToDate: 1, Desired: 3, MaxSurge: 1, MaxUnavailable: 1, ExpectedRemove: 2},
+	}
+
+	for _, tc := range cases {
+		add, remove, err := replacementBatch(tc.Current, tc.UpToDate, tc.Desired, tc.MaxSurge, tc.MaxUnavailable)
+		if err != nil {
+			t.Fatalf("replacementBatch failed: %+v", err)
+		}
+		assert.Equal(t, tc.ExpectedAdd, add, "nodes to add for %+v", tc)
+		assert.Equal(t, tc.ExpectedRemove, remove, "nodes to remove for %+v", tc)
+	}
+
+	if _, _, err := replacementBatch(3, 0, 3, 0, 0); err == nil {
+		t.Fatalf("replacementBatch should have failed without surge or unavailable nodes")
+	}
+}
+
+func TestGetUpdateStrategy(t *testing.T) {
+	d := mockClusterResourceData("name", "southcentralus", "rg", "prefix")
+
+	maxSurge, maxUnavailable := d.getUpdateStrategy()
+	assert.Equal(t, defaultMaxSurge, maxSurge)
+	assert.Equal(t, defaultMaxUnavailable, maxUnavailable)
+
+	d.Set("update_strategy", []interface{}{
+		map[string]interface{}{"max_surge": 3, "max_unavailable": 2},
+	})
+	maxSurge, maxUnavailable = d.getUpdateStrategy()
+	assert.Equal(t, 3, maxSurge)
+	assert.Equal(t, 2, maxUnavailable)
+}
+
+func TestCustomizeDiffUpdateStrategy(t *testing.T) {
+	raw := mockClusterRawConfig("cluster", "southcentralus", "rg", "dnsprefix")
+	state, err := applyCluster(raw, base64Encode(`{"properties": {"agentPoolProfiles": [{"name": "agentpool1"}]}}`))
+	if err != nil {
+		t.Fatalf("failed to create cluster state: %+v", err)
+	}
+
+	raw["update_strategy"] = []interface{}{
+		map[string]interface{}{"max_surge": 0, "max_unavailable": 1},
+	}
+	if _, err = diffClusterState(state, raw); err != nil {
+		t.Fatalf("diff failed: %+v", err)
+	}
+
+	raw["update_strategy"] = []interface{}{
+		map[string]interface{}{"max_surge": 0, "max_unavailable": 0},
+	}
+	_, err = diffClusterState(state, raw)
+	assert.NotNil(t, err, "an update strategy that can't replace nodes should fail")
+}
@@ -25,3 +25,16 @@ func validateAgentPoolProfileCount(v interface{}, k string) (ws []string, errors
 	}
 	return
 }
+
+func validateAgentPoolAutoScaling(name string, count, minCount, maxCount int) error {
+	if minCount < 1 || maxCount < 1 {
+		return fmt.Errorf("agent pool %q must set `min_count` and `max_count` when auto scaling is enabled", name)
+	}
+	if minCount > maxCount {
+		return fmt.Errorf("agent pool %q `min_count` (%d) cannot be greater than `max_count` (%d)", name, minCount, maxCount)
+	}
+	if count < minCount || count > maxCount {
+		return fmt.Errorf("agent pool %q `count` (%d) must be between `min_count` (%d) and `max_count` (%d)", name, count, minCount, maxCount)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	clusterAutoscalerName = "cluster-autoscaler"
+	nodesArgumentPrefix   = "--nodes="
+)
+
+// SetClusterAutoscalerNodeRange updates the minimum and maximum node counts used by the cluster-autoscaler addon
+func SetClusterAutoscalerNodeRange(kubeConfig string, minCount, maxCount int) error {
+	config, err := clientcmd.BuildConfigFromKubeconfigGetter("", func() (*clientcmdapi.Config, error) { return clientcmd.Load([]byte(kubeConfig)) })
+	if err != nil {
+		return fmt.Errorf("error building client config: %+v", err)
+	}
+	client, err := clientset.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("error creating Kubernetes client: %+v", err)
+	}
+
+	deployments := client.ExtensionsV1beta1().Deployments(metav1.NamespaceSystem)
+	deployment, err := deployments.Get(clusterAutoscalerName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting %s deployment: %+v", clusterAutoscalerName, err)
+	}
+
+	containers := deployment.Spec.Template.Spec.Containers
+	found := false
+	for i := range containers {
+		if containers[i].Name != clusterAutoscalerName {
+			continue
+		}
+		if containers[i].Command, err = SetNodesArgument(containers[i].Command, minCount, maxCount); err != nil {
+			return fmt.Errorf("error setting node range: %+v", err)
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("container %s not found in %s deployment", clusterAutoscalerName, clusterAutoscalerName)
+	}
+
+	if _, err = deployments.Update(deployment); err != nil {
+		return fmt.Errorf("error updating %s deployment: %+v", clusterAutoscalerName, err)
+	}
+
+	return nil
+}
+
+// SetNodesArgument replaces the min and max counts of the cluster-autoscaler `--nodes=min:max:vmss` argument
+func SetNodesArgument(command []string, minCount, maxCount int) ([]string, error) {
+	for i, arg := range command {
+		if !strings.HasPrefix(arg, nodesArgumentPrefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(arg, nodesArgumentPrefix), ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("argument %q is not formatted as min:max:name", arg)
+		}
+		command[i] = fmt.Sprintf("%s%d:%d:%s", nodesArgumentPrefix, minCount, maxCount, parts[2])
+		return command, nil
+	}
+
+	return nil, fmt.Errorf("%s argument not found", strings.TrimSuffix(nodesArgumentPrefix, "="))
+}
@@ -0,0 +1,40 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetNodesArgument(t *testing.T) {
+	cases := []struct {
+		Command     []string
+		Expected    string
+		ExpectError bool
+	}{
+		{
+			Command:  []string{"./cluster-autoscaler", "--v=3", "--nodes=1:5:k8s-agentpool1-12345678-vmss"},
+			Expected: "--nodes=2:10:k8s-agentpool1-12345678-vmss",
+		},
+		{
+			Command:     []string{"./cluster-autoscaler", "--v=3"},
+			ExpectError: true,
+		},
+		{
+			Command:     []string{"./cluster-autoscaler", "--nodes=1:5"},
+			ExpectError: true,
+		},
+	}
+
+	for _, tc := range cases {
+		command, err := SetNodesArgument(tc.Command, 2, 10)
+		if tc.ExpectError {
+			assert.NotNil(t, err, "expected an error for command %v", tc.Command)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("SetNodesArgument failed: %+v", err)
+		}
+		assert.Equal(t, tc.Expected, command[len(command)-1], "nodes argument was not set correctly")
+	}
+}
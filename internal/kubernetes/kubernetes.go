@@ -2,30 +2,127 @@ package kubernetes
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/blang/semver"
 )
 
 // ValidateKubernetesVersionUpgrade checks if a version is one of the allowed upgrade versions given current version
 func ValidateKubernetesVersionUpgrade(newVersion string, currentVersion string) error {
+	upgrades, err := upgradeVersions(currentVersion)
+	if err != nil {
+		return err
+	}
+	for _, version := range upgrades { // checking that version I want is within the allowed versions
+		if version == newVersion {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("version %s is not supported (either doesn't exist, is a downgrade or same version, or is an upgrade by more than 1 minor version)", newVersion)
+}
+
+// UpgradePath returns the shortest list of supported upgrades from the current version to the new version,
+// preferring the newest release of each intermediate minor version
+func UpgradePath(currentVersion string, newVersion string) ([]string, error) {
+	if currentVersion == newVersion {
+		return []string{}, nil
+	}
+
+	previous := map[string]string{currentVersion: ""}
+	queue := []string{currentVersion}
+	for len(queue) > 0 {
+		version := queue[0]
+		queue = queue[1:]
+
+		upgrades, err := upgradeVersions(version)
+		if err != nil {
+			return nil, err
+		}
+		for _, upgrade := range upgrades {
+			if _, ok := previous[upgrade]; ok {
+				continue
+			}
+			previous[upgrade] = version
+			if upgrade == newVersion {
+				path := []string{}
+				for v := newVersion; v != currentVersion; v = previous[v] {
+					path = append([]string{v}, path...)
+				}
+				return path, nil
+			}
+			if v, err := semver.Make(upgrade); err == nil && len(v.Pre) == 0 {
+				queue = append(queue, upgrade)
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("version %s is not supported or can't be reached from version %s by upgrading (it may not exist or be a downgrade)", newVersion, currentVersion)
+}
+
+// returns the versions a version can be upgraded to, newest first
+func upgradeVersions(version string) ([]string, error) {
 	kubernetesProfile := api.OrchestratorProfile{
 		OrchestratorType:    "Kubernetes",
-		OrchestratorVersion: currentVersion,
+		OrchestratorVersion: version,
 	}
 	kubernetesInfo, err := api.GetOrchestratorVersionProfile(&kubernetesProfile)
 	if err != nil {
-		return fmt.Errorf("error getting a list of the available upgrades: %+v", err)
+		return nil, fmt.Errorf("error getting a list of the available upgrades for version %s: %+v", version, err)
 	}
-	found := false
-	for _, up := range kubernetesInfo.Upgrades { // checking that version I want is within the allowed versions
-		if up.OrchestratorVersion == newVersion {
-			found = true
-			break
+
+	upgrades := []semver.Version{}
+	for _, up := range kubernetesInfo.Upgrades {
+		v, err := semver.Make(up.OrchestratorVersion)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing version %q: %+v", up.OrchestratorVersion, err)
 		}
+		upgrades = append(upgrades, v)
+	}
+	sort.Sort(sort.Reverse(semver.Versions(upgrades)))
+
+	versions := []string{}
+	for _, v := range upgrades {
+		versions = append(versions, v.String())
+	}
+	return versions, nil
+}
+
+// ValidateAgentPoolVersion checks that an agent pool version is no newer than the control plane version and
+// lags it by at most one minor version
+func ValidateAgentPoolVersion(poolVersion string, controlPlaneVersion string) error {
+	pool, err := semver.Make(poolVersion)
+	if err != nil {
+		return fmt.Errorf("error parsing agent pool version %q: %+v", poolVersion, err)
+	}
+	controlPlane, err := semver.Make(controlPlaneVersion)
+	if err != nil {
+		return fmt.Errorf("error parsing control plane version %q: %+v", controlPlaneVersion, err)
+	}
+
+	if pool.GT(controlPlane) {
+		return fmt.Errorf("agent pool version %s is newer than the control plane version %s", poolVersion, controlPlaneVersion)
 	}
-	if !found {
-		return fmt.Errorf("version %s is not supported (either doesn't exist, is a downgrade or same version, or is an upgrade by more than 1 minor version)", newVersion)
+	if pool.Major != controlPlane.Major || controlPlane.Minor-pool.Minor > 1 {
+		return fmt.Errorf("agent pool version %s lags the control plane version %s by more than 1 minor version", poolVersion, controlPlaneVersion)
 	}
 
 	return nil
 }
+
+// OldestVersion returns the oldest of the versions, or fallback if none of them are valid versions
+func OldestVersion(versions []string, fallback string) string {
+	oldest := fallback
+	var oldestVersion *semver.Version
+	for _, version := range versions {
+		v, err := semver.Make(version)
+		if err != nil {
+			continue
+		}
+		if oldestVersion == nil || v.LT(*oldestVersion) {
+			oldest, oldestVersion = version, &v
+		}
+	}
+	return oldest
+}
@@ -1,6 +1,9 @@
 package kubernetes
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestValidateKubernetesVersionUpgrade(t *testing.T) {
 	cases := []struct {
@@ -31,3 +34,79 @@ func TestValidateKubernetesVersionUpgrade(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateAgentPoolVersion(t *testing.T) {
+	cases := []struct {
+		PoolVersion         string
+		ControlPlaneVersion string
+		ExpectError         bool
+	}{
+		{PoolVersion: "1.10.3", ControlPlaneVersion: "1.10.3", ExpectError: false},
+		{PoolVersion: "1.10.3", ControlPlaneVersion: "1.10.5", ExpectError: false},
+		{PoolVersion: "1.9.8", ControlPlaneVersion: "1.10.3", ExpectError: false},
+		{PoolVersion: "1.8.2", ControlPlaneVersion: "1.10.3", ExpectError: true},
+		{PoolVersion: "1.10.5", ControlPlaneVersion: "1.10.3", ExpectError: true},
+		{PoolVersion: "1.11.0", ControlPlaneVersion: "1.10.3", ExpectError: true},
+		{PoolVersion: "latest", ControlPlaneVersion: "1.10.3", ExpectError: true},
+	}
+
+	for _, tc := range cases {
+		err := ValidateAgentPoolVersion(tc.PoolVersion, tc.ControlPlaneVersion)
+		if tc.ExpectError && err == nil {
+			t.Fatalf("Expected the agent pool version validator to trigger an error for pool version = '%s', control plane version = '%s'", tc.PoolVersion, tc.ControlPlaneVersion)
+		} else if !tc.ExpectError && err != nil {
+			t.Fatalf("Expected the agent pool version validator to not trigger an error for pool version = '%s', control plane version = '%s'. Instead got %+v", tc.PoolVersion, tc.ControlPlaneVersion, err)
+		}
+	}
+}
+
+func TestUpgradePath(t *testing.T) {
+	cases := []struct {
+		CurrentVersion string
+		NewVersion     string
+		Expected       []string
+		ExpectError    bool
+	}{
+		{CurrentVersion: "1.8.13", NewVersion: "1.8.13", Expected: []string{}},
+		{CurrentVersion: "1.8.13", NewVersion: "1.8.15", Expected: []string{"1.8.15"}},
+		{CurrentVersion: "1.8.13", NewVersion: "1.9.8", Expected: []string{"1.9.8"}},
+		{CurrentVersion: "1.8.13", NewVersion: "1.10.3", Expected: []string{"1.9.10", "1.10.3"}},
+		{CurrentVersion: "1.8.13", NewVersion: "1.11.2", Expected: []string{"1.9.10", "1.10.6", "1.11.2"}},
+		{CurrentVersion: "1.9.8", NewVersion: "1.8.13", ExpectError: true},
+		{CurrentVersion: "1.9.8", NewVersion: "1.99.0", ExpectError: true},
+	}
+
+	for _, tc := range cases {
+		path, err := UpgradePath(tc.CurrentVersion, tc.NewVersion)
+		if tc.ExpectError {
+			if err == nil {
+				t.Fatalf("Expected an error upgrading from %s to %s", tc.CurrentVersion, tc.NewVersion)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("UpgradePath failed for %s to %s: %+v", tc.CurrentVersion, tc.NewVersion, err)
+		}
+		if !reflect.DeepEqual(tc.Expected, path) {
+			t.Fatalf("Expected upgrade path %v from %s to %s, got %v", tc.Expected, tc.CurrentVersion, tc.NewVersion, path)
+		}
+	}
+}
+
+func TestOldestVersion(t *testing.T) {
+	cases := []struct {
+		Versions []string
+		Fallback string
+		Expected string
+	}{
+		{Versions: []string{"1.10.3", "1.9.10", "1.10.6"}, Fallback: "1.11.2", Expected: "1.9.10"},
+		{Versions: []string{"1.10.3", "not a version"}, Fallback: "1.9.10", Expected: "1.10.3"},
+		{Versions: []string{}, Fallback: "1.9.10", Expected: "1.9.10"},
+	}
+
+	for _, tc := range cases {
+		if oldest := OldestVersion(tc.Versions, tc.Fallback); oldest != tc.Expected {
+			t.Fatalf("Expected oldest version %s of %v, got %s", tc.Expected, tc.Versions, oldest)
+		}
+	}
+}
@@ -4,12 +4,17 @@ import (
 	"context"
 	"fmt"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/Azure/acs-engine/pkg/acsengine"
 	"github.com/Azure/acs-engine/pkg/api"
 	"github.com/Azure/acs-engine/pkg/armhelpers"
 	"github.com/Azure/acs-engine/pkg/helpers"
 	"github.com/Azure/acs-engine/pkg/i18n"
+	"github.com/Azure/acs-engine/pkg/operations/kubernetesupgrade"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-05-01/resources"
 	"github.com/Azure/terraform-provider-acsengine/internal/resource"
 	"github.com/leonelquinteros/gotext"
 	log "github.com/sirupsen/logrus"
@@ -29,18 +34,23 @@ type ACSEngineClient struct {
 	Locale              *gotext.Locale
 	NameSuffix          string
 	Logger              *log.Entry
+
+	// ManageResourceGroup is false when the resource group is managed outside of the cluster, so that it's never
+	// created or updated
+	ManageResourceGroup bool
 }
 
 // NewACSEngineClient returns a new acs-engine cluster client
 func NewACSEngineClient(secret string) *ACSEngineClient {
 	authArgs := NewAuthArgs(secret)
 	return &ACSEngineClient{
-		AuthArgs: *authArgs,
+		AuthArgs:            *authArgs,
+		ManageResourceGroup: true,
 	}
 }
 
 // AddACSEngineClientAuthArgs adds auth args and sets up client
-func (c *ACSEngineClient) AddACSEngineClientAuthArgs(cluster *api.ContainerService, azureID string) error {
+func (c *ACSEngineClient) AddACSEngineClientAuthArgs(ctx context.Context, cluster *api.ContainerService, azureID string) error {
 	var err error
 	if err = c.AddAuthArgs(cluster, azureID); err != nil {
 		return fmt.Errorf("failed to add auth args: %+v", err)
@@ -49,7 +59,10 @@ func (c *ACSEngineClient) AddACSEngineClientAuthArgs(cluster *api.ContainerServi
 	if c.Client, err = c.GetClient(); err != nil {
 		return fmt.Errorf("failed to get client: %+v", err)
 	}
-	if _, err = c.Client.EnsureResourceGroup(context.Background(), c.ResourceGroupName, c.Location, nil); err != nil {
+	if !c.ManageResourceGroup {
+		return nil
+	}
+	if _, err = c.Client.EnsureResourceGroup(ctx, c.ResourceGroupName, c.Location, nil); err != nil {
 		return fmt.Errorf("failed to get client: %+v", err)
 	}
 
@@ -57,7 +70,7 @@ func (c *ACSEngineClient) AddACSEngineClientAuthArgs(cluster *api.ContainerServi
 }
 
 // SetACSEngineClient sets all necessary client fields for cluster
-func (c *ACSEngineClient) SetACSEngineClient(cluster *api.ContainerService, azureID string) error {
+func (c *ACSEngineClient) SetACSEngineClient(ctx context.Context, cluster *api.ContainerService, azureID string) error {
 	var err error
 
 	id, err := resource.ParseAzureResourceID(azureID)
@@ -72,7 +85,7 @@ func (c *ACSEngineClient) SetACSEngineClient(cluster *api.ContainerService, azur
 		return fmt.Errorf("error loading translation files: %+v", err)
 	}
 
-	if err = c.AddACSEngineClientAuthArgs(cluster, azureID); err != nil {
+	if err = c.AddACSEngineClientAuthArgs(ctx, cluster, azureID); err != nil {
 		return fmt.Errorf("failed to add ACSEngineClient auth args: %+v", err)
 	}
 
@@ -110,3 +123,292 @@ func (c *ACSEngineClient) Validate() error {
 
 	return nil
 }
+
+// ListMasterVMs returns the master VMs of the cluster
+func (c *ACSEngineClient) ListMasterVMs(ctx context.Context) ([]compute.VirtualMachine, error) {
+	masterVMs := []compute.VirtualMachine{}
+	vmListPage, err := c.Client.ListVirtualMachines(ctx, c.ResourceGroupName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VMs in resource group %q: %+v", c.ResourceGroupName, err)
+	}
+	for vmListPage.NotDone() {
+		for _, vm := range vmListPage.Values() {
+			if vm.Name == nil {
+				continue
+			}
+			if strings.HasPrefix(*vm.Name, kubernetesupgrade.MasterVMNamePrefix) && strings.Contains(*vm.Name, c.NameSuffix) {
+				masterVMs = append(masterVMs, vm)
+			}
+		}
+		if err = vmListPage.Next(); err != nil {
+			return nil, fmt.Errorf("failed to list VMs in resource group %q: %+v", c.ResourceGroupName, err)
+		}
+	}
+
+	return masterVMs, nil
+}
+
+// ListClusterVMs returns the cluster's master VMs and availability set agent VMs
+func (c *ACSEngineClient) ListClusterVMs(ctx context.Context) ([]compute.VirtualMachine, error) {
+	vms := []compute.VirtualMachine{}
+	vmListPage, err := c.Client.ListVirtualMachines(ctx, c.ResourceGroupName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VMs in resource group %q: %+v", c.ResourceGroupName, err)
+	}
+	for vmListPage.NotDone() {
+		for _, vm := range vmListPage.Values() {
+			if _, ok := ClusterPoolName(vm.Tags, c.NameSuffix); vm.Name != nil && ok {
+				vms = append(vms, vm)
+			}
+		}
+		if err = vmListPage.Next(); err != nil {
+			return nil, fmt.Errorf("failed to list VMs in resource group %q: %+v", c.ResourceGroupName, err)
+		}
+	}
+
+	return vms, nil
+}
+
+// MasterPoolName is the pool name acs-engine tags master VMs with
+const MasterPoolName = "master"
+
+// NodeVersions returns the Kubernetes versions of the cluster's VMs by lowercase pool name, read from the
+// orchestrator tag acs-engine updates as it upgrades each VM
+func (c *ACSEngineClient) NodeVersions(ctx context.Context) (map[string][]string, error) {
+	versions := map[string][]string{}
+	err := c.visitNodes(ctx, func(poolName string, tags map[string]*string) {
+		if version, ok := orchestratorVersion(tags); ok {
+			versions[poolName] = append(versions[poolName], version)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// NodeTags returns the value of a tag on each of the cluster's VMs, which is empty if the VM doesn't have the tag
+func (c *ACSEngineClient) NodeTags(ctx context.Context, tag string) ([]string, error) {
+	values := []string{}
+	err := c.visitNodes(ctx, func(poolName string, tags map[string]*string) {
+		value := ""
+		if v := tags[tag]; v != nil {
+			value = *v
+		}
+		values = append(values, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// calls visit with the lowercase pool name and tags of every VM in the cluster, including scale set VMs
+func (c *ACSEngineClient) visitNodes(ctx context.Context, visit func(poolName string, tags map[string]*string)) error {
+	vmssList, err := c.Client.ListVirtualMachineScaleSets(ctx, c.ResourceGroupName)
+	if err != nil {
+		return fmt.Errorf("failed to list scale sets in resource group %q: %+v", c.ResourceGroupName, err)
+	}
+	for vmssList.NotDone() {
+		for _, vmss := range vmssList.Values() {
+			poolName, ok := ClusterPoolName(vmss.Tags, c.NameSuffix)
+			if vmss.Name == nil || !ok {
+				continue
+			}
+			vmList, err := c.Client.ListVirtualMachineScaleSetVMs(ctx, c.ResourceGroupName, *vmss.Name)
+			if err != nil {
+				return fmt.Errorf("failed to list VMs in scale set %q: %+v", *vmss.Name, err)
+			}
+			for vmList.NotDone() {
+				for _, vm := range vmList.Values() {
+					visit(poolName, vm.Tags)
+				}
+				if err = vmList.Next(); err != nil {
+					return fmt.Errorf("failed to list VMs in scale set %q: %+v", *vmss.Name, err)
+				}
+			}
+		}
+		if err = vmssList.Next(); err != nil {
+			return fmt.Errorf("failed to list scale sets in resource group %q: %+v", c.ResourceGroupName, err)
+		}
+	}
+
+	vmList, err := c.Client.ListVirtualMachines(ctx, c.ResourceGroupName)
+	if err != nil {
+		return fmt.Errorf("failed to list VMs in resource group %q: %+v", c.ResourceGroupName, err)
+	}
+	for vmList.NotDone() {
+		for _, vm := range vmList.Values() {
+			if poolName, ok := ClusterPoolName(vm.Tags, c.NameSuffix); ok {
+				visit(poolName, vm.Tags)
+			}
+		}
+		if err = vmList.Next(); err != nil {
+			return fmt.Errorf("failed to list VMs in resource group %q: %+v", c.ResourceGroupName, err)
+		}
+	}
+
+	return nil
+}
+
+// ClusterPoolName returns the lowercase agent pool name from the tags acs-engine puts on the VMs and scale sets of
+// the cluster with the given name suffix. Windows nodes are tagged with the prefix of the suffix acs-engine puts in
+// their names instead of the whole suffix
+func ClusterPoolName(tags map[string]*string, nameSuffix string) (string, bool) {
+	poolName, suffix := tags["poolName"], tags["resourceNameSuffix"]
+	if poolName == nil || suffix == nil || nameSuffix == "" {
+		return "", false
+	}
+	if *suffix != nameSuffix && *suffix != WindowsResourceNamePrefix(nameSuffix) {
+		return "", false
+	}
+	return strings.ToLower(*poolName), true
+}
+
+// WindowsResourceNamePrefix returns the prefix of the cluster's name suffix that acs-engine uses in the names of
+// Windows nodes
+func WindowsResourceNamePrefix(nameSuffix string) string {
+	if len(nameSuffix) < windowsResourceNamePrefixLength {
+		return nameSuffix
+	}
+	return nameSuffix[:windowsResourceNamePrefixLength]
+}
+
+// acs-engine's winResourceNamePrefix template variable is the first 5 characters of the name suffix
+const windowsResourceNamePrefixLength = 5
+
+// returns the version from an orchestrator tag like "Kubernetes:1.10.3"
+func orchestratorVersion(tags map[string]*string) (string, bool) {
+	orchestrator := tags["orchestrator"]
+	if orchestrator == nil {
+		return "", false
+	}
+	parts := strings.Split(*orchestrator, ":")
+	if len(parts) != 2 || parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// defaultTimeout limits operations whose context has no deadline
+const defaultTimeout = 60 * time.Minute
+
+// TimeRemaining returns the time left before the context's deadline
+func TimeRemaining(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline)
+	}
+	return defaultTimeout
+}
+
+type contextClient struct {
+	armhelpers.ACSEngineClient
+
+	ctx context.Context
+}
+
+// NewContextClient returns a client that runs long running operations under ctx, since acs-engine
+// starts some of them, like upgrades and VM deletion, with a background context
+func NewContextClient(client armhelpers.ACSEngineClient, ctx context.Context) armhelpers.ACSEngineClient {
+	return &contextClient{
+		ACSEngineClient: client,
+		ctx:             ctx,
+	}
+}
+
+// DeployTemplate deploys a template under the client's context
+func (c *contextClient) DeployTemplate(_ context.Context, resourceGroup, name string, template, parameters map[string]interface{}) (resources.DeploymentExtended, error) {
+	return c.ACSEngineClient.DeployTemplate(c.ctx, resourceGroup, name, template, parameters)
+}
+
+// DeleteVirtualMachine deletes a VM under the client's context
+func (c *contextClient) DeleteVirtualMachine(_ context.Context, resourceGroup, name string) error {
+	return c.ACSEngineClient.DeleteVirtualMachine(c.ctx, resourceGroup, name)
+}
+
+// DeleteVirtualMachineScaleSetVM deletes a scale set VM under the client's context
+func (c *contextClient) DeleteVirtualMachineScaleSetVM(_ context.Context, resourceGroup, virtualMachineScaleSet, instanceID string) error {
+	return c.ACSEngineClient.DeleteVirtualMachineScaleSetVM(c.ctx, resourceGroup, virtualMachineScaleSet, instanceID)
+}
+
+// SetVirtualMachineScaleSetCapacity sets a scale set's capacity under the client's context
+func (c *contextClient) SetVirtualMachineScaleSetCapacity(_ context.Context, resourceGroup, virtualMachineScaleSet string, sku compute.Sku, location string) error {
+	return c.ACSEngineClient.SetVirtualMachineScaleSetCapacity(c.ctx, resourceGroup, virtualMachineScaleSet, sku, location)
+}
+
+// DeleteManagedDisk deletes a managed disk under the client's context
+func (c *contextClient) DeleteManagedDisk(_ context.Context, resourceGroupName string, diskName string) error {
+	return c.ACSEngineClient.DeleteManagedDisk(c.ctx, resourceGroupName, diskName)
+}
+
+// DeleteNetworkInterface deletes a NIC under the client's context
+func (c *contextClient) DeleteNetworkInterface(_ context.Context, resourceGroup, nicName string) error {
+	return c.ACSEngineClient.DeleteNetworkInterface(c.ctx, resourceGroup, nicName)
+}
+
+// TemplateTransformer modifies an ARM template before it is deployed
+type TemplateTransformer func(template map[string]interface{}) error
+
+type transformingClient struct {
+	armhelpers.ACSEngineClient
+
+	transform TemplateTransformer
+}
+
+// NewTransformingClient returns a client that applies transform to every template it deploys, including
+// the templates generated by acs-engine during upgrades
+func NewTransformingClient(client armhelpers.ACSEngineClient, transform TemplateTransformer) armhelpers.ACSEngineClient {
+	return &transformingClient{
+		ACSEngineClient: client,
+		transform:       transform,
+	}
+}
+
+// DeployTemplate transforms and deploys a template
+func (c *transformingClient) DeployTemplate(ctx context.Context, resourceGroup, name string, template, parameters map[string]interface{}) (resources.DeploymentExtended, error) {
+	if err := c.transform(template); err != nil {
+		return resources.DeploymentExtended{}, fmt.Errorf("error transforming template: %+v", err)
+	}
+	return c.ACSEngineClient.DeployTemplate(ctx, resourceGroup, name, template, parameters)
+}
+
+type agentPoolFilteringClient struct {
+	armhelpers.ACSEngineClient
+
+	agentPools map[string]bool
+}
+
+// NewAgentPoolFilteringClient returns a client that hides the scale set VMs of agent pools that aren't listed,
+// since the acs-engine upgrader upgrades every scale set VM it finds regardless of the pools to upgrade
+func NewAgentPoolFilteringClient(client armhelpers.ACSEngineClient, agentPools []string) armhelpers.ACSEngineClient {
+	c := &agentPoolFilteringClient{
+		ACSEngineClient: client,
+		agentPools:      map[string]bool{},
+	}
+	for _, name := range agentPools {
+		c.agentPools[strings.ToLower(name)] = true
+	}
+	return c
+}
+
+// ListVirtualMachineScaleSetVMs lists the VMs in a scale set, or none if the scale set belongs to a hidden agent pool
+func (c *agentPoolFilteringClient) ListVirtualMachineScaleSetVMs(ctx context.Context, resourceGroup, virtualMachineScaleSet string) (compute.VirtualMachineScaleSetVMListResultPage, error) {
+	page, err := c.ACSEngineClient.ListVirtualMachineScaleSetVMs(ctx, resourceGroup, virtualMachineScaleSet)
+	if err != nil {
+		return page, err
+	}
+	if !c.includesScaleSetVMs(page.Values()) {
+		return compute.VirtualMachineScaleSetVMListResultPage{}, nil
+	}
+	return page, nil
+}
+
+// a scale set only belongs to one agent pool, so any of its VMs identifies the pool
+func (c *agentPoolFilteringClient) includesScaleSetVMs(vms []compute.VirtualMachineScaleSetVM) bool {
+	for _, vm := range vms {
+		if poolName := vm.Tags["poolName"]; poolName != nil {
+			return c.agentPools[strings.ToLower(*poolName)]
+		}
+	}
+	return true
+}
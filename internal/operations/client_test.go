@@ -1,10 +1,15 @@
 package operations
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/Azure/acs-engine/pkg/armhelpers"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-05-01/resources"
 	"github.com/Azure/terraform-provider-acsengine/internal/tester"
 	"github.com/stretchr/testify/assert"
 )
@@ -17,7 +22,7 @@ func TestSetACSEngineClient(t *testing.T) {
 
 	c := NewACSEngineClient(os.Getenv("ARM_CLIENT_SECRET"))
 
-	if err := c.SetACSEngineClient(cluster, id); err != nil {
+	if err := c.SetACSEngineClient(context.Background(), cluster, id); err != nil {
 		t.Fatalf("initializeScaleClient failed: %+v", err)
 	}
 
@@ -31,7 +36,7 @@ func TestSetACSEngineClientBadID(t *testing.T) {
 
 	c := NewACSEngineClient(os.Getenv("ARM_CLIENT_SECRET"))
 
-	if err := c.SetACSEngineClient(cluster, ""); err == nil {
+	if err := c.SetACSEngineClient(context.Background(), cluster, ""); err == nil {
 		t.Fatalf("initializeScaleClient should have failed")
 	}
 }
@@ -94,3 +99,248 @@ func TestValidate(t *testing.T) {
 		}
 	}
 }
+
+func TestTransformingClientDeployTemplate(t *testing.T) {
+	transformed := false
+	client := NewTransformingClient(&armhelpers.MockACSEngineClient{}, func(template map[string]interface{}) error {
+		template["transformed"] = true
+		transformed = true
+		return nil
+	})
+
+	template := map[string]interface{}{}
+	if _, err := client.DeployTemplate(context.Background(), "rg", "deployment", template, map[string]interface{}{}); err != nil {
+		t.Fatalf("DeployTemplate failed: %+v", err)
+	}
+
+	assert.True(t, transformed, "template should have been transformed")
+	assert.Equal(t, true, template["transformed"])
+}
+
+func TestTransformingClientDeployTemplateTransformFails(t *testing.T) {
+	client := NewTransformingClient(&armhelpers.MockACSEngineClient{}, func(template map[string]interface{}) error {
+		return fmt.Errorf("transform failed")
+	})
+
+	if _, err := client.DeployTemplate(context.Background(), "rg", "deployment", map[string]interface{}{}, map[string]interface{}{}); err == nil {
+		t.Fatalf("DeployTemplate should have failed when the template transform fails")
+	}
+}
+
+func TestAgentPoolFilteringClientIncludesScaleSetVMs(t *testing.T) {
+	agentPool1, agentPool2 := "agentpool1", "AgentPool2"
+	client := NewAgentPoolFilteringClient(&armhelpers.MockACSEngineClient{}, []string{"agentpool2"}).(*agentPoolFilteringClient)
+
+	cases := []struct {
+		VMs      []compute.VirtualMachineScaleSetVM
+		Expected bool
+	}{
+		{
+			VMs:      []compute.VirtualMachineScaleSetVM{{Tags: map[string]*string{"poolName": &agentPool2}}},
+			Expected: true,
+		},
+		{
+			VMs:      []compute.VirtualMachineScaleSetVM{{Tags: map[string]*string{"poolName": &agentPool1}}},
+			Expected: false,
+		},
+		{
+			VMs:      []compute.VirtualMachineScaleSetVM{{}, {Tags: map[string]*string{"poolName": &agentPool1}}},
+			Expected: false,
+		},
+		{
+			VMs:      []compute.VirtualMachineScaleSetVM{},
+			Expected: true,
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.Expected, client.includesScaleSetVMs(tc.VMs))
+	}
+
+	if _, err := client.ListVirtualMachineScaleSetVMs(context.Background(), "rg", "k8s-agentpool1-12345678-vmss"); err != nil {
+		t.Fatalf("ListVirtualMachineScaleSetVMs failed: %+v", err)
+	}
+}
+
+func TestTimeRemaining(t *testing.T) {
+	assert.Equal(t, defaultTimeout, TimeRemaining(context.Background()), "a context without a deadline should use the default timeout")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	remaining := TimeRemaining(ctx)
+	assert.True(t, remaining > 9*time.Minute && remaining <= 10*time.Minute, "remaining time %s should be close to 10 minutes", remaining)
+}
+
+type contextRecordingClient struct {
+	armhelpers.MockACSEngineClient
+
+	ctx context.Context
+}
+
+func (mc *contextRecordingClient) DeployTemplate(ctx context.Context, resourceGroup, name string, template, parameters map[string]interface{}) (resources.DeploymentExtended, error) {
+	mc.ctx = ctx
+	return resources.DeploymentExtended{}, nil
+}
+
+func TestContextClientDeployTemplate(t *testing.T) {
+	type key string
+	ctx := context.WithValue(context.Background(), key("stop"), true)
+	recorder := &contextRecordingClient{}
+	client := NewContextClient(recorder, ctx)
+
+	if _, err := client.DeployTemplate(context.Background(), "rg", "deployment", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatalf("DeployTemplate failed: %+v", err)
+	}
+	assert.Equal(t, ctx, recorder.ctx, "the template should be deployed under the client's context")
+
+	if err := client.DeleteVirtualMachine(context.Background(), "rg", "vm"); err != nil {
+		t.Fatalf("DeleteVirtualMachine failed: %+v", err)
+	}
+}
+
+func TestListClusterVMs(t *testing.T) {
+	c := ACSEngineClient{
+		Client:            &armhelpers.MockACSEngineClient{},
+		ResourceGroupName: "rg",
+		NameSuffix:        "12345678",
+	}
+
+	vms, err := c.ListClusterVMs(context.Background())
+	if err != nil {
+		t.Fatalf("ListClusterVMs failed: %+v", err)
+	}
+	if assert.Len(t, vms, 1) {
+		assert.Equal(t, "k8s-agentpool1-12345678-0", *vms[0].Name)
+	}
+
+	c.NameSuffix = "87654321"
+	if vms, err = c.ListClusterVMs(context.Background()); err != nil {
+		t.Fatalf("ListClusterVMs failed: %+v", err)
+	}
+	assert.Empty(t, vms, "VMs of other clusters should be skipped")
+
+	c.Client = &armhelpers.MockACSEngineClient{FailListVirtualMachines: true}
+	if _, err = c.ListClusterVMs(context.Background()); err == nil {
+		t.Fatalf("ListClusterVMs should have failed")
+	}
+}
+
+func TestNodeVersions(t *testing.T) {
+	c := ACSEngineClient{
+		Client:            &armhelpers.MockACSEngineClient{},
+		ResourceGroupName: "rg",
+		NameSuffix:        "12345678",
+	}
+
+	versions, err := c.NodeVersions(context.Background())
+	if err != nil {
+		t.Fatalf("NodeVersions failed: %+v", err)
+	}
+	assert.Equal(t, map[string][]string{"agentpool1": {"1.6.9"}}, versions)
+
+	c.NameSuffix = "87654321"
+	if versions, err = c.NodeVersions(context.Background()); err != nil {
+		t.Fatalf("NodeVersions failed: %+v", err)
+	}
+	assert.Empty(t, versions, "VMs of other clusters should be skipped")
+
+	c.Client = &armhelpers.MockACSEngineClient{FailListVirtualMachines: true}
+	if _, err = c.NodeVersions(context.Background()); err == nil {
+		t.Fatalf("NodeVersions should have failed")
+	}
+}
+
+func TestNodeTags(t *testing.T) {
+	c := ACSEngineClient{
+		Client:            &armhelpers.MockACSEngineClient{},
+		ResourceGroupName: "rg",
+		NameSuffix:        "12345678",
+	}
+
+	values, err := c.NodeTags(context.Background(), "orchestrator")
+	if err != nil {
+		t.Fatalf("NodeTags failed: %+v", err)
+	}
+	assert.Equal(t, []string{"Kubernetes:1.6.9"}, values)
+
+	if values, err = c.NodeTags(context.Background(), "certificateFingerprint"); err != nil {
+		t.Fatalf("NodeTags failed: %+v", err)
+	}
+	assert.Equal(t, []string{""}, values, "VMs without the tag should have an empty value")
+
+	c.Client = &armhelpers.MockACSEngineClient{FailListVirtualMachines: true}
+	if _, err = c.NodeTags(context.Background(), "orchestrator"); err == nil {
+		t.Fatalf("NodeTags should have failed")
+	}
+}
+
+func TestOrchestratorVersion(t *testing.T) {
+	tagged, untagged := "Kubernetes:1.10.3", "Kubernetes"
+	cases := []struct {
+		Orchestrator *string
+		Expected     string
+		ExpectOK     bool
+	}{
+		{
+			Orchestrator: &tagged,
+			Expected:     "1.10.3",
+			ExpectOK:     true,
+		},
+		{
+			Orchestrator: &untagged,
+			ExpectOK:     false,
+		},
+		{
+			Orchestrator: nil,
+			ExpectOK:     false,
+		},
+	}
+
+	for _, tc := range cases {
+		version, ok := orchestratorVersion(map[string]*string{"orchestrator": tc.Orchestrator})
+		assert.Equal(t, tc.ExpectOK, ok)
+		assert.Equal(t, tc.Expected, version)
+	}
+}
+
+func TestClusterPoolName(t *testing.T) {
+	pool, suffix, windowsSuffix, otherSuffix, partialSuffix, emptySuffix := "AgentPool1", "12345678", "12345", "87654321", "1234", ""
+	cases := []struct {
+		Tags     map[string]*string
+		Expected string
+		Found    bool
+	}{
+		{
+			Tags:     map[string]*string{"poolName": &pool, "resourceNameSuffix": &suffix},
+			Expected: "agentpool1",
+			Found:    true,
+		},
+		{
+			Tags:     map[string]*string{"poolName": &pool, "resourceNameSuffix": &windowsSuffix},
+			Expected: "agentpool1",
+			Found:    true,
+		},
+		{
+			Tags:  map[string]*string{"poolName": &pool, "resourceNameSuffix": &otherSuffix},
+			Found: false,
+		},
+		{
+			Tags:  map[string]*string{"poolName": &pool, "resourceNameSuffix": &partialSuffix},
+			Found: false,
+		},
+		{
+			Tags:  map[string]*string{"poolName": &pool, "resourceNameSuffix": &emptySuffix},
+			Found: false,
+		},
+		{
+			Tags:  map[string]*string{},
+			Found: false,
+		},
+	}
+
+	for _, tc := range cases {
+		name, ok := ClusterPoolName(tc.Tags, suffix)
+		assert.Equal(t, tc.Found, ok)
+		assert.Equal(t, tc.Expected, name)
+	}
+}
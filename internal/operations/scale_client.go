@@ -3,13 +3,16 @@ package operations
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/Azure/acs-engine/pkg/api"
 	"github.com/Azure/acs-engine/pkg/armhelpers/utils"
 	"github.com/Azure/acs-engine/pkg/operations"
 	"github.com/Azure/terraform-provider-acsengine/internal/resource"
+	"github.com/hashicorp/go-multierror"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -23,6 +26,16 @@ type ScaleClient struct {
 	AgentPool         *api.AgentPoolProfile
 	AgentPoolIndex    int
 	DeploymentName    string
+
+	// MaxSurge limits how many nodes are added above the desired count while nodes are replaced
+	MaxSurge int
+	// MaxUnavailable limits how many nodes are removed below the desired count while nodes are replaced, and how
+	// many nodes are drained at once, which is at least one
+	MaxUnavailable int
+
+	// AgentPoolVersion is the Kubernetes version the agent pool's nodes are deployed with if it isn't the control
+	// plane version
+	AgentPoolVersion string
 }
 
 // NewScaleClient returns a new ScaleClient
@@ -34,10 +47,10 @@ func NewScaleClient(secret string) *ScaleClient {
 }
 
 // SetScaleClient sets values in acsengine scale client
-func (sc *ScaleClient) SetScaleClient(cluster *api.ContainerService, azureID string, agentIndex, agentCount int) error {
+func (sc *ScaleClient) SetScaleClient(ctx context.Context, cluster *api.ContainerService, azureID string, agentIndex, agentCount int) error {
 	var err error
 
-	err = sc.ACSEngineClient.SetACSEngineClient(cluster, azureID)
+	err = sc.ACSEngineClient.SetACSEngineClient(ctx, cluster, azureID)
 	if err != nil {
 		return fmt.Errorf("failed to initialize ACSEngineClient: %+v", err)
 	}
@@ -81,11 +94,7 @@ func (sc *ScaleClient) ScaleVMAS(ctx context.Context) (int, int, int, []string,
 		return highestUsedIndex, currentNodeCount, windowsIndex, indexToVM, fmt.Errorf("The provided resource group does not contain any vms")
 	}
 	for _, vm := range vms.Values() {
-		vmTags := vm.Tags
-		poolName := *vmTags["poolName"]
-		nameSuf := *vmTags["resourceNameSuffix"]
-
-		if err != nil || !strings.EqualFold(poolName, sc.AgentPoolToScale) || !strings.Contains(sc.NameSuffix, nameSuf) {
+		if !sc.isAgentPoolResource(vm.Tags) {
 			continue
 		}
 
@@ -119,11 +128,7 @@ func (sc *ScaleClient) ScaleVMSS(ctx context.Context) (int, int, int, error) {
 		return highestUsedIndex, currentNodeCount, windowsIndex, fmt.Errorf("failed to get vmss list in the resource group: %+v", err)
 	}
 	for _, vmss := range vmssList.Values() {
-		vmTags := vmss.Tags
-		poolName := *vmTags["poolName"]
-		nameSuffix := *vmTags["resourceNameSuffix"]
-
-		if err != nil || !strings.EqualFold(poolName, sc.AgentPoolToScale) || !strings.Contains(sc.NameSuffix, nameSuffix) {
+		if !sc.isAgentPoolResource(vmss.Tags) {
 			continue
 		}
 
@@ -140,35 +145,236 @@ func (sc *ScaleClient) ScaleVMSS(ctx context.Context) (int, int, int, error) {
 	return highestUsedIndex, currentNodeCount, windowsIndex, nil
 }
 
-// DrainNodes drains and deletes all nodes in array provided
-func (sc *ScaleClient) DrainNodes(kubeConfig string, vmsToDelete []string) error {
+// ScaleSetVM is a VM in one of an agent pool's scale sets
+type ScaleSetVM struct {
+	ScaleSet   string
+	InstanceID string
+	NodeName   string
+	VMSize     string
+	// false for VMs created before the scale set's model was last updated
+	LatestModelApplied bool
+}
+
+// AgentPoolScaleSets returns the names of the agent pool's scale sets and the names of their nodes
+func (sc *ScaleClient) AgentPoolScaleSets(ctx context.Context) ([]string, []string, error) {
+	scaleSets, vms, err := sc.agentPoolScaleSetVMs(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	nodes := []string{}
+	for _, vm := range vms {
+		nodes = append(nodes, vm.NodeName)
+	}
+
+	return scaleSets, nodes, nil
+}
+
+// AgentPoolScaleSetVMs returns the VMs in the agent pool's scale sets
+func (sc *ScaleClient) AgentPoolScaleSetVMs(ctx context.Context) ([]ScaleSetVM, error) {
+	_, vms, err := sc.agentPoolScaleSetVMs(ctx)
+	return vms, err
+}
+
+func (sc *ScaleClient) agentPoolScaleSetVMs(ctx context.Context) ([]string, []ScaleSetVM, error) {
+	scaleSets, vms := []string{}, []ScaleSetVM{}
+	vmssList, err := sc.Client.ListVirtualMachineScaleSets(ctx, sc.ResourceGroupName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get vmss list in the resource group: %+v", err)
+	}
+	for vmssList.NotDone() {
+		for _, vmss := range vmssList.Values() {
+			if vmss.Name == nil || !sc.isAgentPoolResource(vmss.Tags) {
+				continue
+			}
+			scaleSets = append(scaleSets, *vmss.Name)
+
+			vmList, err := sc.Client.ListVirtualMachineScaleSetVMs(ctx, sc.ResourceGroupName, *vmss.Name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to get VMs in scale set %q: %+v", *vmss.Name, err)
+			}
+			for vmList.NotDone() {
+				for _, vm := range vmList.Values() {
+					if vm.InstanceID == nil || vm.VirtualMachineScaleSetVMProperties == nil || vm.OsProfile == nil || vm.OsProfile.ComputerName == nil {
+						continue
+					}
+					scaleSetVM := ScaleSetVM{
+						ScaleSet:   *vmss.Name,
+						InstanceID: *vm.InstanceID,
+						NodeName:   *vm.OsProfile.ComputerName,
+					}
+					if vm.Sku != nil && vm.Sku.Name != nil {
+						scaleSetVM.VMSize = *vm.Sku.Name
+					}
+					if vm.LatestModelApplied != nil {
+						scaleSetVM.LatestModelApplied = *vm.LatestModelApplied
+					}
+					vms = append(vms, scaleSetVM)
+				}
+				if err = vmList.Next(); err != nil {
+					return nil, nil, fmt.Errorf("failed to get VMs in scale set %q: %+v", *vmss.Name, err)
+				}
+			}
+		}
+		if err = vmssList.Next(); err != nil {
+			return nil, nil, fmt.Errorf("failed to get vmss list in the resource group: %+v", err)
+		}
+	}
+
+	return scaleSets, vms, nil
+}
+
+// AvailabilitySetVMSizes returns the sizes of the agent pool's availability set VMs by VM name
+func (sc *ScaleClient) AvailabilitySetVMSizes(ctx context.Context) (map[string]string, error) {
+	sizes := map[string]string{}
+	vmList, err := sc.Client.ListVirtualMachines(ctx, sc.ResourceGroupName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vms in the resource group: %+v", err)
+	}
+	for vmList.NotDone() {
+		for _, vm := range vmList.Values() {
+			if vm.Name == nil || !sc.isAgentPoolResource(vm.Tags) {
+				continue
+			}
+			sizes[*vm.Name] = ""
+			if vm.VirtualMachineProperties != nil && vm.HardwareProfile != nil {
+				sizes[*vm.Name] = string(vm.HardwareProfile.VMSize)
+			}
+		}
+		if err = vmList.Next(); err != nil {
+			return nil, fmt.Errorf("failed to get vms in the resource group: %+v", err)
+		}
+	}
+
+	return sizes, nil
+}
+
+// AvailabilitySetVMTags returns the value of a tag on the agent pool's availability set VMs by VM name, which is
+// empty for VMs without the tag
+func (sc *ScaleClient) AvailabilitySetVMTags(ctx context.Context, tag string) (map[string]string, error) {
+	tags := map[string]string{}
+	vmList, err := sc.Client.ListVirtualMachines(ctx, sc.ResourceGroupName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vms in the resource group: %+v", err)
+	}
+	for vmList.NotDone() {
+		for _, vm := range vmList.Values() {
+			if vm.Name == nil || !sc.isAgentPoolResource(vm.Tags) {
+				continue
+			}
+			tags[*vm.Name] = ""
+			if value := vm.Tags[tag]; value != nil {
+				tags[*vm.Name] = *value
+			}
+		}
+		if err = vmList.Next(); err != nil {
+			return nil, fmt.Errorf("failed to get vms in the resource group: %+v", err)
+		}
+	}
+
+	return tags, nil
+}
+
+// NodeCount returns the number of VMs in the agent pool
+func (sc *ScaleClient) NodeCount(ctx context.Context) (int, error) {
+	if sc.AgentPool.IsAvailabilitySets() {
+		sizes, err := sc.AvailabilitySetVMSizes(ctx)
+		return len(sizes), err
+	}
+	vms, err := sc.AgentPoolScaleSetVMs(ctx)
+	return len(vms), err
+}
+
+// ScaleSetVMsToDelete picks the VMs to remove to get down to the desired count, highest instance IDs first
+// so the same VMs are chosen if a scale down is retried
+func ScaleSetVMsToDelete(vms []ScaleSetVM, desiredCount int) ([]ScaleSetVM, error) {
+	if desiredCount < 0 {
+		return nil, fmt.Errorf("desired count %d can't be negative", desiredCount)
+	}
+	instanceIDs := map[ScaleSetVM]int{}
+	for _, vm := range vms {
+		id, err := strconv.Atoi(vm.InstanceID)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing instance ID %q of scale set %q: %+v", vm.InstanceID, vm.ScaleSet, err)
+		}
+		instanceIDs[vm] = id
+	}
+
+	sorted := make([]ScaleSetVM, len(vms))
+	copy(sorted, vms)
+	sort.Slice(sorted, func(i, j int) bool {
+		if instanceIDs[sorted[i]] != instanceIDs[sorted[j]] {
+			return instanceIDs[sorted[i]] > instanceIDs[sorted[j]]
+		}
+		return sorted[i].ScaleSet > sorted[j].ScaleSet
+	})
+
+	if len(sorted) <= desiredCount {
+		return []ScaleSetVM{}, nil
+	}
+	return sorted[:len(sorted)-desiredCount], nil
+}
+
+// isAgentPoolResource checks the tags acs-engine puts on agent pool VMs and scale sets
+func (sc *ScaleClient) isAgentPoolResource(tags map[string]*string) bool {
+	poolName, ok := ClusterPoolName(tags, sc.NameSuffix)
+	return ok && strings.EqualFold(poolName, sc.AgentPoolToScale)
+}
+
+// DrainNodes drains the nodes at most MaxUnavailable at a time, giving each node the time left before the context's
+// deadline. It returns the nodes that were drained, in the order they were given, along with an error listing every
+// node that wasn't. Nodes that haven't started draining when the context is done aren't drained
+func (sc *ScaleClient) DrainNodes(ctx context.Context, kubeConfig string, nodes []string) ([]string, error) {
 	masterURL := sc.MasterFQDN
 	if !strings.HasPrefix(masterURL, "https://") {
 		masterURL = fmt.Sprintf("https://%s", masterURL)
 	}
-	numVmsToDrain := len(vmsToDelete)
-	errChan := make(chan *operations.VMScalingErrorDetails, numVmsToDrain)
-	defer close(errChan)
-	for _, vmName := range vmsToDelete {
-		go func(vmName string) {
-			err := operations.SafelyDrainNode(sc.Client, sc.Logger,
-				masterURL, kubeConfig, vmName, time.Duration(60)*time.Minute) // is the vmName the node name?
-			if err != nil {
-				log.Errorf("Failed to drain node %s, got error %v", vmName, err)
-				errChan <- &operations.VMScalingErrorDetails{Error: err, Name: vmName}
-				return
+	workers := 1
+	if sc.MaxUnavailable > workers {
+		workers = sc.MaxUnavailable
+	}
+
+	queue := make(chan string)
+	var mu sync.Mutex
+	errs := map[string]error{}
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(nodes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for node := range queue {
+				err := sc.drainNode(ctx, masterURL, kubeConfig, node)
+				mu.Lock()
+				errs[node] = err
+				mu.Unlock()
 			}
-			errChan <- nil
-		}(vmName)
+		}()
+	}
+	for _, node := range nodes {
+		queue <- node
 	}
+	close(queue)
+	wg.Wait()
 
-	for i := 0; i < numVmsToDrain; i++ {
-		errDetails := <-errChan
-		if errDetails != nil {
-			return fmt.Errorf("Node %q failed to drain with error: %v", errDetails.Name, errDetails.Error) // failing here w/ upgrade then scale down
+	drained := []string{}
+	var result *multierror.Error
+	for _, node := range nodes {
+		if err := errs[node]; err != nil {
+			result = multierror.Append(result, fmt.Errorf("node %q failed to drain: %+v", node, err))
+			continue
 		}
+		drained = append(drained, node)
 	}
+	return drained, result.ErrorOrNil()
+}
 
+func (sc *ScaleClient) drainNode(ctx context.Context, masterURL, kubeConfig, node string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := operations.SafelyDrainNode(sc.Client, sc.Logger, masterURL, kubeConfig, node, TimeRemaining(ctx)); err != nil {
+		log.Errorf("Failed to drain node %s, got error %v", node, err)
+		return err
+	}
 	return nil
 }
 
@@ -1,12 +1,19 @@
 package operations
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/Azure/acs-engine/pkg/armhelpers"
 	"github.com/Azure/terraform-provider-acsengine/internal/tester"
+	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
 )
 
 func TestSetScaleClient(t *testing.T) {
@@ -18,7 +25,7 @@ func TestSetScaleClient(t *testing.T) {
 	agentIndex := 0
 	desiredAgentCount := 2
 	sc := NewScaleClient(os.Getenv("ARM_CLIENT_SECRET"))
-	if err := sc.SetScaleClient(cluster, id, agentIndex, desiredAgentCount); err != nil {
+	if err := sc.SetScaleClient(context.Background(), cluster, id, agentIndex, desiredAgentCount); err != nil {
 		t.Fatalf("setScaleClient failed: %+v", err)
 	}
 
@@ -70,3 +77,352 @@ func TestScaleValidate(t *testing.T) {
 		}
 	}
 }
+
+func TestAgentPoolScaleSets(t *testing.T) {
+	sc := ScaleClient{
+		ACSEngineClient: ACSEngineClient{
+			Client:            &armhelpers.MockACSEngineClient{},
+			ResourceGroupName: "rg",
+			NameSuffix:        "12345678",
+		},
+		AgentPoolToScale: "agentpool2",
+	}
+
+	scaleSets, nodes, err := sc.AgentPoolScaleSets(context.Background())
+	if err != nil {
+		t.Fatalf("AgentPoolScaleSets failed: %+v", err)
+	}
+	assert.Empty(t, scaleSets, "there should be no scale sets")
+	assert.Empty(t, nodes, "there should be no nodes")
+
+	sc.Client = &armhelpers.MockACSEngineClient{FailListVirtualMachineScaleSets: true}
+	if _, _, err = sc.AgentPoolScaleSets(context.Background()); err == nil {
+		t.Fatalf("AgentPoolScaleSets should have failed")
+	}
+}
+
+func TestAgentPoolScaleSetVMs(t *testing.T) {
+	sc := ScaleClient{
+		ACSEngineClient: ACSEngineClient{
+			Client:            &armhelpers.MockACSEngineClient{},
+			ResourceGroupName: "rg",
+			NameSuffix:        "12345678",
+		},
+		AgentPoolToScale: "agentpool2",
+	}
+
+	vms, err := sc.AgentPoolScaleSetVMs(context.Background())
+	if err != nil {
+		t.Fatalf("AgentPoolScaleSetVMs failed: %+v", err)
+	}
+	assert.Empty(t, vms, "there should be no VMs")
+
+	sc.Client = &armhelpers.MockACSEngineClient{FailListVirtualMachineScaleSets: true}
+	if _, err = sc.AgentPoolScaleSetVMs(context.Background()); err == nil {
+		t.Fatalf("AgentPoolScaleSetVMs should have failed")
+	}
+}
+
+func TestAvailabilitySetVMSizes(t *testing.T) {
+	sc := ScaleClient{
+		ACSEngineClient: ACSEngineClient{
+			Client:            &armhelpers.MockACSEngineClient{},
+			ResourceGroupName: "rg",
+			NameSuffix:        "12345678",
+		},
+		AgentPoolToScale: "agentpool1",
+	}
+
+	sizes, err := sc.AvailabilitySetVMSizes(context.Background())
+	if err != nil {
+		t.Fatalf("AvailabilitySetVMSizes failed: %+v", err)
+	}
+	assert.Equal(t, map[string]string{"k8s-agentpool1-12345678-0": ""}, sizes)
+
+	sc.AgentPoolToScale = "agentpool2"
+	if sizes, err = sc.AvailabilitySetVMSizes(context.Background()); err != nil {
+		t.Fatalf("AvailabilitySetVMSizes failed: %+v", err)
+	}
+	assert.Empty(t, sizes, "there should be no VMs in agentpool2")
+
+	sc.Client = &armhelpers.MockACSEngineClient{FailListVirtualMachines: true}
+	if _, err = sc.AvailabilitySetVMSizes(context.Background()); err == nil {
+		t.Fatalf("AvailabilitySetVMSizes should have failed")
+	}
+}
+
+func TestAvailabilitySetVMTags(t *testing.T) {
+	sc := ScaleClient{
+		ACSEngineClient: ACSEngineClient{
+			Client:            &armhelpers.MockACSEngineClient{},
+			ResourceGroupName: "rg",
+			NameSuffix:        "12345678",
+		},
+		AgentPoolToScale: "agentpool1",
+	}
+
+	tags, err := sc.AvailabilitySetVMTags(context.Background(), "orchestrator")
+	if err != nil {
+		t.Fatalf("AvailabilitySetVMTags failed: %+v", err)
+	}
+	assert.Equal(t, map[string]string{"k8s-agentpool1-12345678-0": "Kubernetes:1.6.9"}, tags)
+
+	if tags, err = sc.AvailabilitySetVMTags(context.Background(), "missing"); err != nil {
+		t.Fatalf("AvailabilitySetVMTags failed: %+v", err)
+	}
+	assert.Equal(t, map[string]string{"k8s-agentpool1-12345678-0": ""}, tags, "VMs without the tag should have an empty value")
+
+	sc.Client = &armhelpers.MockACSEngineClient{FailListVirtualMachines: true}
+	if _, err = sc.AvailabilitySetVMTags(context.Background(), "orchestrator"); err == nil {
+		t.Fatalf("AvailabilitySetVMTags should have failed")
+	}
+}
+
+func TestNodeCount(t *testing.T) {
+	sc := ScaleClient{
+		ACSEngineClient: ACSEngineClient{
+			Client:            &armhelpers.MockACSEngineClient{},
+			ResourceGroupName: "rg",
+			NameSuffix:        "12345678",
+		},
+		AgentPoolToScale: "agentpool1",
+		AgentPool:        &api.AgentPoolProfile{Name: "agentpool1", AvailabilityProfile: api.AvailabilitySet},
+	}
+
+	count, err := sc.NodeCount(context.Background())
+	if err != nil {
+		t.Fatalf("NodeCount failed: %+v", err)
+	}
+	assert.Equal(t, 1, count)
+
+	sc.AgentPool.AvailabilityProfile = api.VirtualMachineScaleSets
+	if count, err = sc.NodeCount(context.Background()); err != nil {
+		t.Fatalf("NodeCount failed: %+v", err)
+	}
+	assert.Equal(t, 0, count, "there should be no scale set VMs")
+}
+
+func TestScaleSetVMsToDelete(t *testing.T) {
+	vms := []ScaleSetVM{
+		{ScaleSet: "k8s-agentpool2-12345678-vmss", InstanceID: "2", NodeName: "k8s-agentpool2-12345678-vmss000002"},
+		{ScaleSet: "k8s-agentpool2-12345678-vmss", InstanceID: "10", NodeName: "k8s-agentpool2-12345678-vmss00000a"},
+		{ScaleSet: "k8s-agentpool2-12345678-vmss", InstanceID: "0", NodeName: "k8s-agentpool2-12345678-vmss000000"},
+		{ScaleSet: "k8s-agentpool2-12345678-vmss", InstanceID: "5", NodeName: "k8s-agentpool2-12345678-vmss000005"},
+	}
+
+	cases := []struct {
+		VMs          []ScaleSetVM
+		DesiredCount int
+		Expected     []string
+		ExpectError  bool
+	}{
+		{
+			VMs:          vms,
+			DesiredCount: 2,
+			Expected:     []string{"10", "5"},
+		},
+		{
+			VMs:          vms,
+			DesiredCount: 0,
+			Expected:     []string{"10", "5", "2", "0"},
+		},
+		{
+			VMs:          vms,
+			DesiredCount: 4,
+			Expected:     []string{},
+		},
+		{
+			VMs:          vms,
+			DesiredCount: 6,
+			Expected:     []string{},
+		},
+		{
+			VMs:          vms,
+			DesiredCount: -1,
+			ExpectError:  true,
+		},
+		{
+			VMs:          []ScaleSetVM{{ScaleSet: "k8s-agentpool2-12345678-vmss", InstanceID: "abc"}},
+			DesiredCount: 0,
+			ExpectError:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		vmsToDelete, err := ScaleSetVMsToDelete(tc.VMs, tc.DesiredCount)
+		if tc.ExpectError {
+			assert.NotNil(t, err, "expected an error for desired count %d", tc.DesiredCount)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ScaleSetVMsToDelete failed: %+v", err)
+		}
+		instanceIDs := []string{}
+		for _, vm := range vmsToDelete {
+			instanceIDs = append(instanceIDs, vm.InstanceID)
+		}
+		assert.Equal(t, tc.Expected, instanceIDs)
+	}
+	assert.Equal(t, "2", vms[0].InstanceID, "the VMs passed in shouldn't be reordered")
+}
+
+func TestIsAgentPoolResource(t *testing.T) {
+	poolName, otherPoolName, nameSuffix := "agentpool2", "agentpool1", "12345678"
+	sc := ScaleClient{
+		ACSEngineClient: ACSEngineClient{
+			NameSuffix: "12345678",
+		},
+		AgentPoolToScale: "agentpool2",
+	}
+
+	cases := []struct {
+		Tags     map[string]*string
+		Expected bool
+	}{
+		{
+			Tags:     map[string]*string{"poolName": &poolName, "resourceNameSuffix": &nameSuffix},
+			Expected: true,
+		},
+		{
+			Tags:     map[string]*string{"poolName": &otherPoolName, "resourceNameSuffix": &nameSuffix},
+			Expected: false,
+		},
+		{
+			Tags:     map[string]*string{"poolName": &poolName},
+			Expected: false,
+		},
+		{
+			Tags:     nil,
+			Expected: false,
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.Expected, sc.isAgentPoolResource(tc.Tags))
+	}
+}
+
+func mockDrainScaleClient(kubernetesClient *armhelpers.MockKubernetesClient, maxUnavailable int) *ScaleClient {
+	return &ScaleClient{
+		ACSEngineClient: ACSEngineClient{
+			Client: &armhelpers.MockACSEngineClient{MockKubernetesClient: kubernetesClient},
+			Logger: log.New().WithField("source", "scaling update"),
+		},
+		MasterFQDN:     "dnsprefix.southcentralus.cloudapp.azure.com",
+		MaxUnavailable: maxUnavailable,
+	}
+}
+
+func TestDrainNodes(t *testing.T) {
+	nodes := []string{"k8s-agentpool1-12345678-0", "k8s-agentpool1-12345678-1", "k8s-agentpool1-12345678-2"}
+	cases := []struct {
+		KubernetesClient *armhelpers.MockKubernetesClient
+		Drained          []string
+		ExpectError      bool
+	}{
+		{
+			KubernetesClient: &armhelpers.MockKubernetesClient{},
+			Drained:          nodes,
+			ExpectError:      false,
+		},
+		{
+			KubernetesClient: &armhelpers.MockKubernetesClient{FailGetNode: true},
+			Drained:          []string{},
+			ExpectError:      true,
+		},
+		{
+			KubernetesClient: &armhelpers.MockKubernetesClient{FailListPods: true},
+			Drained:          []string{},
+			ExpectError:      true,
+		},
+	}
+
+	for _, tc := range cases {
+		sc := mockDrainScaleClient(tc.KubernetesClient, 2)
+		drained, err := sc.DrainNodes(context.Background(), "kubeconfig", nodes)
+		assert.Equal(t, tc.Drained, drained)
+		if tc.ExpectError {
+			if err == nil {
+				t.Fatalf("DrainNodes should have failed")
+			}
+			for _, node := range nodes {
+				assert.Contains(t, err.Error(), node, "every node that failed to drain should be reported")
+			}
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+}
+
+func TestDrainNodesPartialFailure(t *testing.T) {
+	nodes := []string{"k8s-agentpool1-12345678-0", "k8s-agentpool1-12345678-1", "k8s-agentpool1-12345678-2"}
+	var mu sync.Mutex
+	cordoned := 0
+	kubernetesClient := &armhelpers.MockKubernetesClient{
+		UpdateNodeFunc: func(node *v1.Node) (*v1.Node, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			cordoned++
+			if cordoned == 2 {
+				return nil, fmt.Errorf("UpdateNode failed")
+			}
+			return node, nil
+		},
+	}
+
+	sc := mockDrainScaleClient(kubernetesClient, 1)
+	drained, err := sc.DrainNodes(context.Background(), "kubeconfig", nodes)
+	if err == nil {
+		t.Fatalf("DrainNodes should have failed")
+	}
+	assert.Equal(t, []string{nodes[0], nodes[2]}, drained, "nodes after the failed node should still be drained")
+	assert.Contains(t, err.Error(), nodes[1])
+	assert.NotContains(t, err.Error(), nodes[0])
+}
+
+func TestDrainNodesCanceled(t *testing.T) {
+	nodes := []string{"k8s-agentpool1-12345678-0", "k8s-agentpool1-12345678-1"}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sc := mockDrainScaleClient(&armhelpers.MockKubernetesClient{}, 1)
+	drained, err := sc.DrainNodes(ctx, "kubeconfig", nodes)
+	if err == nil {
+		t.Fatalf("DrainNodes should have failed with a canceled context")
+	}
+	assert.Empty(t, drained, "no nodes should be drained after the context is canceled")
+}
+
+func TestDrainNodesConcurrency(t *testing.T) {
+	nodes := []string{}
+	for i := 0; i < 6; i++ {
+		nodes = append(nodes, fmt.Sprintf("k8s-agentpool1-12345678-%d", i))
+	}
+	maxUnavailable := 2
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	kubernetesClient := &armhelpers.MockKubernetesClient{
+		UpdateNodeFunc: func(node *v1.Node) (*v1.Node, error) {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			return node, nil
+		},
+	}
+
+	sc := mockDrainScaleClient(kubernetesClient, maxUnavailable)
+	drained, err := sc.DrainNodes(context.Background(), "kubeconfig", nodes)
+	if err != nil {
+		t.Fatalf("DrainNodes failed: %+v", err)
+	}
+	assert.Equal(t, nodes, drained)
+	assert.True(t, maxInFlight <= maxUnavailable, "%d nodes were drained at once, more than %d", maxInFlight, maxUnavailable)
+	assert.True(t, maxInFlight > 1, "nodes should be drained in parallel")
+}
@@ -1,7 +1,9 @@
 package operations
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Azure/acs-engine/pkg/api"
@@ -27,8 +29,8 @@ func NewUpgradeClient(secret string) *UpgradeClient {
 }
 
 // SetUpgradeClient sets acs-engine upgrade client fields
-func (uc *UpgradeClient) SetUpgradeClient(cluster *api.ContainerService, azureID, upgradeVersion string) error {
-	if err := uc.ACSEngineClient.SetACSEngineClient(cluster, azureID); err != nil {
+func (uc *UpgradeClient) SetUpgradeClient(ctx context.Context, cluster *api.ContainerService, azureID, upgradeVersion string) error {
+	if err := uc.ACSEngineClient.SetACSEngineClient(ctx, cluster, azureID); err != nil {
 		return fmt.Errorf("failed to initialize ACSEngineClient: %+v", err)
 	}
 
@@ -48,6 +50,33 @@ func (uc *UpgradeClient) SetUpgradeClient(cluster *api.ContainerService, azureID
 	return nil
 }
 
+// SetTimeout limits each upgrade step to the time left before the context's deadline
+func (uc *UpgradeClient) SetTimeout(ctx context.Context) {
+	timeout := TimeRemaining(ctx)
+	uc.TimeoutInMinutes = int(timeout.Minutes())
+	uc.Timeout = &timeout
+}
+
+// SetAgentPoolsToUpgrade limits the upgrade to the named agent pools, so an empty list only upgrades the masters
+func (uc *UpgradeClient) SetAgentPoolsToUpgrade(agentPools []string) error {
+	uc.AgentPoolsToUpgrade = []string{}
+	for _, name := range agentPools {
+		found := false
+		for _, agentPool := range uc.Cluster.Properties.AgentPoolProfiles {
+			if strings.EqualFold(agentPool.Name, name) {
+				uc.AgentPoolsToUpgrade = append(uc.AgentPoolsToUpgrade, agentPool.Name)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("agent pool %q not found in the api model", name)
+		}
+	}
+
+	return nil
+}
+
 // Validate checks that required client fields are set
 func (uc *UpgradeClient) Validate() error {
 	uc.Logger = log.New().WithField("source", "upgrade update")
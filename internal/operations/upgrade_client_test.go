@@ -1,10 +1,15 @@
 package operations
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/Azure/acs-engine/pkg/api"
+	"github.com/Azure/acs-engine/pkg/armhelpers"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
 	"github.com/Azure/terraform-provider-acsengine/internal/tester"
 	"github.com/stretchr/testify/assert"
 )
@@ -17,7 +22,7 @@ func TestACSEngineK8sCluster_setUpgradeClient(t *testing.T) {
 	upgradeVersion := "1.9.8"
 
 	uc := NewUpgradeClient(os.Getenv("ARM_CLIENT_SECRET"))
-	err := uc.SetUpgradeClient(cluster, id, upgradeVersion)
+	err := uc.SetUpgradeClient(context.Background(), cluster, id, upgradeVersion)
 	if err != nil {
 		t.Fatalf("setUpgradeClient failed: %+v", err)
 	}
@@ -27,6 +32,39 @@ func TestACSEngineK8sCluster_setUpgradeClient(t *testing.T) {
 	assert.Equal(t, uc.AuthArgs.SubscriptionID.String(), os.Getenv("ARM_SUBSCRIPTION_ID"), "Subscription ID is not set correctly")
 }
 
+func TestSetAgentPoolsToUpgrade(t *testing.T) {
+	uc := UpgradeClient{
+		ACSEngineClient: ACSEngineClient{
+			Cluster: tester.MockContainerService("clusterName", "southcentralus", "masterDNSPrefix"),
+		},
+	}
+	uc.Cluster.Properties.AgentPoolProfiles = []*api.AgentPoolProfile{{Name: "agentpool1"}, {Name: "agentpool2"}}
+
+	if err := uc.SetAgentPoolsToUpgrade([]string{"AgentPool2"}); err != nil {
+		t.Fatalf("SetAgentPoolsToUpgrade failed: %+v", err)
+	}
+	assert.Equal(t, []string{"agentpool2"}, uc.AgentPoolsToUpgrade)
+
+	if err := uc.SetAgentPoolsToUpgrade([]string{}); err != nil {
+		t.Fatalf("SetAgentPoolsToUpgrade failed: %+v", err)
+	}
+	assert.Empty(t, uc.AgentPoolsToUpgrade, "only the masters should be upgraded")
+
+	if err := uc.SetAgentPoolsToUpgrade([]string{"agentpool3"}); err == nil {
+		t.Fatalf("SetAgentPoolsToUpgrade should have failed for a pool that doesn't exist")
+	}
+}
+
+func TestUpgradeClientSetTimeout(t *testing.T) {
+	uc := UpgradeClient{}
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Minute)
+	defer cancel()
+
+	uc.SetTimeout(ctx)
+	assert.Equal(t, 89, uc.TimeoutInMinutes, "the timeout should be the time left before the deadline")
+	assert.True(t, *uc.Timeout > 89*time.Minute && *uc.Timeout <= 90*time.Minute)
+}
+
 func TestUpgradeValidate(t *testing.T) {
 	cases := []struct {
 		Client      UpgradeClient
@@ -69,3 +107,49 @@ func TestUpgradeValidate(t *testing.T) {
 		}
 	}
 }
+
+type mockMasterVMsClient struct {
+	armhelpers.MockACSEngineClient
+
+	vmNames []string
+}
+
+func (mc *mockMasterVMsClient) ListVirtualMachines(ctx context.Context, resourceGroup string) (armhelpers.VirtualMachineListResultPage, error) {
+	vms := []compute.VirtualMachine{}
+	for i := range mc.vmNames {
+		vms = append(vms, compute.VirtualMachine{Name: &mc.vmNames[i]})
+	}
+	return &armhelpers.MockVirtualMachineListResultPage{
+		Fn: func(lastResults compute.VirtualMachineListResult) (compute.VirtualMachineListResult, error) {
+			return compute.VirtualMachineListResult{}, nil
+		},
+		Vmlr: compute.VirtualMachineListResult{Value: &vms},
+	}, nil
+}
+
+func TestListMasterVMs(t *testing.T) {
+	uc := UpgradeClient{
+		ACSEngineClient: ACSEngineClient{
+			ResourceGroupName: "rg",
+			NameSuffix:        "12345678",
+			Client: &mockMasterVMsClient{
+				vmNames: []string{"k8s-master-12345678-0", "k8s-agentpool1-12345678-0", "k8s-master-87654321-0", "k8s-master-12345678-1"},
+			},
+		},
+	}
+
+	masterVMs, err := uc.ListMasterVMs(context.Background())
+	if err != nil {
+		t.Fatalf("ListMasterVMs failed: %+v", err)
+	}
+	names := []string{}
+	for _, vm := range masterVMs {
+		names = append(names, *vm.Name)
+	}
+	assert.Equal(t, []string{"k8s-master-12345678-0", "k8s-master-12345678-1"}, names)
+
+	uc.Client = &armhelpers.MockACSEngineClient{FailListVirtualMachines: true}
+	if _, err = uc.ListMasterVMs(context.Background()); err == nil {
+		t.Fatalf("ListMasterVMs should have failed")
+	}
+}